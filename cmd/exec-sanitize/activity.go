@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultHeartbeatMessage is the line -heartbeat writes if -heartbeat-message is not set
+const defaultHeartbeatMessage = "[exec-sanitize] still running"
+
+// activity tracks when the command last wrote any output, for -heartbeat and -idle-timeout
+type activity struct {
+	mu   sync.Mutex
+	last time.Time
+	// out is the stream heartbeats are written to
+	out io.Writer
+	// midLine is set if the last write to out did not end with a newline
+	midLine bool
+	done    chan struct{}
+	// timers tracks the goroutines of every, so that stop waits for them
+	timers sync.WaitGroup
+}
+
+func newActivity() *activity {
+	return &activity{last: time.Now(), done: make(chan struct{})}
+}
+
+// wrap returns writers that record the command's output on stdout and stderr. heartbeats are written to
+// stdout if heartbeatToStdout is set, and stderr otherwise
+func (a *activity) wrap(stdout, stderr io.Writer, heartbeatToStdout bool) (io.Writer, io.Writer) {
+	if heartbeatToStdout {
+		a.out = stdout
+	} else {
+		a.out = stderr
+	}
+
+	return &activityWriter{a: a, w: stdout, out: heartbeatToStdout}, &activityWriter{a: a, w: stderr, out: !heartbeatToStdout}
+}
+
+// every calls fn, with a locked, whenever the command has been silent for d since it last wrote or fn was last
+// called, until stop is called
+func (a *activity) every(d time.Duration, fn func()) {
+	a.timers.Add(1)
+	go func() {
+		defer a.timers.Done()
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		called := time.Now()
+		for {
+			select {
+			case <-t.C:
+			case <-a.done:
+				return
+			}
+
+			a.mu.Lock()
+			since := a.last
+			if called.After(since) {
+				since = called
+			}
+			wait := d - time.Since(since)
+			if wait <= 0 {
+				fn()
+				called, wait = time.Now(), d
+			}
+			a.mu.Unlock()
+			t.Reset(wait)
+		}
+	}()
+}
+
+// heartbeat writes message on a line of its own whenever the command has been silent for interval. the line is
+// written past the sanitizer, so rules never alter it
+func (a *activity) heartbeat(interval time.Duration, message string) {
+	if message == "" {
+		message = defaultHeartbeatMessage
+	}
+
+	a.every(interval, func() {
+		line := message + "\n"
+		if a.midLine {
+			line = "\n" + line
+		}
+		_, _ = fmt.Fprint(a.out, line)
+		a.midLine = false
+	})
+}
+
+// stop stops the timers of every, returning once none of them can write or call fn anymore
+func (a *activity) stop() {
+	close(a.done)
+	a.timers.Wait()
+}
+
+// activityWriter records the writes of a stream
+type activityWriter struct {
+	a *activity
+	w io.Writer
+	// out is set if heartbeats are written to this stream
+	out bool
+}
+
+func (aw *activityWriter) Write(p []byte) (int, error) {
+	aw.a.mu.Lock()
+	defer aw.a.mu.Unlock()
+
+	aw.a.last = time.Now()
+	if aw.out && len(p) > 0 {
+		aw.a.midLine = p[len(p)-1] != '\n'
+	}
+	return aw.w.Write(p)
+}
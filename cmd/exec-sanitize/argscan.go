@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// -check-args modes
+const (
+	checkArgsWarn   = "warn"
+	checkArgsRefuse = "refuse"
+)
+
+// argsCheck is the result of checking the command's arguments and environment against the rules
+type argsCheck struct {
+	// warnings describe the arguments that matched a rule, without their values
+	warnings []string
+	// rules replace the matched values wherever they show up in the output, such as in a shell's set -x trace
+	rules []*execsanitize.Rule
+}
+
+// checkArgs sanitizes the command's arguments and environment values with a copy of s, collecting the values
+// that the rules match
+func checkArgs(s *execsanitize.Sanitizer, args, env []string) argsCheck {
+	var (
+		check        argsCheck
+		values       = make(map[string]string)
+		replacements = make(map[string]string)
+	)
+	scan := newSanitizer(s)
+	record := func(where string, in string) []execsanitize.Match {
+		_, report := scan.SanitizeWithReport(in)
+		var matches []execsanitize.Match
+		for _, m := range report.Matches {
+			if m.Action != execsanitize.ActionReplace || m.Text == "" {
+				continue
+			}
+			name := fmt.Sprintf("%s:%s", where, m.Rule.Name)
+			values[name], replacements[name] = m.Text, m.Replacement
+			matches = append(matches, m)
+		}
+
+		return matches
+	}
+
+	for i, arg := range args {
+		for _, m := range record(fmt.Sprintf("arg%d", i+1), arg) {
+			check.warnings = append(check.warnings, fmt.Sprintf("argument %d matches rule %s", i+1, m.Rule.Name))
+		}
+	}
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		record("env:"+key, value)
+	}
+
+	check.rules = execsanitize.SecretRulesFunc("", values, func(name string) string {
+		return replacements[name]
+	})
+	return check
+}
+
+// report prints the warnings of a check, returning false if the command should not be run
+func (check argsCheck) report(mode string, stderr io.Writer) bool {
+	for _, warning := range check.warnings {
+		fmt.Fprintf(stderr, "%s\n", warning)
+	}
+	if mode == checkArgsRefuse && len(check.warnings) > 0 {
+		fmt.Fprintf(stderr, "refusing to pass secrets to the command as arguments\n")
+		return false
+	}
+
+	return true
+}
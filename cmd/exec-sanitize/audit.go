@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// auditRecord is the line -audit-file appends for each run
+type auditRecord struct {
+	Argv []string `json:"argv"`
+	// RulesSHA256 is the digest of the rules in force when the command started, see rulesDigest
+	RulesSHA256 string          `json:"rules_sha256"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	ExitCode    int             `json:"exit_code"`
+	Matches     int64           `json:"matches"`
+	Rules       []jsonRuleStats `json:"rules"`
+	// PrevSHA256 is the sha256 of the line before this one in the file, if any, so that removing or changing a
+	// record breaks the chain of the records after it
+	PrevSHA256 string `json:"prev_sha256"`
+	// HMACSHA256 is the HMAC-SHA256 of the line up to the field, closed with }, keyed with -hash-key-file
+	HMACSHA256 string `json:"hmac_sha256,omitempty"`
+}
+
+// newAuditRecord returns the record of a run, naming its rules with ruleID, see execsanitize.Sanitizer.RuleID
+func newAuditRecord(argv []string, rulesSHA256 string, st execsanitize.Stats, ruleID func(*execsanitize.Rule) string, start time.Time, exitCode int) *auditRecord {
+	record := &auditRecord{
+		Argv:        argv,
+		RulesSHA256: rulesSHA256,
+		Start:       start,
+		End:         time.Now(),
+		ExitCode:    exitCode,
+		Rules:       make([]jsonRuleStats, 0, len(st.Rules)),
+	}
+	for _, rs := range st.Rules {
+		record.Matches += rs.Matches
+		record.Rules = append(record.Rules, jsonRuleStats{Name: ruleID(rs.Rule), Matches: rs.Matches})
+	}
+
+	return record
+}
+
+// rulesDigest returns the hex sha256 of what defines rules: their names, patterns, replacements and options.
+// replacers are functions, and are only recorded as being set
+func rulesDigest(rules []*execsanitize.Rule) string {
+	h := sha256.New()
+	for _, rule := range rules {
+		var pattern, begin, end string
+		if rule.Pattern != nil {
+			pattern = rule.Pattern.String()
+		}
+		if rule.Region != nil {
+			begin, end = rule.Region.Begin.String(), rule.Region.End.String()
+		}
+		fmt.Fprintf(h, "%q %q %q %q %q %s %d %d %t %t %t %t %t %t\n",
+			rule.Name, pattern, rule.Replacement, begin, end, rule.Action, rule.Priority, rule.MaxReplacements,
+			rule.CollapseRuns, rule.FirstPerLine, rule.Replacer != nil, rule.MatchReplacer != nil,
+			rule.Validate != nil, rule.Verify != nil)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// appendAuditRecord appends a record to an -audit-file as a line of JSON, chained to the line before it and signed
+// with key if it is set. the file is only ever appended to
+func appendAuditRecord(path string, record *auditRecord, key []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	prev, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if len(prev) > 0 {
+		sum := sha256.Sum256(prev)
+		record.PrevSHA256 = hex.EncodeToString(sum[:])
+	}
+
+	record.HMACSHA256 = ""
+	line, err := json.Marshal(record)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if key != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(line)
+		sig := fmt.Sprintf(`,"hmac_sha256":%q}`, hex.EncodeToString(mac.Sum(nil)))
+		line = append(line[:len(line)-1], sig...)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// lastLine returns the last non-empty line of a file, reading it from the end
+func lastLine(f *os.File) ([]byte, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var tail []byte
+	for off := size; off > 0; {
+		n := min(off, 4096)
+		off -= n
+		chunk := make([]byte, n, int(n)+len(tail))
+		if _, err := f.ReadAt(chunk, off); err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+
+	return bytes.TrimRight(tail, "\n"), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is how often the -output-checkpoint file is written while output is being delivered
+const checkpointInterval = time.Second
+
+// checkpoint records how many lines of each stream the -output sinks delivered, so that a later attempt at running
+// the command, or a later run, skips them instead of sending them again
+type checkpoint struct {
+	path string
+
+	mu sync.Mutex
+	// delivered holds the number of lines delivered to each destination, by stream
+	delivered map[string]map[string]int64
+	saved     time.Time
+	dirty     bool
+}
+
+// checkpointFile is the format of the -output-checkpoint file
+type checkpointFile struct {
+	Outputs map[string]map[string]int64 `json:"outputs"`
+	Updated time.Time                   `json:"updated"`
+}
+
+// loadCheckpoint reads a checkpoint file, starting from nothing delivered if it does not exist
+func loadCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{path: path, delivered: make(map[string]map[string]int64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading -output-checkpoint: %w", err)
+	}
+
+	var f checkpointFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("reading -output-checkpoint %s: %w", path, err)
+	}
+	for dest, streams := range f.Outputs {
+		cp.delivered[dest] = streams
+	}
+
+	return cp, nil
+}
+
+// deliveredLines returns the number of lines of a stream delivered to a destination
+func (cp *checkpoint) deliveredLines(dest, stream string) int64 {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.delivered[dest][stream]
+}
+
+// advance records the delivery of the next line of a stream, writing the file if it was last written more than
+// checkpointInterval ago
+func (cp *checkpoint) advance(dest, stream string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cp.delivered[dest] == nil {
+		cp.delivered[dest] = make(map[string]int64)
+	}
+	cp.delivered[dest][stream]++
+	cp.dirty = true
+	if time.Since(cp.saved) < checkpointInterval {
+		return nil
+	}
+
+	return cp.save()
+}
+
+// flush writes the file if deliveries were recorded since it was last written
+func (cp *checkpoint) flush() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if !cp.dirty {
+		return nil
+	}
+	return cp.save()
+}
+
+// remove removes the file once the output was delivered in full, so that the next run starts from the beginning
+func (cp *checkpoint) remove() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.dirty = false
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// save replaces the file with the current counts, so that it is never left half written
+func (cp *checkpoint) save() error {
+	data, err := json.Marshal(checkpointFile{Outputs: cp.delivered, Updated: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), "."+filepath.Base(cp.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cp.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	cp.saved, cp.dirty = time.Now(), false
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// lockedWriter serializes writes to a writer shared by several streams
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.w.Write(p)
+}
+
+// prefixWriter prefixes every line written to it. each write is passed on in a single write, so that lines
+// written whole are not interleaved with other streams' lines
+type prefixWriter struct {
+	w io.Writer
+	// prefix returns the prefix of a line when its first byte is written
+	prefix func() string
+	// midLine is set if the last write did not end with a newline
+	midLine bool
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	var (
+		out  []byte
+		rest = p
+	)
+	for len(rest) > 0 {
+		if !pw.midLine {
+			out = append(out, pw.prefix()...)
+		}
+
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			out = append(out, rest...)
+			pw.midLine = true
+			break
+		}
+		out = append(out, rest[:i+1]...)
+		pw.midLine = false
+		rest = rest[i+1:]
+	}
+
+	if _, err := pw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// staticPrefix returns a constant line prefix
+func staticPrefix(prefix string) func() string {
+	return func() string {
+		return prefix
+	}
+}
+
+// prefixTimeFormat is how {{.Time}} is formatted in -prefix templates
+const prefixTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// linePrefix is the data of a -prefix template
+type linePrefix struct {
+	// Stream is stdout or stderr
+	Stream string
+	Time   prefixTime
+}
+
+// prefixTime formats as prefixTimeFormat, while its methods such as Format remain available to templates
+type prefixTime struct {
+	time.Time
+}
+
+func (t prefixTime) String() string {
+	return t.Format(prefixTimeFormat)
+}
+
+// parsePrefix parses a -prefix template
+func parsePrefix(text string) (*template.Template, error) {
+	tmpl, err := template.New("prefix").Parse(text)
+	if err == nil {
+		// catch references to fields that do not exist before any output is written
+		err = tmpl.Execute(io.Discard, linePrefix{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing -prefix: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// templatePrefix returns line prefixes for a stream rendered from a -prefix template
+func templatePrefix(tmpl *template.Template, stream string) func() string {
+	return func() string {
+		var b strings.Builder
+		_ = tmpl.Execute(&b, linePrefix{Stream: stream, Time: prefixTime{time.Now()}})
+		return b.String()
+	}
+}
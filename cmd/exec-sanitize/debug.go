@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// debugVars holds the counters served at /debug/vars under exec_sanitize
+type debugVars struct {
+	UptimeSeconds  float64          `json:"uptime_seconds"`
+	BytesProcessed int64            `json:"bytes_processed"`
+	BytesByStream  map[string]int64 `json:"bytes_by_stream"`
+	Rules          []jsonRuleStats  `json:"rules"`
+	Terminated     bool             `json:"terminated"`
+}
+
+// startDebugServer serves expvar counters at /debug/vars and net/http/pprof's profiles at /debug/pprof/ on addr,
+// until stop is called. the command line is left out of both, as it may hold the patterns of secrets, and rules are
+// named with the sanitizer's RuleID for the same reason
+func startDebugServer(addr string, s *execsanitize.Sanitizer) (listening string, stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("starting -debug-listen server: %w", err)
+	}
+
+	start := time.Now()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		st := s.Stats()
+		vars := debugVars{
+			UptimeSeconds:  time.Since(start).Seconds(),
+			BytesProcessed: st.BytesProcessed,
+			BytesByStream:  st.BytesByStream,
+			Rules:          make([]jsonRuleStats, 0, len(st.Rules)),
+			Terminated:     s.Terminated(),
+		}
+		for _, rs := range st.Rules {
+			vars.Rules = append(vars.Rules, jsonRuleStats{Name: s.RuleID(rs.Rule), Matches: rs.Matches})
+		}
+		writeDebugVars(w, vars)
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	return ln.Addr().String(), func() {
+		_ = srv.Close()
+	}, nil
+}
+
+// writeDebugVars writes the published expvar variables, such as memstats, along with exec-sanitize's own
+func writeDebugVars(w http.ResponseWriter, vars debugVars) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	own, _ := json.Marshal(vars)
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "exec_sanitize", own)
+}
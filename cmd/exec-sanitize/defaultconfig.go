@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// repoConfigFile is the name of the config looked for in the working directory and its parents
+const repoConfigFile = ".exec-sanitize.yaml"
+
+// defaultConfigs returns the configs that are loaded without -config, in the order their rules apply: the user's
+// exec-sanitize/config.yaml in the user config directory, such as $XDG_CONFIG_HOME, then the closest
+// .exec-sanitize.yaml in dir or its parents, up to the root of the git repository dir is in. only files that
+// exist are returned
+func defaultConfigs(dir string) []string {
+	var configs []string
+	if configDir, err := os.UserConfigDir(); err == nil {
+		if path := filepath.Join(configDir, "exec-sanitize", "config.yaml"); isFile(path) {
+			configs = append(configs, path)
+		}
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return configs
+	}
+	for {
+		if path := filepath.Join(dir, repoConfigFile); isFile(path) {
+			return append(configs, path)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return configs
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return configs
+		}
+		dir = parent
+	}
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
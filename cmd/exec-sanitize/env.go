@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// childEnv builds the command's environment: environ, unless -clean-env is set, followed by the variables of the
+// -env-file files and -env flags, without the -unset-env variables. later values of a variable override earlier ones
+func (a *parsedArgs) childEnv(environ []string) ([]string, error) {
+	var env []string
+	if !a.cleanEnv {
+		env = append(env, environ...)
+	}
+	for _, path := range a.envFiles {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	env = append(env, a.env...)
+
+	unset := make(map[string]bool, len(a.unsetEnv))
+	for _, key := range a.unsetEnv {
+		unset[key] = true
+	}
+
+	// keep the last value of each variable, in the order the variables first appeared in
+	var (
+		keys   []string
+		values = make(map[string]string, len(env))
+	)
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if unset[key] {
+			continue
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = kv
+	}
+
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, values[key])
+	}
+	return out, nil
+}
+
+// readEnvFile reads KEY=VALUE lines from a file. blank lines, # comments and export prefixes are ignored,
+// and values may be wrapped in single or double quotes
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading -env-file: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		vars []string
+		n    int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading -env-file: %w", err)
+	}
+
+	return vars, nil
+}
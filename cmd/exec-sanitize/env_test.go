@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_childEnv(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte(`
+# database settings
+export DB_HOST=db.internal
+DB_PASSWORD="hunter2"
+GREETING='hello world'
+`), 0600))
+
+	a := &parsedArgs{
+		envFiles: []string{envFile},
+		env:      []string{"HOME=/srv", "DB_HOST=localhost"},
+		unsetEnv: []string{"AWS_SECRET_ACCESS_KEY", "DB_PASSWORD"},
+	}
+	env, err := a.childEnv([]string{"HOME=/root", "PATH=/bin", "AWS_SECRET_ACCESS_KEY=abc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HOME=/srv", "PATH=/bin", "DB_HOST=localhost", "GREETING=hello world"}, env)
+
+	a.cleanEnv = true
+	env, err = a.childEnv([]string{"HOME=/root", "PATH=/bin"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DB_HOST=localhost", "GREETING=hello world", "HOME=/srv"}, env)
+
+	require.NoError(t, ioutil.WriteFile(envFile, []byte("DB_HOST\n"), 0600))
+	_, err = a.childEnv(nil)
+	assert.EqualError(t, err, envFile+":1: expected KEY=VALUE")
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(nil, &stdout, &stderr, []string{"/opt/execsanitize",
+		"-clean-env", "-env", "TOKEN=tok_123", "-env", "NAME=world",
+		"-p:plain", "tok_123", "-r", "<token>",
+		"--", "/usr/bin/env",
+	})
+	require.Zero(t, exitCode, stderr.String())
+	assert.Equal(t, "TOKEN=<token>\nNAME=world\n", stdout.String())
+
+	_, err = parseArgs([]string{"-env", "TOKEN", "--", "env"})
+	assert.EqualError(t, err, "-env must be KEY=VALUE")
+}
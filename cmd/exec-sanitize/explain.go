@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// explain returns an OnTrace hook that writes traces to w as -explain output
+func explain(w io.Writer) func(execsanitize.Trace) {
+	lw := &lockedWriter{w: w}
+	return func(tr execsanitize.Trace) {
+		stream := tr.Stream
+		if stream == "" {
+			stream = "input"
+		}
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s: %d bytes in %s: %q\n", stream, len(tr.Input), tr.Duration, tr.Input)
+		for _, rt := range tr.Rules {
+			fmt.Fprintf(&b, "  %s: %d matches in %s\n", rt.Rule.Name, len(rt.Matches), rt.Duration)
+			for _, m := range rt.Matches {
+				result := fmt.Sprintf("%q", m.Replacement)
+				if rt.Rule.Action != execsanitize.ActionReplace {
+					result = rt.Rule.Action.String()
+				}
+				fmt.Fprintf(&b, "    %d-%d %q -> %s\n", m.Start, m.End, m.Text, result)
+			}
+		}
+		if tr.Output != tr.Input {
+			fmt.Fprintf(&b, "  output: %q\n", tr.Output)
+		}
+
+		_, _ = lw.Write(b.Bytes())
+	}
+}
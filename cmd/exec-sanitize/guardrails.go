@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// checkProgramSizes rejects rules whose patterns compile to more than max instructions, if max is set
+func checkProgramSizes(rules []*execsanitize.Rule, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	for _, rule := range rules {
+		if rule.Pattern == nil {
+			continue
+		}
+		if size := execsanitize.ProgramSize(rule.Pattern); size > max {
+			return fmt.Errorf("rule %s: pattern compiles to %d instructions, more than -max-regex-size %d", rule.Name, size, max)
+		}
+	}
+
+	return nil
+}
+
+// warnSlowRules returns an OnSlowRule hook that warns about each rule that goes over the -rule-budget once
+func warnSlowRules(w io.Writer, budget time.Duration, disable bool) func(*execsanitize.Rule, time.Duration) {
+	var warned sync.Map
+	return func(rule *execsanitize.Rule, took time.Duration) {
+		if _, loaded := warned.LoadOrStore(rule, struct{}{}); loaded {
+			return
+		}
+
+		if disable {
+			fmt.Fprintf(w, "[exec-sanitize] rule %s took %s to match, more than -rule-budget %s, disabling it\n", rule.Name, took, budget)
+			return
+		}
+		fmt.Fprintf(w, "[exec-sanitize] rule %s took %s to match, more than -rule-budget %s\n", rule.Name, took, budget)
+	}
+}
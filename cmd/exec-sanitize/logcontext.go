@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// matchContext is the sanitized output around a logged match
+type matchContext struct {
+	Before []string `json:"before"`
+	// Line is the sanitized line the match was found on
+	Line  string   `json:"line"`
+	After []string `json:"after"`
+}
+
+// streamContext tracks the output of a stream for -log-context
+type streamContext struct {
+	// partial is the incomplete last line written
+	partial []byte
+	// recent are the last complete lines written, up to the log's context
+	recent []string
+	// unplaced are entries whose line has not been written yet
+	unplaced []matchLogEntry
+	// waiting are entries whose line has been written, waiting for the lines after it
+	waiting []matchLogEntry
+}
+
+func (l *matchLog) stream(name string) *streamContext {
+	if l.streams == nil {
+		l.streams = make(map[string]*streamContext)
+	}
+	sc, ok := l.streams[name]
+	if !ok {
+		sc = &streamContext{}
+		l.streams[name] = sc
+	}
+
+	return sc
+}
+
+// contextWriter passes the sanitized output of a stream through to w, recording its lines as the context of
+// the stream's matches. the sanitizer reports matches before writing their output, so the matches of a stream
+// are found on the lines written to it next
+func (l *matchLog) contextWriter(stream string, w io.Writer) io.Writer {
+	return &contextWriter{l: l, stream: stream, w: w}
+}
+
+type contextWriter struct {
+	l      *matchLog
+	stream string
+	w      io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	cw.l.mu.Lock()
+	cw.l.output(cw.l.stream(cw.stream), p)
+	cw.l.mu.Unlock()
+
+	return cw.w.Write(p)
+}
+
+// output records sanitized output, writing out entries once the lines after their match are known
+func (l *matchLog) output(sc *streamContext, p []byte) {
+	data := append(sc.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i+1:]
+	}
+	sc.partial = append([]byte(nil), data...)
+	if len(lines) == 0 {
+		return
+	}
+
+	// a write can hold several lines. each match is placed on the first one containing its replacement,
+	// if it is not on the incomplete line that follows them
+	placed := make([][]matchLogEntry, len(lines))
+	var unplaced []matchLogEntry
+	for _, entry := range sc.unplaced {
+		at := -1
+		for i, line := range lines {
+			if strings.Contains(line, entry.Replacement) {
+				at = i
+				break
+			}
+		}
+		switch {
+		case at >= 0:
+			placed[at] = append(placed[at], entry)
+		case bytes.Contains(sc.partial, []byte(entry.Replacement)):
+			unplaced = append(unplaced, entry)
+		default:
+			placed[0] = append(placed[0], entry)
+		}
+	}
+	sc.unplaced = unplaced
+
+	for i, line := range lines {
+		waiting := sc.waiting[:0]
+		for _, entry := range sc.waiting {
+			entry.Context.After = append(entry.Context.After, line)
+			if len(entry.Context.After) < l.context {
+				waiting = append(waiting, entry)
+				continue
+			}
+			l.writeEntry(entry)
+		}
+		sc.waiting = waiting
+
+		for _, entry := range placed[i] {
+			entry.Context = &matchContext{Before: append([]string{}, sc.recent...), Line: line, After: []string{}}
+			sc.waiting = append(sc.waiting, entry)
+		}
+
+		sc.recent = append(sc.recent, line)
+		if len(sc.recent) > l.context {
+			sc.recent = sc.recent[1:]
+		}
+	}
+}
+
+// flushContext writes out the entries still waiting for context, with as much of it as there is
+func (l *matchLog) flushContext() {
+	for _, sc := range l.streams {
+		if len(sc.partial) > 0 {
+			l.output(sc, []byte{'\n'})
+		}
+		for _, entry := range sc.waiting {
+			l.writeEntry(entry)
+		}
+		for _, entry := range sc.unplaced {
+			l.writeEntry(entry)
+		}
+	}
+	l.streams = nil
+}
@@ -1,36 +1,324 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"filippo.io/age"
 
 	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/gitleaks"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/presets"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/replacers"
+)
+
+var (
+	errPrintUsage        = fmt.Errorf("u")
+	errPrintVersion      = fmt.Errorf("v")
+	errPrintCapabilities = fmt.Errorf("c")
+)
+
+// subcommands are the first arguments that select what exec-sanitize does. without one, it runs a command as run does
+var subcommands = []string{"run", "filter", "serve", "proxy", "test", "rules"}
+
+// patternKinds are the kinds of -p:<kind> patterns
+var patternKinds = []string{"regex", "plain", "glob", "word", "kill"}
+
+const (
+	// tripwireExitCode is returned when the command was terminated by a -p:kill rule
+	tripwireExitCode = 3
+	defaultKillGrace = 5 * time.Second
+	// idleTimeoutExitCode is returned when the command was terminated by -idle-timeout, as by timeout(1)
+	idleTimeoutExitCode = 124
+	// maxOutputExitCode is returned when the command was terminated by -max-output-policy kill
+	maxOutputExitCode = 4
+	// defaultShell runs -c commands if neither -shell nor $SHELL are set
+	defaultShell = "/bin/sh"
+	// sshFlushInterval is the -flush-interval of -ssh if it is not set
+	sshFlushInterval = 100 * time.Millisecond
+)
+
+// special replacement values that select a rule action
+const (
+	discardToken      = "@discard"
+	discardWriteToken = "@discard-write"
+	alertToken        = "@alert"
 )
 
-var errPrintUsage = fmt.Errorf("u")
+const usageText = `usage: exec-sanitize [run] <patterns and replacements> -- <command> [args...]
+       exec-sanitize [run] <patterns and replacements> -c 'command | pipeline'
+       exec-sanitize filter <patterns and replacements> < input
+       exec-sanitize serve <patterns and replacements> [-listen addr] [-profile name=rules.yaml...]
+       exec-sanitize proxy <patterns and replacements> -listen addr -upstream addr [-direction down|up|both]
+       exec-sanitize test rules.yaml...
+       exec-sanitize rules list
+       exec-sanitize rules lint [-format text|json] rules.yaml...
+       exec-sanitize --version | --capabilities
+
+run runs a command, sanitizing its output, which is also what exec-sanitize does without a subcommand. without a command, or with the filter subcommand, stdin is sanitized and written to stdout.
+
+serve runs an HTTP server that sanitizes text with the rules given on the command line, or those of a -profile selected with the profile query parameter. POST /sanitize responds with JSON holding the sanitized text and the matches found in it, POST /stream streams back the sanitized body line by line.
+
+proxy accepts connections on the -listen address and forwards them to the -upstream address, sanitizing what the upstream sends back, what clients send, or both. addresses are host:port, tcp:host:port or unix:/path/to/socket. a tripwire rule closes the connection it matched in.
 
-const usageText = `usage: exec-sanitize <patterns and replacements> -- <command> [args...]
+test runs the test cases of -config files, each of which gives an input and the output or names of the rules expected for it, and exits with code 1 if any of them fail:
 
-each pattern must be directly followed with replacement. a replacement value of "@discard" deletes the line entirely.
+	tests:
+	  - name: redacts passwords
+	    input: 'password=hunter2'
+	    output: 'password=<redacted>'
+	    matches: [password]
 
+--version prints the version, commit and build date of exec-sanitize. --capabilities prints a JSON object describing the build along with the subcommands, -p:<kind> patterns, -r:<kind> replacers, -builtin rule packs and -log-backend values it supports, for tools that generate exec-sanitize command lines.
+
+rules list prints the built-in rule packs and their rules, as -list-builtin does. rules lint checks -config files for invalid patterns, patterns that match the empty string or compile to very large programs, replacements such as "@discard" that configs write out as is instead of reading them as actions, and rules that never match because an earlier rule always takes their text. it prints each problem found, or a JSON array of them with -format json, and exits with code 1 if there are any.
+
+each pattern must be directly followed with replacement. a replacement value of "@discard" deletes the matching lines entirely, and "@discard-write" deletes everything the command wrote in the same chunk of output. a replacement value of "@alert" leaves matches untouched but still logs and reports them.
+
+	-0
+		separate records with NUL bytes instead of newlines, as output by find -print0 or xargs -0. short for -delimiter "\x00".
+	-allow value
+		regular expression for matches to leave untouched, such as documented example keys. a match of any pattern that also matches an allow pattern is not replaced or reported. may be repeated.
+	-audit-file value
+		file to append a record of each run to, for evidence of the redaction policy a job ran with: a line of JSON with the full command line of exec-sanitize, the sha256 of the rules in force when the command started, the start and end time, the exit code and how many times each rule matched. each record holds the sha256 of the line before it, so that removing or changing a record breaks the chain, and with -hash-key-file, an HMAC-SHA256 of the line up to that field, closed with }. the command line is recorded as is, so do not pass secrets on it.
+	-c value
+		run a shell command line, such as a pipeline, with $SHELL -c instead of a command given after --.
+	-chdir value
+		directory to run the command in.
+	-check-args value
+		check the command's arguments and environment variables against the rules before running it. with warn, arguments that match a rule are reported on stderr, with refuse, the command is not run if any do, since arguments are visible to other users of the machine. either way, the matched values of arguments and environment variables are replaced wherever they show up in the output, such as in a shell's set -x trace.
+	-ci-secrets
+		replace the values of sensitive env vars, such as *_TOKEN, *_PASSWORD and *_SECRET, with the name of the variable. on GitHub Actions, GitLab CI, CircleCI and Buildkite, secrets set by the provider are included as well.
+	-clean-env
+		start the command with an empty environment instead of exec-sanitize's, so that only the -env and -env-file variables are passed to it.
+	-collapse
+		replace runs of the same match of the following pattern on a line, separated by nothing but spaces and tabs, with a single replacement annotated with the number of matches, e.g. "<redacted x431>". output is sanitized line by line, as with -line-buffered.
+	-combine
+		merge the command's stderr into its stdout through a single pipe, so that their output is interleaved as on a terminal.
+	-combine-prefix
+		like -combine, but prefix each line with [out] or [err] to tell the streams apart. the streams use separate pipes, so lines are only ordered as they are read, and are held back until complete as with -line-buffered.
+	-config value
+		YAML file of rules to add, see execsanitize.Config, or an http(s) URL to fetch one from. may be repeated. the configs are reloaded when exec-sanitize receives SIGHUP, without restarting the command. if a config fails to load, the previous rules are kept. local configs can include other files with include: [path or glob...], whose rules come first. unless -no-default-config is set, exec-sanitize/config.yaml in the user config directory, e.g. ~/.config, and the closest .exec-sanitize.yaml in the working directory or its parents, up to the root of its git repository, are loaded too, in that order and before the -config files.
+	-config-public-key value
+		file holding an ed25519 public key, PEM encoded or base64, to verify remote -config URLs with. each URL must then publish the base64 signature of its config at <url>.sig, e.g. as made with "openssl pkeyutl -sign -rawin -inkey key.pem -in rules.yaml | base64".
+	-config-refresh value
+		how often to refetch remote -config URLs, e.g. 5m. configs that have not changed, going by their ETag, are not downloaded again.
+	-debug-listen value
+		address to serve debug information on, e.g. 127.0.0.1:6060, to profile exec-sanitize in place when it is suspected of slowing a job down. /debug/vars has expvar counters, such as the bytes processed and the matches of each rule, and /debug/pprof/ has the pprof profiles, e.g. for "go tool pprof http://127.0.0.1:6060/debug/pprof/profile". the command line is not exposed. anyone who can connect can read the counters and profiles, so listen on a loopback address.
+	-delimiter value
+		separate records with this byte sequence instead of a newline, where Go escapes such as \x00, \r\n or \x1e are interpreted. records are what -line-buffered holds back and sanitizes one at a time, what @discard drops and what -e without the g flag replaces the first match in.
+	-direction value
+		which direction proxy sanitizes: down for what the upstream sends back, up for what clients send, or both. defaults to down.
+	-disable-slow-rules
+		disable rules that go over -rule-budget for the rest of the run, so that a pathological pattern cannot keep stalling the command's output. later matches of a disabled rule are left in the output.
+	-e value
+		sed-style s/pattern/replacement/flags expression, an alternative to a pattern followed by a replacement. & in the replacement is the whole match and \1 to \9 are capture groups. without the g flag, only the first match on each line is replaced. the i and m flags are as in -p:regex. does not take a replacement.
+	-encodings value
+		comma-separated encodings in which -p:plain patterns are matched as well: base64, including inside larger base64 strings such as basic auth headers, url for percent-encoding, and hex.
+	-env value
+		set an environment variable for the command, as KEY=VALUE. may be repeated. later values override earlier ones and -env-file values.
+	-env-file value
+		file of KEY=VALUE lines to add to the command's environment. blank lines, # comments and export prefixes are ignored, and values may be quoted. may be repeated.
+	-exclusive
+		match every pattern against the command's original output instead of the output of the patterns before it, so that a replacement is never matched by another pattern. where matches overlap, the pattern given first wins.
+	-explain value
+		file to write a trace of how each chunk of output was sanitized to, or - for stderr: the rules run over it, their matches and replacements, and how long each rule took. every rule is run over every chunk while tracing. meant for debugging rules, the trace contains the unsanitized output.
+	-fail-exit-code value
+		exit code to use for -fail-on-match. defaults to 1.
+	-fail-on-match
+		exit with a non-zero code if any rule matched, even if the command succeeded.
+	-fail-on-match-rule value
+		like -fail-on-match, but only for the rule with this name. may be repeated.
+	-flush-interval value
+		with -line-buffered, sanitize and write out a partial line once the command has not written anything for this long, e.g. 50ms, so that prompts without a trailing newline are not held back. the rest of the line is sanitized on its own, so a match split across the flush is missed.
+	-fold-unicode
+		match patterns as if look-alike characters were plain ASCII, so that secrets written with fullwidth or mathematical characters, Cyrillic or Greek homoglyphs or zero-width characters in between still match. the output keeps its characters apart from replaced matches.
+	-hash-key-file value
+		file containing a key for -r:hash, -r:anon-ip and {{.Hash}} in -r:template. if set, an hmac is used instead of a plain hash, so that guesses of the original values cannot be confirmed without the key.
+	-heartbeat value
+		write a line to stderr whenever the command has not written any output for this long, e.g. 30s, so that CI systems with inactivity timeouts do not kill long quiet steps. the line is written as is, rules do not apply to it, and it is not written to -tee-clean.
+	-heartbeat-message value
+		line written by -heartbeat. defaults to "[exec-sanitize] still running".
+	-heartbeat-stream value
+		stream to write -heartbeat lines to, stdout or stderr. defaults to stderr.
+	-idle-timeout value
+		terminate the command with SIGTERM, then SIGKILL after -kill-grace, if it does not write any output for this long, e.g. 10m. exec-sanitize then exits with code 124. -heartbeat lines do not count as output.
+	-ignore-ansi
+		match patterns as if ANSI escape sequences such as colors were not there, so that colored output cannot split a secret. escape sequences are kept in the output.
+	-ionice value
+		I/O scheduling class and level to run the command with, as realtime[:level], best-effort[:level] or idle, where the level is 0 (highest) to 7 and defaults to 4, e.g. idle or best-effort:7. realtime needs privileges. only supported on linux.
+	-kill-grace value
+		how long to wait after SIGTERM before killing the command. defaults to 5s. on windows, the command is sent CTRL_BREAK instead of SIGTERM, and killing it kills every process it started as well.
+	-line-buffered
+		hold back output until a full line is available and sanitize each line on its own, so that patterns and discards apply to whole lines even if the command writes them in pieces.
+	-line-endings value
+		preserve, the default, keeps CRLF and CR line endings as the command wrote them, while lf rewrites them to LF before sanitizing, which also turns the CRs of progress bars into line breaks. either way, with -line-buffered, the CR of a CRLF is not part of the line, so that $ matches before it.
+	-list-builtin
+		print the built-in rule packs and exit.
+	-listen value
+		address for serve or proxy to listen on. serve defaults to localhost:8080.
 	-log value
-		optional directory to log substituted strings as numbered files. if set, replacements will have the first asterisk * replaced with the log item number
+		optional directory to log substituted strings as numbered files, or file to log them to with -log-backend jsonl or sqlite. if set, replacements will have the first asterisk * replaced with the log item number, and -r:template's {{.MatchIndex}} is the log item number
+	-log-backend value
+		how to write the -log: dir, the default, writes each match to a numbered file in the -log directory. jsonl appends a JSON object per match to the -log file, with the rule, match, replacement, stream, time and an id for the run. sqlite inserts the same fields as a row per match into the matches table of the -log SQLite database, creating it if needed, with the time in its timestamp column. the sqlite backend needs a build with cgo.
+	-log-compress
+		gzip rotated jsonl -log files to <log>.<time>.gz.
+	-log-context value
+		record this many lines of sanitized output before and after each match in the jsonl -log, along with the sanitized line the match was found on, so that matches can be reviewed in context.
+	-log-dedup
+		log identical strings once, under the same number, instead of once per match. the number of times each one matched is written to a "counts" file in the -log directory when the command exits.
+	-log-encrypt value
+		age public key (age1...) to encrypt each logged match to, so that the -log can only be read with the private key, e.g. with "age -d -i key.txt log/3". jsonl logs hold the base64 encoded ciphertext. may be repeated.
+	-log-encrypt-recipient-file value
+		file of age recipients to encrypt logged matches to, one per line as accepted by "age -R". may be repeated.
+	-log-hash
+		log the HMAC-SHA256 of each match, keyed with -hash-key-file, and its length instead of the match itself, so that distinct leaks can be counted and correlated across runs without storing them. directory logs hold "<hash> <length>" in each file.
+	-log-max-age value
+		remove rotated jsonl -log files older than this duration, e.g. 168h.
+	-log-max-count value
+		keep at most this many rotated jsonl -log files, removing the oldest ones.
+	-log-max-size value
+		rotate the jsonl -log once it would grow past this size, e.g. 100m, by renaming it to <log>.<time> and starting a new one. sizes take a k, m or g suffix.
+	-logfmt-key value
+		comma-separated keys whose values to replace with <redacted> in logfmt lines, e.g. password,api_key. quoted values containing spaces are replaced as a whole. may be repeated.
+	-max-output value
+		limit the sanitized output the command writes to stdout and stderr to this size in total, e.g. 50MB, so that a runaway command cannot fill up log storage. what happens to the output past it depends on -max-output-policy. the bytes dropped from each stream are reported by -summary and -summary-json.
+	-max-output-policy value
+		what to do once -max-output is reached: truncate writes a marker line and drops the rest of the output, discard drops it silently, and kill also terminates the command with SIGTERM, then SIGKILL after -kill-grace, after which exec-sanitize exits with code 4. defaults to truncate.
+	-max-regex-size value
+		refuse to start if a rule's pattern compiles to more than this many instructions, as large counted repetitions such as x{1000} do. such patterns take a lot of time and memory to match. checked for reloaded -config files too, which are then not installed.
+	-max-replacements value
+		replace and log only the first n matches of the following pattern, leaving later matches untouched. a run collapsed by -collapse counts as one match.
+	-name value
+		optional name for the following pattern, shown in the summary.
+	-nice value
+		niceness to run the command with, from -20 to 19, e.g. 10 to deprioritize a batch job. set before the command executes on linux, and right after it starts elsewhere. negative values need privileges. not supported on windows.
+	-no-default-config
+		do not load the user's and the repository's default configs, see -config.
+	-notify-rule value
+		only notify about matches of the rule with this name. may be repeated. defaults to all rules.
+	-notify-url value
+		optional webhook url to POST a json summary of matches to, batched at most once every 5 seconds. the payload includes rule names but never the matched text or its replacement.
+	-otel
+		export a span for the run of the command, with an event naming the rule of each match but never the matched text, and metrics of the matches of each rule and the bytes processed on each stream, to an OpenTelemetry collector with OTLP over HTTP as JSON. also enabled by setting OTEL_EXPORTER_OTLP_ENDPOINT, which defaults to http://localhost:4318, and configured by the standard OTEL_* variables, such as OTEL_SERVICE_NAME and OTEL_EXPORTER_OTLP_HEADERS. telemetry is disabled with a warning if OTEL_EXPORTER_OTLP_PROTOCOL is set to anything but http/json. the span continues the trace of TRACEPARENT if it is set, and the command is passed its own TRACEPARENT. takes no value.
+	-output value
+		send the sanitized output to system logging instead of stdout and stderr, a line per entry: syslog://host:port, syslog+tcp://host:port or syslog:///dev/log for a syslog server or socket, journald: for the local journal. lines are sent with the name of the command as their tag, and the severity info for stdout and err for stderr. options are given as a query, e.g. syslog://localhost:514?tag=myjob&facility=local0&stderr=warning: tag, facility, stdout and stderr for the severity of each stream, and matches=true to also send an entry naming the rule of each match, or numbering it if it has no name, never the matched text. journald entries have the stream or rule in EXEC_SANITIZE_STREAM or EXEC_SANITIZE_RULE. a failure to send is reported once to stderr, and does not fail the run. may be repeated.
+	-output-checkpoint value
+		file recording how many lines of each stream were delivered to each -output, so that the lines delivered before are skipped when the command is run again, by -retry or by running exec-sanitize again with the same file after a failure. an -output stops sending after a failure, and reconnects for the next attempt. the file is written at most once a second and when exec-sanitize exits, and removed once the command succeeded and all of its output was delivered. lines count as delivered once they are written to the connection, as syslog and the journal do not acknowledge them, and the command must write the same output again for the count to skip the right lines.
 	-p:regex value
-		regexp pattern to sanitize.
+		regexp pattern to sanitize. modifiers may be appended as in -p:regex:im: i for case-insensitive, m for multiline, s to let . match newlines, U for ungreedy.
 	-p:plain value
-		plaintext pattern to sanitize.
+		plaintext pattern to sanitize. append :i as in -p:plain:i to match case-insensitively.
+	-p:glob value
+		shell-style glob pattern to sanitize, where * matches any run of non-whitespace characters and ? a single one, e.g. "ghp_*". [...] matches a character class. append :i as in -p:glob:i to match case-insensitively.
+	-p:word value
+		plaintext pattern to sanitize only where it is a whole word, so that "pass" matches "pass" but not "password". append :i as in -p:word:i to match case-insensitively.
+	-p:kill value
+		regexp tripwire pattern. if the command's output matches, the output is dropped and the command is terminated with SIGTERM, then SIGKILL after -kill-grace. exec-sanitize then exits with code 3. does not take a replacement.
+	-pack value
+		add the rules of a built-in rule pack, see -list-builtin. may be repeated.
+	-policy value
+		Rego policy file deciding the action taken on each match instead of its rule, for decisions kept apart from the patterns. the policy is evaluated with opa, which must be in PATH, and the action is the string value of data.execsanitize.action: replace, discard-line or discard, discard-write, alert, or terminate or kill, which stops the command as a "kill" pattern does. the input holds the rule, the stream, the rule's own action, the length of the match rounded up to a power of 2 and the base name of the command, never the matched text, as well as the environment of the command as env, with its values sanitized. matches the policy defines no action for keep their rule's action, as do those it fails to evaluate. decisions are cached for each distinct input.
+	-prefix value
+		template in Go text/template syntax to prefix each line of output with after sanitizing it, e.g. '[{{.Stream}} {{.Time}}] '. .Stream is stdout or stderr, which -combine cannot tell apart, and .Time is when the line was written, formatted as 2006-01-02T15:04:05.000Z07:00 or with {{.Time.Format "15:04:05"}}. replaces the prefixes of -combine-prefix.
+	-profile value
+		profile of the -config files to use, whose rules apply along with the configs' top-level rules. profiles can extend other profiles, see execsanitize.ProfileConfig. with serve, a name=rules.yaml profile serves the rules of a file under that name instead. may be repeated.
+	-pty
+		run the command attached to a pseudo-terminal. its stdout and stderr are merged.
+	-quarantine value
+		suppress all output, including -tee-clean, for the rest of the run once a rule replaces or discards a match, since output after a leak cannot be trusted either. the output of the write the match was found in is suppressed as well. with suppress, the output stops silently, with notice, a single line naming the rule is written to stderr instead. the command keeps running.
 	-r value
 		what to replace matched substrings with.
+	-r:anon-ip[:v4bits[,v6bits]]
+		replace IP addresses within matched substrings with pseudonymous addresses that keep their subnet structure: addresses sharing a prefix are replaced with addresses sharing a prefix of the same length. the mapping is keyed with -hash-key-file, or a random key for each run. with prefix lengths, as in -r:anon-ip:24, addresses are truncated to their network address instead, keeping the first v4bits of IPv4 and v6bits (default 48) of IPv6 addresses. takes no value.
+	-r:hash[:length]
+		replace matched substrings with the first length (default 8) hex characters of their sha256 hash, so that occurrences of the same value can be correlated. takes no value.
+	-r:preserve[:start,end]
+		replace letters in matched substrings with x and digits with 0, keeping their length and punctuation, and optionally the first start and last end letters and digits. takes no value.
+	-r:tokenize[:prefix]
+		replace each distinct matched value with a stable numbered token such as <SECRET-7>, or <prefix-7>. see -token-map. takes no value.
+	-r:mask[:start,end[,char]]
+		mask matched substrings with asterisks or char, optionally keeping the first start and last end characters. takes no value.
+	-r:mask-fixed:length[,char]
+		replace matched substrings with a fixed number of asterisks or char, hiding their length. takes no value.
+	-r:exec[:timeout[,concurrency]] value
+		replace matched substrings with the output of a command, such as an in-house tokenization client, which is run with each match on its stdin. the value is the command and its arguments separated by spaces. trailing newlines are removed from the output. each run is limited to timeout, defaulting to 5s, and at most concurrency commands run at once, defaulting to the number of CPUs. matches whose command fails are replaced with <redacted>.
+	-r:script[:function]
+		replace matched substrings with what a function of a -replacer-script returns when called with them, the function named replace by default. takes no value.
+	-r:template:template
+		replace matched substrings with a Go text/template, e.g. -r:template:'<{{.RuleName}}-{{.MatchIndex}}>'. templates can use {{.RuleName}}, {{.MatchIndex}}, a count of the rule's matches from 0, {{.Stream}}, {{.Hash}}, as with -r:hash, and {{.Timestamp}}, e.g. {{.Timestamp.Format "15:04:05"}}. takes no value.
+	-replacer-script value
+		Starlark script whose functions -r:script calls to replace matches, such as one keeping the domain of email addresses while masking their mailbox. functions take the match and return its replacement. each call is limited to 1000000 execution steps, and matches whose function fails or does not return a string are replaced with <redacted>. the output of print is discarded. may be repeated.
+	-report-sarif value
+		file to write a SARIF 2.1.0 report of the matches to when the command exits, for CI platforms that display SARIF, such as GitHub code scanning. each match is a result naming its rule, or numbering it if it has no name, and located at its line of stdout or stderr, never including the matched text. matches of rules replaced with "@alert" are warnings, others errors. at most 5000 matches are reported.
+	-retries value
+		run the command again up to this many times if it fails, waiting -retry-backoff before the first retry and twice as long before each one after it. the output of every attempt is sanitized with the same rules, -log entries record the attempt they were found in, and -r:template replacements can use it as {{.Attempt}}. stdin is not replayed, later attempts read what the previous ones left of it.
+	-retry-backoff value
+		delay before the first retry, e.g. 5s. doubles after every retry. defaults to 1s.
+	-retry-on-exit-codes value
+		comma-separated exit codes to retry the command on, e.g. 1,75. by default, any non-zero exit code is retried. commands killed by a signal or terminated by a -p:kill rule are never retried.
+	-rule-budget value
+		how long a rule may take to match a single chunk of output, e.g. 50ms. rules that take longer are reported on stderr once. the chunk is still sanitized in full.
+	-rules-gitleaks value
+		add the rules of a gitleaks TOML config, replacing each secret with <rule id>. the config's global allowlist applies like -allow. may be repeated.
+	-secrets-file value
+		.env file of KEY=value lines or JSON object of secret values to redact, each value is replaced with its key. safer than passing secret values as patterns, which end up in shell history and process listings. may be repeated.
+	-secrets-from value
+		source of secret values to redact, each value is replaced with its name. vault://<path> reads the fields of a secret from HashiCorp Vault, e.g. vault://secret/data/ci for a KV v2 engine mounted at secret, using VAULT_ADDR and VAULT_TOKEN. may be repeated.
+	-secrets-refresh value
+		how often to fetch -secrets-from and -secrets-file secrets again, e.g. 5m. by default they are only fetched at startup.
+	-secrets-replacement value
+		replacement for -secrets-from and -secrets-file secrets instead of <KEY>, where {name} is replaced with the secret's key, e.g. "<redacted:{name}>".
+	-shell value
+		shell to run -c with instead of $SHELL, or /bin/sh if it is not set.
+	-spill-after value
+		with -line-buffered, hold at most this much of a line in memory, e.g. 64MB, so that a command printing a huge line cannot run exec-sanitize out of memory. the rest of a longer line is kept in a temporary file in $TMPDIR, encrypted with a key that is only kept in memory, until the line ends. such lines are then sanitized in pieces of this size that end after whitespace where possible, so a match spanning pieces may be missed. applies to serve mode's /stream too.
+	-ssh
+		tune for wrapping ssh and scp: scrub known_hosts warnings, key fingerprints and host keys, and use a pty if stdin is a terminal. output is sanitized line by line as with -line-buffered and -ignore-ansi, and a partial line such as a password prompt is written out once the command has written nothing for -flush-interval, 100ms by default. writes holding only NUL bytes and escape sequences, such as keepalives and terminal title updates, do not postpone it.
+	-summary
+		print a table of how many times each rule matched when the command exits.
+	-summary-json value
+		file, or fd:N for an open file descriptor such as fd:3, to write a JSON summary to when the command exits: the command, its duration and exit code, the bytes processed per stream and how many times each rule matched.
+	-tee-clean value
+		file to also write the sanitized output of the command to.
+	-tee-raw value
+		file to write the unsanitized output of the command to, readable only by the current user. stdout and stderr are both written to it. meant for authorized debugging, the file contains every secret the command printed.
+	-threshold value
+		act once a rule matches too often, as <rule>:<count>/<window>:<action>, e.g. token:1000/1m:kill, where the rule may be * for the matches of all rules. notify writes a notice to stderr, discard discards the rest of the output as -quarantine does, and kill terminates the command with SIGTERM, then SIGKILL after -kill-grace, after which exec-sanitize exits with code 3. may be repeated.
+	-token-map value
+		file to write the token to value mapping of -r:tokenize replacements to when the command exits, readable only by the current user.
+	-token-map-recipient value
+		age public key (age1...) to encrypt the -token-map file to. may be repeated. the map can be decrypted with "age -d -i key.txt".
+	-umask value
+		octal umask to run the command with, e.g. 027. files that exec-sanitize itself creates while the command runs, such as -log files, are created with it too. not supported on windows.
+	-unset-env value
+		remove a variable from the command's environment, such as a secret it does not need. applies after -env and -env-file. may be repeated.
+	-upstream value
+		address for proxy to forward connections to.
+	-user value
+		user to run the command as, as name[:group] or uid[:gid], with the user's groups unless a group is given. exec-sanitize must be allowed to switch users, e.g. by running as root. not supported on windows.
+	-watch-config
+		reload the -config files whenever they change, as on SIGHUP. they are checked every second.
+	-workers value
+		sanitize output on this many workers, so that a command writing a lot to both stdout and stderr is not held up by sanitizing it, and in serve mode, so that streams are sanitized side by side. each stream's output stays in order, though matches may be reported out of order. rules with regions are still run as output is written. cannot be combined with -log-context.
 `
 
 func main() {
@@ -40,82 +328,978 @@ func main() {
 func run(stdin io.Reader, stdout, stderr io.Writer, args []string) int {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	// hooks and timers write notices to stderr from goroutines of their own while the command's output is being
+	// written to it, so writes to stderr are serialized
+	stderr = &lockedWriter{w: stderr}
+	argv := args
 
 	if len(args) < 2 {
 		fmt.Fprint(stderr, usageText)
 		return 1
 	}
 
+	var subcommand string
+	switch args[1] {
+	case "test":
+		return testRules(stdout, stderr, args[2:])
+	case "rules":
+		return rulesCommand(stdout, stderr, args[2:])
+	case "run", "filter", "serve", "proxy":
+		subcommand = args[1]
+		args = args[1:]
+	}
 	parsedArgs, err := parseArgs(args[1:])
 	if err != nil {
-		if err == errPrintUsage {
+		switch err {
+		case errPrintUsage:
 			fmt.Fprint(stderr, usageText)
 			return 0
+		case errPrintVersion:
+			printVersion(stdout)
+			return 0
+		case errPrintCapabilities:
+			if err := printCapabilities(stdout); err != nil {
+				fmt.Fprintf(stderr, "%v\n", err)
+				return 1
+			}
+			return 0
 		}
 
 		fmt.Fprintf(stderr, "%v\n", err)
 		return 1
 	}
+	switch {
+	case subcommand == "run" && parsedArgs.cmd == "":
+		fmt.Fprintln(stderr, "run needs a command after -- or -c")
+		return 1
+	case subcommand != "" && subcommand != "run" && parsedArgs.cmd != "":
+		fmt.Fprintf(stderr, "%s does not run a command\n", subcommand)
+		return 1
+	}
+	filterMode := parsedArgs.cmd == ""
+
+	if parsedArgs.listBuiltin {
+		if err := listBuiltin(stdout); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if !parsedArgs.noDefaultConfig {
+		dir := parsedArgs.chdir
+		if dir == "" {
+			dir = "."
+		}
+		parsedArgs.configs = append(defaultConfigs(dir), parsedArgs.configs...)
+	}
+	if len(parsedArgs.configProfiles) > 0 && len(parsedArgs.configs) == 0 {
+		fmt.Fprintf(stderr, "-profile %s needs -config\n", parsedArgs.configProfiles[0])
+		return 1
+	}
+
+	rc, err := parsedArgs.replacerContext()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	rc.errors = stderr
+	flagRules, err := parsedArgs.Rules(rc)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	configs, err := parsedArgs.configLoader()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	configRules, err := configs.load(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	var rules []*execsanitize.Rule
+	for _, name := range parsedArgs.packs {
+		pack, err := presets.Pack(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		rules = append(rules, pack...)
+	}
+	if len(parsedArgs.logfmtKeys) > 0 {
+		rules = append(rules, presets.Logfmt(parsedArgs.logfmtKeys, "<redacted>"))
+	}
+	var gitleaksAllow []*regexp.Regexp
+	for _, path := range parsedArgs.gitleaks {
+		rs, err := gitleaks.Load(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		rules = append(rules, rs.Rules...)
+		gitleaksAllow = append(gitleaksAllow, rs.Allow...)
+	}
+	if parsedArgs.ssh {
+		rules = append(rules, presets.SSH()...)
+	}
+	if parsedArgs.ciSecrets {
+		rules = append(rules, presets.EnvSecrets(os.Environ())...)
+	}
+	secretSources, err := parsedArgs.secretSources()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	set := &ruleSet{flags: flagRules, configs: configRules, rest: rules}
+	if len(secretSources) > 0 {
+		if set.secrets, err = secretRules(ctx, secretSources); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if err := checkProgramSizes(set.rules(), parsedArgs.maxRegexSize); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	allow, err := parsedArgs.Allow()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	s := &execsanitize.Sanitizer{
+		Rules:       set.rules(),
+		Allow:       append(allow, gitleaksAllow...),
+		Exclusive:   parsedArgs.exclusive,
+		IgnoreANSI:  parsedArgs.ignoreANSI || parsedArgs.ssh,
+		Delimiter:   parsedArgs.delimiter,
+		FoldUnicode: parsedArgs.foldUnicode,
 
-	rules, err := parsedArgs.Rules()
+		RuleTimeBudget:   parsedArgs.ruleBudget,
+		DisableSlowRules: parsedArgs.disableSlowRules,
+	}
+	if parsedArgs.ruleBudget > 0 {
+		s.OnSlowRule = warnSlowRules(stderr, parsedArgs.ruleBudget, parsedArgs.disableSlowRules)
+	}
+	if parsedArgs.explain != "" {
+		w := stderr
+		if parsedArgs.explain != "-" {
+			f, err := openTee(parsedArgs.explain, 0600)
+			if err != nil {
+				fmt.Fprintf(stderr, "opening -explain file: %v\n", err)
+				return 1
+			}
+			defer f.Close()
+			w = f
+		}
+		s.OnTrace = explain(w)
+	}
+	var pol *policy
+	if parsedArgs.policy != "" {
+		pol, err = loadPolicy(parsedArgs.policy, parsedArgs.cmd, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		s.ActionPolicy = pol.decide
+	}
+	set.s = s
+	var rulesSHA256 string
+	if parsedArgs.auditFile != "" {
+		rulesSHA256 = rulesDigest(s.Rules)
+	}
+	if parsedArgs.debugListen != "" {
+		addr, stop, err := startDebugServer(parsedArgs.debugListen, s)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		defer stop()
+		fmt.Fprintf(stderr, "[exec-sanitize] debug server listening on %s\n", addr)
+	}
+	if len(secretSources) > 0 && parsedArgs.secretsRefresh > 0 {
+		go refreshSecrets(ctx, set, secretSources, parsedArgs.secretsRefresh, stderr)
+	}
+	if len(parsedArgs.configs) > 0 {
+		reloadConfigs(ctx, set, configs, parsedArgs.watchConfig, parsedArgs.configRefresh, stderr)
+	}
+
+	switch subcommand {
+	case "serve":
+		return serve(parsedArgs, s, stderr)
+	case "proxy":
+		return runProxy(parsedArgs, s, stderr)
+	}
+
+	var onMatch []func(execsanitize.Match)
+	if parsedArgs.notifyURL != "" {
+		b := execsanitize.NewBatcher(&execsanitize.WebhookNotifier{URL: parsedArgs.notifyURL, RuleID: s.RuleID}, 0)
+		if len(parsedArgs.notifyRules) > 0 {
+			b.Filter = func(m execsanitize.Match) bool {
+				return containsString(parsedArgs.notifyRules, m.Rule.Name)
+			}
+		}
+		b.OnError = func(err error) {
+			fmt.Fprintf(stderr, "notifying webhook: %v\n", err)
+		}
+		defer b.Close()
+		onMatch = append(onMatch, b.Add)
+	}
+	otel, err := newOTelExporter(parsedArgs.otel, os.Environ(), s.RuleID, stderr)
 	if err != nil {
 		fmt.Fprintf(stderr, "%v\n", err)
 		return 1
 	}
-	s := &execsanitize.Sanitizer{Rules: rules}
+	if otel != nil {
+		onMatch = append(onMatch, otel.matched)
+	}
+	var sarif *sarifReport
+	if parsedArgs.reportSARIF != "" {
+		sarif = newSARIFReport(s.RuleID)
+		onMatch = append(onMatch, sarif.matched)
+	}
+
+	usePTY := parsedArgs.pty && !filterMode
+	if f, ok := stdin.(*os.File); ok && parsedArgs.ssh && !filterMode && isTerminal(f) {
+		usePTY = true
+	}
 
 	c := exec.CommandContext(ctx, parsedArgs.cmd, parsedArgs.cmdArgs...)
-	c.Env = os.Environ()
+	if c.Env, err = parsedArgs.childEnv(os.Environ()); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	if otel != nil {
+		c.Env = append(c.Env, "TRACEPARENT="+otel.traceparent())
+	}
+	if parsedArgs.checkArgs != "" && !filterMode {
+		check := checkArgs(s, parsedArgs.cmdArgs, c.Env)
+		if !check.report(parsedArgs.checkArgs, stderr) {
+			return 1
+		}
+		set.setArgs(check.rules)
+	}
+	if pol != nil && !filterMode {
+		pol.setEnv(s, c.Env)
+	}
 	c.Stdin = stdin
-	c.Stdout = s.Writer(stdout)
-	c.Stderr = s.Writer(stderr)
+	c.Dir = parsedArgs.chdir
+	if parsedArgs.user != "" {
+		if c.SysProcAttr, err = runAs(c.SysProcAttr, parsedArgs.user); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+	c.SysProcAttr = dieWithParent(c.SysProcAttr)
+	var writerOpts []execsanitize.WriterOption
+	if parsedArgs.lineBuffered || parsedArgs.combinePrefix || parsedArgs.ssh {
+		writerOpts = append(writerOpts, execsanitize.LineBuffered())
+	}
+	if parsedArgs.normalizeEOL {
+		writerOpts = append(writerOpts, execsanitize.NormalizeLineEndings())
+	}
+	if parsedArgs.flushInterval > 0 {
+		writerOpts = append(writerOpts, execsanitize.FlushAfter(parsedArgs.flushInterval))
+	} else if parsedArgs.ssh {
+		writerOpts = append(writerOpts, execsanitize.FlushAfter(sshFlushInterval))
+	}
+	if parsedArgs.ssh {
+		writerOpts = append(writerOpts, execsanitize.IgnoreKeepalives())
+	}
+	if parsedArgs.spillAfter > 0 {
+		writerOpts = append(writerOpts, execsanitize.SpillAfter(int(parsedArgs.spillAfter), ""))
+	}
+	if parsedArgs.workers > 0 {
+		pl := execsanitize.NewPipeline(parsedArgs.workers)
+		defer pl.Close()
+		writerOpts = append(writerOpts, execsanitize.WithPipeline(pl))
+	}
+	cleanStdout, cleanStderr := stdout, stderr
+	var (
+		sinks      []*logSink
+		checkpoint *checkpoint
+	)
+	if parsedArgs.outputCheckpoint != "" {
+		if checkpoint, err = loadCheckpoint(parsedArgs.outputCheckpoint); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if len(parsedArgs.outputs) > 0 {
+		tag := "exec-sanitize"
+		if !filterMode {
+			tag = filepath.Base(parsedArgs.cmd)
+		}
+		var outs, errs []io.Writer
+		for _, spec := range parsedArgs.outputs {
+			sink, err := spec.open(tag, stderr, s.RuleID)
+			if err != nil {
+				fmt.Fprintf(stderr, "%v\n", err)
+				return 1
+			}
+			defer sink.Close()
+			if checkpoint != nil {
+				sink.resumeFrom(checkpoint)
+			}
+			sinks = append(sinks, sink)
+			outs, errs = append(outs, sink.writer("stdout")), append(errs, sink.writer("stderr"))
+			onMatch = append(onMatch, sink.matched)
+		}
+		cleanStdout, cleanStderr = io.MultiWriter(outs...), io.MultiWriter(errs...)
+	}
+	// the command's process, for the hooks and timers that stop it
+	proc := &runningProcess{}
+	var (
+		limit          *outputLimit
+		outputExceeded atomic.Bool
+	)
+	if parsedArgs.maxOutput > 0 {
+		limit = newOutputLimit(parsedArgs.maxOutput, parsedArgs.maxOutputPolicy, func() {
+			p := proc.get()
+			if parsedArgs.maxOutputPolicy != maxOutputKill || filterMode || p == nil {
+				return
+			}
+			outputExceeded.Store(true)
+			terminate(p, parsedArgs.killGrace)
+		})
+		cleanStdout, cleanStderr = limit.writer("stdout", cleanStdout), limit.writer("stderr", cleanStderr)
+	}
+	var act *activity
+	if (parsedArgs.heartbeat > 0 || parsedArgs.idleTimeout > 0) && !filterMode {
+		act = newActivity()
+		cleanStdout, cleanStderr = act.wrap(cleanStdout, cleanStderr, parsedArgs.heartbeatStream == "stdout")
+	}
+	if parsedArgs.teeClean != "" {
+		f, err := openTee(parsedArgs.teeClean, 0644)
+		if err != nil {
+			fmt.Fprintf(stderr, "opening -tee-clean file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		cleanStdout, cleanStderr = io.MultiWriter(cleanStdout, f), io.MultiWriter(cleanStderr, f)
+	}
+	var thresholdKilled atomic.Bool
+	if parsedArgs.quarantine != "" || len(parsedArgs.thresholds) > 0 {
+		q := &quarantine{mode: parsedArgs.quarantine, notice: stderr}
+		cleanStdout, cleanStderr = q.writer(cleanStdout), q.writer(cleanStderr)
+		if parsedArgs.quarantine != "" {
+			onMatch = append(onMatch, q.matched)
+		}
+		onMatch = append(onMatch, thresholdHooks(parsedArgs.thresholds, stderr, q, func(reason string) {
+			p := proc.get()
+			if filterMode || p == nil || thresholdKilled.Swap(true) {
+				return
+			}
+			fmt.Fprintf(stderr, "\nterminating command: %s\n", reason)
+			terminate(p, parsedArgs.killGrace)
+		})...)
+	}
+	// prefixes are added after sanitizing, so that rules cannot match them
+	stdoutPrefix, stderrPrefix := staticPrefix("[out] "), staticPrefix("[err] ")
+	if parsedArgs.prefix != "" {
+		tmpl, err := parsePrefix(parsedArgs.prefix)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		stdoutPrefix, stderrPrefix = templatePrefix(tmpl, "stdout"), templatePrefix(tmpl, "stderr")
+	}
+	if parsedArgs.combinePrefix {
+		combined := &lockedWriter{w: cleanStdout}
+		cleanStdout, cleanStderr = &prefixWriter{w: combined, prefix: stdoutPrefix}, &prefixWriter{w: combined, prefix: stderrPrefix}
+	} else if parsedArgs.prefix != "" {
+		cleanStdout, cleanStderr = &prefixWriter{w: cleanStdout, prefix: stdoutPrefix}, &prefixWriter{w: cleanStderr, prefix: stderrPrefix}
+	}
+	if rc.log != nil && parsedArgs.logContext > 0 {
+		cleanStdout, cleanStderr = rc.log.contextWriter("stdout", cleanStdout), rc.log.contextWriter("stderr", cleanStderr)
+	}
+	sanitizedStdout := s.Writer(cleanStdout, append(writerOpts, execsanitize.WithStream("stdout"))...)
+	sanitizedStderr := s.Writer(cleanStderr, append(writerOpts, execsanitize.WithStream("stderr"))...)
+	if parsedArgs.combine && !parsedArgs.combinePrefix {
+		sanitizedStderr = sanitizedStdout
+	}
+	c.Stdout = sanitizedStdout
+	c.Stderr = sanitizedStderr
+	ptyOut := io.Writer(sanitizedStdout)
+	if parsedArgs.teeRaw != "" {
+		f, err := openTee(parsedArgs.teeRaw, 0600)
+		if err != nil {
+			fmt.Fprintf(stderr, "opening -tee-raw file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		// the raw copy is written after the sanitized one so that failing to write it does not hold back output
+		c.Stdout, c.Stderr = io.MultiWriter(sanitizedStdout, f), io.MultiWriter(sanitizedStderr, f)
+		if sanitizedStderr == sanitizedStdout {
+			// the command's stdout and stderr share a pipe only if they are the same writer
+			c.Stderr = c.Stdout
+		}
+		ptyOut = c.Stdout
+	}
+
+	var killOnce sync.Once
+	onMatch = append(onMatch, func(m execsanitize.Match) {
+		if m.Action != execsanitize.ActionTerminate || filterMode {
+			return
+		}
 
-	chanSig := make(chan os.Signal)
-	signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
-	go func() {
-	loop:
-		for {
-			select {
-			case sig := <-chanSig:
-				_ = c.Process.Signal(sig)
-				cancel()
-			case <-ctx.Done():
-				break loop
+		killOnce.Do(func() {
+			fmt.Fprintf(stderr, "\nterminating command: output matched rule %s\n", m.Rule.Name)
+			if p := proc.get(); p != nil {
+				terminate(p, parsedArgs.killGrace)
+			}
+		})
+	})
+	if rc.log != nil {
+		rc.log.waitForStreams()
+		onMatch = append(onMatch, rc.log.matched)
+	}
+	s.OnMatch = func(m execsanitize.Match) {
+		for _, fn := range onMatch {
+			fn(m)
+		}
+	}
+
+	if !filterMode {
+		chanSig := make(chan os.Signal, 1)
+		signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+		loop:
+			for {
+				select {
+				case sig := <-chanSig:
+					if p := proc.get(); p != nil {
+						_ = signalCommand(p, sig)
+					}
+					cancel()
+				case <-ctx.Done():
+					break loop
+				}
+			}
+		}()
+	}
+
+	restoreUmask := func() {}
+	if parsedArgs.umask != nil && !filterMode {
+		// the umask is inherited by the command when it starts, it is process-wide until then
+		if restoreUmask, err = setUmask(*parsedArgs.umask); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	var idle atomic.Bool
+	if act != nil {
+		if parsedArgs.heartbeat > 0 {
+			act.heartbeat(parsedArgs.heartbeat, parsedArgs.heartbeatMessage)
+		}
+		if parsedArgs.idleTimeout > 0 {
+			act.every(parsedArgs.idleTimeout, func() {
+				p := proc.get()
+				if p == nil || idle.Swap(true) {
+					return
+				}
+				fmt.Fprintf(stderr, "\nterminating command: no output for %s\n", parsedArgs.idleTimeout)
+				terminate(p, parsedArgs.killGrace)
+			})
+		}
+	}
+	var (
+		start    = time.Now()
+		exitCode int
+		// runPTY changes the command it runs, so retries start from a copy of it
+		base = retryCmd(ctx, c)
+	)
+	for attempt := 1; ; attempt++ {
+		rc.setAttempt(attempt)
+		if rc.log != nil && parsedArgs.retry.retries > 0 {
+			rc.log.setAttempt(attempt)
+		}
+		if attempt > 1 {
+			// the command starts its output over, which is delivered from the checkpoint on
+			for _, sink := range sinks {
+				sink.restart()
+			}
+		}
+		switch {
+		case filterMode:
+			err = filter(stdin, c.Stdout)
+		case usePTY:
+			err = runPTY(c, proc, stdin, ptyOut, parsedArgs.priority)
+		default:
+			err = runCommand(c, proc, parsedArgs.priority)
+		}
+		_ = sanitizedStdout.Flush()
+		_ = sanitizedStderr.Flush()
+		if s.Terminated() {
+			exitCode = tripwireExitCode
+			break
+		}
+		if thresholdKilled.Load() {
+			exitCode = tripwireExitCode
+			break
+		}
+		if idle.Load() {
+			exitCode = idleTimeoutExitCode
+			break
+		}
+		if outputExceeded.Load() {
+			exitCode = maxOutputExitCode
+			break
+		}
+		exitCode = exitStatus(stderr, err)
+
+		if filterMode || ctx.Err() != nil || !parsedArgs.retry.retry(attempt, err) {
+			break
+		}
+		delay := parsedArgs.retry.delay(attempt)
+		fmt.Fprintf(stderr, "retrying command in %s (attempt %d of %d)\n", delay, attempt+1, parsedArgs.retry.retries+1)
+		if !sleepContext(ctx, delay) {
+			break
+		}
+		c = retryCmd(ctx, base)
+	}
+	if checkpoint != nil {
+		delivered := true
+		for _, sink := range sinks {
+			sink.flush()
+			delivered = delivered && sink.delivered()
+		}
+		// a run that completed starts the next one from the beginning, while the next run after a failure resumes
+		// where this one stopped
+		save := checkpoint.flush
+		if exitCode == 0 && delivered {
+			save = checkpoint.remove
+		}
+		if err := save(); err != nil {
+			fmt.Fprintf(stderr, "writing -output-checkpoint: %v\n", err)
+		}
+	}
+	restoreUmask()
+	if act != nil {
+		act.stop()
+	}
+	duration := time.Since(start)
+	if exitCode == 0 {
+		if matched := parsedArgs.failingRules(s.Stats()); len(matched) > 0 {
+			fmt.Fprintf(stderr, "\noutput matched rules: %s\n", strings.Join(matched, ", "))
+			exitCode = parsedArgs.failExitCode
+			if exitCode == 0 {
+				exitCode = 1
 			}
 		}
-	}()
+	}
+
+	if parsedArgs.summary {
+		printSummary(stderr, s.Stats(), limit)
+	}
+
+	if rc.log != nil {
+		if err := rc.log.close(); err != nil {
+			fmt.Fprintf(stderr, "writing match log: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+
+	if parsedArgs.tokenMapPath != "" {
+		if err := rc.writeTokenMap(parsedArgs.tokenMapPath, parsedArgs.tokenMapRecipients); err != nil {
+			fmt.Fprintf(stderr, "writing token map: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+
+	if parsedArgs.summaryJSON != "" {
+		summary := newJSONSummary(parsedArgs, s.Stats(), s.RuleID, duration, exitCode)
+		if limit != nil {
+			summary.BytesDroppedByStream = limit.droppedBytes()
+		}
+		if err := writeJSONSummary(parsedArgs.summaryJSON, summary); err != nil {
+			fmt.Fprintf(stderr, "writing summary: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+
+	if sarif != nil {
+		if err := sarif.write(parsedArgs.reportSARIF); err != nil {
+			fmt.Fprintf(stderr, "writing SARIF report: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+
+	if parsedArgs.auditFile != "" {
+		record := newAuditRecord(argv, rulesSHA256, s.Stats(), s.RuleID, start, exitCode)
+		if err := appendAuditRecord(parsedArgs.auditFile, record, rc.hashKey); err != nil {
+			fmt.Fprintf(stderr, "writing audit record: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+
+	// failing to export telemetry does not fail the run
+	if otel != nil {
+		if err := otel.export(context.Background(), parsedArgs.cmd, s.Stats(), exitCode); err != nil {
+			fmt.Fprintf(stderr, "exporting telemetry: %v\n", err)
+		}
+	}
+
+	return exitCode
+}
+
+// failingRules returns the names of rules that matched and should fail the run
+func (a *parsedArgs) failingRules(st execsanitize.Stats) []string {
+	if !a.failOnMatch && len(a.failOnMatchRules) == 0 {
+		return nil
+	}
+
+	var matched []string
+	for _, rs := range st.Rules {
+		if rs.Matches == 0 {
+			continue
+		}
+		if a.failOnMatch || containsString(a.failOnMatchRules, rs.Rule.Name) {
+			matched = append(matched, rs.Rule.Name)
+		}
+	}
+
+	return matched
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runningProcess is the process of the command while it runs. hooks and timers that stop the command read it from
+// goroutines of their own, and retries replace it
+type runningProcess struct {
+	mu sync.Mutex
+	p  *os.Process
+}
+
+func (rp *runningProcess) set(p *os.Process) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	rp.p = p
+}
+
+// get returns the command's process, or nil if it is not running
+func (rp *runningProcess) get() *os.Process {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	return rp.p
+}
+
+// terminate asks a process to stop, killing it if it is still running after the grace period
+func terminate(p *os.Process, grace time.Duration) {
+	if grace <= 0 {
+		grace = defaultKillGrace
+	}
+
+	_ = signalCommand(p, syscall.SIGTERM)
+	time.AfterFunc(grace, func() {
+		_ = killCommand(p)
+	})
+}
+
+// runCommand runs a command with a priority until it exits, keeping proc set to its process while it runs
+func runCommand(c *exec.Cmd, proc *runningProcess, prio priority) error {
+	var release func()
+	return prio.run(c, func() (err error) {
+		release, err = startCommand(c)
+		if err == nil {
+			proc.set(c.Process)
+		}
+		return err
+	}, func() error {
+		defer release()
+		defer proc.set(nil)
+
+		return c.Wait()
+	})
+}
+
+// newSanitizer returns a sanitizer with the rules and settings of s, but without its state
+func newSanitizer(s *execsanitize.Sanitizer) *execsanitize.Sanitizer {
+	return &execsanitize.Sanitizer{
+		Rules:       s.CurrentRules(),
+		Allow:       s.Allow,
+		Exclusive:   s.Exclusive,
+		IgnoreANSI:  s.IgnoreANSI,
+		Delimiter:   s.Delimiter,
+		FoldUnicode: s.FoldUnicode,
+
+		RuleTimeBudget:   s.RuleTimeBudget,
+		OnSlowRule:       s.OnSlowRule,
+		DisableSlowRules: s.DisableSlowRules,
 
-	err = c.Run()
+		ActionPolicy: s.ActionPolicy,
+		OnTrace:      s.OnTrace,
+	}
+}
+
+// filter sanitizes stdin, stopping early if a tripwire rule matched
+func filter(stdin io.Reader, out io.Writer) error {
+	if stdin == nil {
+		return nil
+	}
+
+	_, err := io.Copy(out, stdin)
+	if err == execsanitize.ErrTerminated {
+		return nil
+	}
+	return err
+}
+
+// loadRules loads the rules of a -config file, with those of the given profiles if it defines any
+func loadRules(path string, profiles []string) ([]*execsanitize.Rule, error) {
+	c, err := execsanitize.LoadConfig(path)
 	if err != nil {
-		var (
-			exitCode = 1
-			exerr    *exec.ExitError
-		)
-		if errors.As(err, &exerr) {
-			exitCode = exerr.ExitCode()
-		} else {
-			fmt.Fprintf(stderr, "\ncommand exited with error %v\n", err)
-			return exitCode
+		return nil, fmt.Errorf("loading config %s: %w", path, err)
+	}
+
+	rules, err := configRules(c, profiles)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// configRules compiles the rules of a config. configs that define profiles must define every selected profile,
+// others are used as they are
+func configRules(c *execsanitize.Config, profiles []string) ([]*execsanitize.Rule, error) {
+	if len(c.Profiles) > 0 {
+		var err error
+		if c, err = c.Profile(profiles...); err != nil {
+			return nil, err
 		}
+	}
+
+	return c.Compile()
+}
+
+// exitStatus reports a failed command to stderr and returns the exit code to use
+func exitStatus(stderr io.Writer, err error) int {
+	if err == nil {
+		return 0
+	}
 
-		fmt.Fprintf(stderr, "\ncommand exited with code %d\n", exitCode)
+	var (
+		exitCode = 1
+		exerr    *exec.ExitError
+	)
+	if errors.As(err, &exerr) {
+		exitCode = exerr.ExitCode()
+	} else {
+		fmt.Fprintf(stderr, "\ncommand exited with error %v\n", err)
 		return exitCode
 	}
 
-	return 0
+	fmt.Fprintf(stderr, "\ncommand exited with code %d\n", exitCode)
+	return exitCode
+}
+
+// listBuiltin prints the built-in rule packs and their rules
+func listBuiltin(w io.Writer) error {
+	for _, name := range presets.Packs() {
+		c, err := presets.PackConfig(name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s\n\t%s\n", name, c.Description)
+		for _, rule := range c.Rules {
+			fmt.Fprintf(w, "\t- %s\n", rule.Name)
+		}
+	}
+
+	return nil
+}
+
+// printSummary prints a table of per-rule match counts, and the output dropped by -max-output if limit is set
+func printSummary(w io.Writer, st execsanitize.Stats, limit *outputLimit) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nrule\tmatches")
+	for _, rs := range st.Rules {
+		fmt.Fprintf(tw, "%s\t%d\n", rs.Rule.Name, rs.Matches)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d bytes processed\n", st.BytesProcessed)
+	if limit == nil {
+		return
+	}
+	dropped := limit.droppedBytes()
+	for _, stream := range []string{"stdout", "stderr"} {
+		if n, ok := dropped[stream]; ok {
+			fmt.Fprintf(w, "%d bytes of %s dropped by -max-output\n", n, stream)
+		}
+	}
 }
 
 // this is an intermediate step before the replacements are turned into ReplacerFuncs
 // to make things easier to test
 type parsedArgs struct {
-	rules   []parsedRule
-	cmd     string
-	cmdArgs []string
-	logPath string
+	rules     []parsedRule
+	allow     []string
+	cmd       string
+	cmdArgs   []string
+	configs   []string
+	listen    string
+	upstream  string
+	direction string
+	profiles  []string
+	logPath   string
+	logDedup  bool
+	teeRaw    string
+	teeClean  string
+	pty       bool
+	ssh       bool
+	summary   bool
+
+	combine       bool
+	combinePrefix bool
+	prefix        string
+	explain       string
+	summaryJSON   string
+
+	notifyURL   string
+	notifyRules []string
+
+	packs       []string
+	gitleaks    []string
+	logfmtKeys  []string
+	encodings   []execsanitize.Encoding
+	listBuiltin bool
+
+	killGrace time.Duration
+
+	failOnMatch      bool
+	failOnMatchRules []string
+	failExitCode     int
+
+	lineBuffered bool
+	ignoreANSI   bool
+	exclusive    bool
+	ciSecrets    bool
+
+	secretsFrom        []string
+	secretsFiles       []string
+	secretsReplacement string
+	secretsRefresh     time.Duration
+
+	hashKeyFile string
+	// replacerScripts are the -replacer-script files
+	replacerScripts []string
+
+	tokenMapPath       string
+	tokenMapRecipients []string
+
+	logBackend string
+	// logEncrypt and logEncryptFiles are the age recipients and recipient files to encrypt logged matches to
+	logEncrypt      []string
+	logEncryptFiles []string
+	logRotation     rotation
+	logContext      int
+	logHash         bool
+
+	watchConfig     bool
+	configRefresh   time.Duration
+	configPublicKey string
+
+	env      []string
+	envFiles []string
+	unsetEnv []string
+	cleanEnv bool
+
+	chdir string
+	user  string
+	umask *int
+
+	checkArgs string
+
+	shellCommand string
+	shell        string
+
+	retry retryPolicy
+
+	priority priority
+
+	// configProfiles are the profiles of the -config files to use
+	configProfiles  []string
+	noDefaultConfig bool
+
+	maxRegexSize     int
+	ruleBudget       time.Duration
+	disableSlowRules bool
+
+	heartbeat        time.Duration
+	heartbeatMessage string
+	heartbeatStream  string
+	idleTimeout      time.Duration
+
+	maxOutput       int64
+	maxOutputPolicy string
+
+	quarantine string
+	thresholds []thresholdSpec
+
+	delimiter string
+
+	flushInterval time.Duration
+	normalizeEOL  bool
+	foldUnicode   bool
+
+	workers int
+
+	spillAfter int64
+
+	debugListen string
+
+	auditFile string
+
+	otel bool
+
+	outputs []*outputSpec
+	// outputCheckpoint is the file recording the lines delivered to the outputs
+	outputCheckpoint string
+
+	reportSARIF string
+
+	policy string
 }
 
 type parsedRule struct {
+	name                 string
 	pattern, replacement string
+	// replacer is the spec of a -r:<kind> flag, used instead of the replacement
+	replacer string
+	// command is the value of -r:exec
+	command string
+	// action overrides the action selected by the replacement
+	action execsanitize.Action
+
+	maxReplacements int
+	collapseRuns    bool
+	// expand makes the replacement a template referencing capture groups, as used by -e
+	expand       bool
+	firstPerLine bool
 }
 
 func parseArgs(args []string) (*parsedArgs, error) {
@@ -124,15 +1308,141 @@ func parseArgs(args []string) (*parsedArgs, error) {
 	var (
 		i    int
 		rule string
+		// next holds the settings given for the next rule
+		next parsedRule
+		// plain is the value of a -p:plain rule, plains those of the parsed rules by index, for -encodings
+		plain  string
+		plains = make(map[int]string)
 	)
-	for ; i < len(args); i += 2 {
+	for i < len(args) {
 		arg := args[i]
 		if arg == "--" {
 			i++
 			break
 		}
-		if arg == "--help" {
+		switch arg {
+		case "--help":
 			return nil, errPrintUsage
+		case "--version":
+			return nil, errPrintVersion
+		case "--capabilities":
+			return nil, errPrintCapabilities
+		}
+
+		if strings.HasPrefix(arg, "-r:") {
+			if rule == "" {
+				return nil, fmt.Errorf("replacement must be directly preceeded by a pattern")
+			}
+			next.pattern, next.replacer = rule, arg[3:]
+			if kind := strings.SplitN(next.replacer, ":", 2)[0]; kind == "exec" {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("-r:exec must be followed with a command")
+				}
+				i++
+				next.command = args[i]
+			}
+			parsed.rules = append(parsed.rules, next)
+			if plain != "" {
+				plains[len(parsed.rules)-1] = plain
+			}
+			rule, next, plain = "", parsedRule{}, ""
+			i++
+			continue
+		}
+
+		// boolean flags
+		switch arg {
+		case "-pty":
+			parsed.pty = true
+			i++
+			continue
+		case "-ssh":
+			parsed.ssh = true
+			i++
+			continue
+		case "-combine":
+			parsed.combine = true
+			i++
+			continue
+		case "-combine-prefix":
+			parsed.combinePrefix = true
+			i++
+			continue
+		case "-summary":
+			parsed.summary = true
+			i++
+			continue
+		case "-list-builtin":
+			parsed.listBuiltin = true
+			i++
+			continue
+		case "-no-default-config":
+			parsed.noDefaultConfig = true
+			i++
+			continue
+		case "-disable-slow-rules":
+			parsed.disableSlowRules = true
+			i++
+			continue
+		case "-fail-on-match":
+			parsed.failOnMatch = true
+			i++
+			continue
+		case "-line-buffered":
+			parsed.lineBuffered = true
+			i++
+			continue
+		case "-ignore-ansi":
+			parsed.ignoreANSI = true
+			i++
+			continue
+		case "-exclusive":
+			parsed.exclusive = true
+			i++
+			continue
+		case "-ci-secrets":
+			parsed.ciSecrets = true
+			i++
+			continue
+		case "-log-dedup":
+			parsed.logDedup = true
+			i++
+			continue
+		case "-log-compress":
+			parsed.logRotation.compress = true
+			i++
+			continue
+		case "-log-hash":
+			parsed.logHash = true
+			i++
+			continue
+		case "-watch-config":
+			parsed.watchConfig = true
+			i++
+			continue
+		case "-clean-env":
+			parsed.cleanEnv = true
+			i++
+			continue
+		case "-fold-unicode":
+			parsed.foldUnicode = true
+			i++
+			continue
+		case "-otel":
+			parsed.otel = true
+			i++
+			continue
+		case "-0":
+			parsed.delimiter = "\x00"
+			i++
+			continue
+		case "-collapse":
+			if rule != "" {
+				return nil, fmt.Errorf("-collapse must precede a pattern")
+			}
+			next.collapseRuns = true
+			i++
+			continue
 		}
 
 		if i+1 >= len(args) {
@@ -140,30 +1450,390 @@ func parseArgs(args []string) (*parsedArgs, error) {
 		}
 
 		value := args[i+1]
-		switch arg {
+		i += 2
+
+		flag, modifiers, err := patternModifiers(arg)
+		if err != nil {
+			return nil, err
+		}
+		switch flag {
 		case "-log":
 			parsed.logPath = value
+		case "-log-backend":
+			parsed.logBackend = value
+		case "-log-encrypt":
+			parsed.logEncrypt = append(parsed.logEncrypt, value)
+		case "-log-encrypt-recipient-file":
+			parsed.logEncryptFiles = append(parsed.logEncryptFiles, value)
+		case "-env":
+			if key, _, ok := strings.Cut(value, "="); !ok || key == "" {
+				return nil, fmt.Errorf("-env must be KEY=VALUE")
+			}
+			parsed.env = append(parsed.env, value)
+		case "-env-file":
+			parsed.envFiles = append(parsed.envFiles, value)
+		case "-unset-env":
+			parsed.unsetEnv = append(parsed.unsetEnv, value)
+		case "-check-args":
+			if value != checkArgsWarn && value != checkArgsRefuse {
+				return nil, fmt.Errorf("-check-args must be %s or %s", checkArgsWarn, checkArgsRefuse)
+			}
+			parsed.checkArgs = value
+		case "-c":
+			parsed.shellCommand = value
+		case "-shell":
+			parsed.shell = value
+		case "-retries":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("parsing -retries: expected a number of retries")
+			}
+			parsed.retry.retries = retries
+		case "-retry-backoff":
+			backoff, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -retry-backoff: %w", err)
+			}
+			parsed.retry.backoff = backoff
+		case "-retry-on-exit-codes":
+			codes, err := parseExitCodes(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -retry-on-exit-codes: %w", err)
+			}
+			parsed.retry.exitCodes = codes
+		case "-heartbeat":
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("parsing -heartbeat: expected a positive duration such as 30s")
+			}
+			parsed.heartbeat = interval
+		case "-idle-timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("parsing -idle-timeout: expected a positive duration such as 10m")
+			}
+			parsed.idleTimeout = timeout
+		case "-max-output":
+			size, err := parseSize(value)
+			if err != nil || size == 0 {
+				return nil, fmt.Errorf("parsing -max-output: expected a size such as 50MB")
+			}
+			parsed.maxOutput = size
+		case "-debug-listen":
+			parsed.debugListen = value
+		case "-audit-file":
+			parsed.auditFile = value
+		case "-report-sarif":
+			parsed.reportSARIF = value
+		case "-policy":
+			parsed.policy = value
+		case "-output":
+			spec, err := parseOutput(value)
+			if err != nil {
+				return nil, err
+			}
+			parsed.outputs = append(parsed.outputs, spec)
+		case "-output-checkpoint":
+			parsed.outputCheckpoint = value
+		case "-spill-after":
+			size, err := parseSize(value)
+			if err != nil || size == 0 || size > math.MaxInt32 {
+				return nil, fmt.Errorf("parsing -spill-after: expected a size such as 64MB, up to 2GB")
+			}
+			parsed.spillAfter = size
+		case "-max-output-policy":
+			switch value {
+			case maxOutputTruncate, maxOutputDiscard, maxOutputKill:
+			default:
+				return nil, fmt.Errorf("-max-output-policy must be %s, %s or %s", maxOutputTruncate, maxOutputDiscard, maxOutputKill)
+			}
+			parsed.maxOutputPolicy = value
+		case "-quarantine":
+			if value != quarantineSuppress && value != quarantineNotice {
+				return nil, fmt.Errorf("-quarantine must be %s or %s", quarantineSuppress, quarantineNotice)
+			}
+			parsed.quarantine = value
+		case "-threshold":
+			spec, err := parseThreshold(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -threshold: %w", err)
+			}
+			parsed.thresholds = append(parsed.thresholds, spec)
+		case "-delimiter":
+			delim, err := strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -delimiter: %w", err)
+			}
+			if delim == "" {
+				return nil, fmt.Errorf("-delimiter cannot be empty")
+			}
+			parsed.delimiter = delim
+		case "-flush-interval":
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("parsing -flush-interval: expected a positive duration such as 50ms")
+			}
+			parsed.flushInterval = interval
+		case "-line-endings":
+			switch value {
+			case "preserve":
+				parsed.normalizeEOL = false
+			case "lf":
+				parsed.normalizeEOL = true
+			default:
+				return nil, fmt.Errorf("-line-endings must be preserve or lf")
+			}
+		case "-heartbeat-message":
+			parsed.heartbeatMessage = value
+		case "-heartbeat-stream":
+			if value != "stdout" && value != "stderr" {
+				return nil, fmt.Errorf("-heartbeat-stream must be stdout or stderr")
+			}
+			parsed.heartbeatStream = value
+		case "-chdir":
+			parsed.chdir = value
+		case "-user":
+			parsed.user = value
+		case "-max-regex-size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("-max-regex-size must be a positive number of instructions")
+			}
+			parsed.maxRegexSize = size
+		case "-workers":
+			workers, err := strconv.Atoi(value)
+			if err != nil || workers <= 0 {
+				return nil, fmt.Errorf("-workers must be a positive number")
+			}
+			parsed.workers = workers
+		case "-rule-budget":
+			budget, err := time.ParseDuration(value)
+			if err != nil || budget <= 0 {
+				return nil, fmt.Errorf("parsing -rule-budget: expected a positive duration such as 50ms")
+			}
+			parsed.ruleBudget = budget
+		case "-nice":
+			nice, err := strconv.Atoi(value)
+			if err != nil || nice < -20 || nice > 19 {
+				return nil, fmt.Errorf("-nice must be between -20 and 19")
+			}
+			if runtime.GOOS == "windows" {
+				return nil, fmt.Errorf("-nice is not supported on windows")
+			}
+			parsed.priority.nice = &nice
+		case "-ionice":
+			if runtime.GOOS != "linux" {
+				return nil, fmt.Errorf("-ionice is only supported on linux")
+			}
+			class, level, err := parseIOPriority(value)
+			if err != nil {
+				return nil, err
+			}
+			parsed.priority.ioClass, parsed.priority.ioLevel = class, level
+		case "-umask":
+			mask, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || mask > 0777 {
+				return nil, fmt.Errorf("parsing -umask: expected an octal mask such as 027")
+			}
+			umask := int(mask)
+			parsed.umask = &umask
+		case "-config-public-key":
+			parsed.configPublicKey = value
+		case "-config-refresh":
+			refresh, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -config-refresh: %w", err)
+			}
+			parsed.configRefresh = refresh
+		case "-log-context":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -log-context: %w", err)
+			}
+			parsed.logContext = n
+		case "-log-max-size":
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -log-max-size: %w", err)
+			}
+			parsed.logRotation.maxSize = size
+		case "-log-max-age":
+			maxAge, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -log-max-age: %w", err)
+			}
+			parsed.logRotation.maxAge = maxAge
+		case "-log-max-count":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -log-max-count: %w", err)
+			}
+			parsed.logRotation.maxCount = count
+		case "-allow":
+			parsed.allow = append(parsed.allow, value)
+		case "-upstream":
+			parsed.upstream = value
+		case "-direction":
+			parsed.direction = value
+		case "-listen":
+			parsed.listen = value
+		case "-profile":
+			if strings.Contains(value, "=") {
+				parsed.profiles = append(parsed.profiles, value)
+			} else {
+				parsed.configProfiles = append(parsed.configProfiles, value)
+			}
+		case "-config":
+			parsed.configs = append(parsed.configs, value)
+		case "-tee-raw":
+			parsed.teeRaw = value
+		case "-tee-clean":
+			parsed.teeClean = value
+		case "-prefix":
+			parsed.prefix = value
+		case "-explain":
+			parsed.explain = value
+		case "-summary-json":
+			parsed.summaryJSON = value
+		case "-notify-url":
+			parsed.notifyURL = value
+		case "-notify-rule":
+			parsed.notifyRules = append(parsed.notifyRules, value)
+		case "-pack":
+			parsed.packs = append(parsed.packs, value)
+		case "-encodings":
+			for _, name := range strings.Split(value, ",") {
+				e, err := execsanitize.ParseEncoding(name)
+				if err != nil {
+					return nil, fmt.Errorf("parsing -encodings: %w", err)
+				}
+				parsed.encodings = append(parsed.encodings, e)
+			}
+		case "-logfmt-key":
+			parsed.logfmtKeys = append(parsed.logfmtKeys, strings.Split(value, ",")...)
+		case "-rules-gitleaks":
+			parsed.gitleaks = append(parsed.gitleaks, value)
+		case "-name":
+			if rule != "" {
+				return nil, fmt.Errorf("name must precede a pattern")
+			}
+			next.name = value
+		case "-max-replacements":
+			if rule != "" {
+				return nil, fmt.Errorf("-max-replacements must precede a pattern")
+			}
+			max, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -max-replacements: %w", err)
+			}
+			next.maxReplacements = max
 		case "-p:regex":
 			if rule != "" {
 				return nil, fmt.Errorf("pattern must be followed with a replacement")
 			}
-			rule = value
+			rule = withModifiers(value, modifiers)
 		case "-p:plain":
 			if rule != "" {
 				return nil, fmt.Errorf("pattern must be followed with a replacement")
 			}
-			rule = regexp.QuoteMeta(value)
+			rule, plain = withModifiers(regexp.QuoteMeta(value), modifiers), value
+		case "-p:glob":
+			if rule != "" {
+				return nil, fmt.Errorf("pattern must be followed with a replacement")
+			}
+			pattern, err := execsanitize.GlobPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			rule = withModifiers(pattern, modifiers)
+		case "-p:word":
+			if rule != "" {
+				return nil, fmt.Errorf("pattern must be followed with a replacement")
+			}
+			rule = withModifiers(execsanitize.WordPattern(value), modifiers)
+		case "-e":
+			if rule != "" {
+				return nil, fmt.Errorf("pattern must be followed with a replacement")
+			}
+			sed, err := parseSedExpr(value)
+			if err != nil {
+				return nil, err
+			}
+			next.pattern, next.replacement, next.expand, next.firstPerLine = sed.pattern, sed.replacement, sed.expand, sed.firstPerLine
+			parsed.rules = append(parsed.rules, next)
+			next = parsedRule{}
+		case "-p:kill":
+			if rule != "" {
+				return nil, fmt.Errorf("pattern must be followed with a replacement")
+			}
+			next.pattern, next.action = withModifiers(value, modifiers), execsanitize.ActionTerminate
+			parsed.rules = append(parsed.rules, next)
+			next = parsedRule{}
+		case "-fail-on-match-rule":
+			parsed.failOnMatchRules = append(parsed.failOnMatchRules, value)
+		case "-token-map":
+			parsed.tokenMapPath = value
+		case "-token-map-recipient":
+			parsed.tokenMapRecipients = append(parsed.tokenMapRecipients, value)
+		case "-hash-key-file":
+			parsed.hashKeyFile = value
+		case "-replacer-script":
+			parsed.replacerScripts = append(parsed.replacerScripts, value)
+		case "-fail-exit-code":
+			code, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -fail-exit-code: %w", err)
+			}
+			parsed.failExitCode = code
+		case "-secrets-from":
+			parsed.secretsFrom = append(parsed.secretsFrom, value)
+		case "-secrets-file":
+			parsed.secretsFiles = append(parsed.secretsFiles, value)
+		case "-secrets-replacement":
+			parsed.secretsReplacement = value
+		case "-secrets-refresh":
+			interval, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -secrets-refresh: %w", err)
+			}
+			parsed.secretsRefresh = interval
+		case "-kill-grace":
+			grace, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -kill-grace: %w", err)
+			}
+			parsed.killGrace = grace
 		case "-r":
 			if rule == "" {
 				return nil, fmt.Errorf("replacement must be directly preceeded by a pattern")
 			}
-			parsed.rules = append(parsed.rules, parsedRule{pattern: rule, replacement: value})
-			rule = ""
+			next.pattern, next.replacement = rule, value
+			parsed.rules = append(parsed.rules, next)
+			if plain != "" {
+				plains[len(parsed.rules)-1] = plain
+			}
+			rule, next, plain = "", parsedRule{}, ""
 		default:
 			return nil, fmt.Errorf("unrecognized flag %s", arg)
 		}
 	}
 
+	for idx, value := range plains {
+		variants := execsanitize.EncodedVariants(value, parsed.encodings...)
+		if len(variants) == 0 {
+			continue
+		}
+
+		// the rule keeps the name of the plain pattern
+		r := &parsed.rules[idx]
+		if r.name == "" {
+			r.name = r.pattern
+		}
+		for _, variant := range variants {
+			r.pattern += "|" + regexp.QuoteMeta(variant)
+		}
+	}
+
 	if i < len(args) {
 		parsed.cmd = args[i]
 	}
@@ -171,28 +1841,169 @@ func parseArgs(args []string) (*parsedArgs, error) {
 		parsed.cmdArgs = args[i+1:]
 	}
 
+	if parsed.shellCommand != "" {
+		if parsed.cmd != "" {
+			return nil, fmt.Errorf("-c cannot be combined with a command after --")
+		}
+		shell := parsed.shell
+		if shell == "" {
+			shell = os.Getenv("SHELL")
+		}
+		if shell == "" {
+			shell = defaultShell
+		}
+		parsed.cmd, parsed.cmdArgs = shell, []string{"-c", parsed.shellCommand}
+	} else if parsed.shell != "" {
+		return nil, fmt.Errorf("-shell needs -c")
+	}
+	if parsed.outputCheckpoint != "" && len(parsed.outputs) == 0 {
+		return nil, fmt.Errorf("-output-checkpoint needs -output")
+	}
+	if parsed.workers > 0 && parsed.logContext > 0 {
+		// the context of a match is the output written around the time it is found, which workers write out later
+		return nil, fmt.Errorf("-workers cannot be combined with -log-context")
+	}
+
 	return parsed, nil
 }
 
-func (a *parsedArgs) Rules() ([]*execsanitize.Rule, error) {
+// patternModifiers splits modifiers such as "im" off pattern flags like -p:regex:im
+func patternModifiers(arg string) (flag, modifiers string, err error) {
+	if !strings.HasPrefix(arg, "-p:") {
+		return arg, "", nil
+	}
+
+	i := strings.Index(arg[3:], ":")
+	if i < 0 {
+		return arg, "", nil
+	}
+	flag, modifiers = arg[:3+i], arg[3+i+1:]
+
+	allowed := "imsU"
+	switch flag {
+	case "-p:plain", "-p:glob", "-p:word":
+		allowed = "i"
+	}
+	for _, m := range modifiers {
+		if !strings.ContainsRune(allowed, m) {
+			return "", "", fmt.Errorf("unsupported modifier %q for %s", m, flag)
+		}
+	}
+
+	return flag, modifiers, nil
+}
+
+// withModifiers prefixes a regexp with inline flags
+func withModifiers(pattern, modifiers string) string {
+	if modifiers == "" {
+		return pattern
+	}
+
+	return "(?" + modifiers + ")" + pattern
+}
+
+// replacerContext loads the settings shared by -r:<kind> replacers
+func (a *parsedArgs) replacerContext() (*replacerContext, error) {
+	rc := &replacerContext{}
+	if a.hashKeyFile != "" {
+		key, err := ioutil.ReadFile(a.hashKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading hash key: %w", err)
+		}
+		rc.hashKey = bytes.TrimSpace(key)
+	}
+	for _, path := range a.replacerScripts {
+		sc, err := replacers.LoadScript(path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("loading -replacer-script: %w", err)
+		}
+		rc.scripts = append(rc.scripts, sc)
+	}
+	if a.logPath != "" {
+		recipients, err := a.logRecipients()
+		if err != nil {
+			return nil, err
+		}
+		opts := matchLogOptions{
+			backend:    a.logBackend,
+			dedup:      a.logDedup,
+			recipients: recipients,
+			rotation:   a.logRotation,
+			context:    a.logContext,
+		}
+		if a.logHash {
+			// an unkeyed hash of a short secret could be reversed by trying every value
+			if rc.hashKey == nil {
+				return nil, fmt.Errorf("-log-hash needs -hash-key-file")
+			}
+			opts.hashKey = rc.hashKey
+		}
+		if rc.log, err = newMatchLog(a.logPath, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return rc, nil
+}
+
+// logRecipients parses the -log-encrypt recipients
+func (a *parsedArgs) logRecipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, r := range a.logEncrypt {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -log-encrypt: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	for _, path := range a.logEncryptFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading -log-encrypt-recipient-file: %w", err)
+		}
+		parsed, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	return recipients, nil
+}
+
+// Allow compiles the -allow patterns
+func (a *parsedArgs) Allow() ([]*regexp.Regexp, error) {
+	allow := make([]*regexp.Regexp, 0, len(a.allow))
+	for _, pattern := range a.allow {
+		rgxp, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("parsing allow pattern %s: %w", pattern, err)
+		}
+		allow = append(allow, rgxp)
+	}
+
+	return allow, nil
+}
+
+func (a *parsedArgs) Rules(rc *replacerContext) ([]*execsanitize.Rule, error) {
 	rules := make([]*execsanitize.Rule, 0, len(a.rules))
 
-	var loggerIdx int
-	withLogger := func(r execsanitize.ReplacerFunc) execsanitize.ReplacerFunc {
-		if a.logPath == "" {
+	// the log item number is only substituted into constant -r replacements
+	withLogger := func(name string, r execsanitize.ReplacerFunc, substitute bool) execsanitize.ReplacerFunc {
+		if rc.log == nil {
 			return r
 		}
 
 		return func(in string) string {
 			s := r(in)
 
-			idx := loggerIdx
-			loggerIdx++
-
-			_ = ioutil.WriteFile(filepath.Join(a.logPath, fmt.Sprint(idx)), []byte(in), 0644)
-
-			s = strings.Replace(s, "*", fmt.Sprint(idx), 1)
-			return s
+			return rc.log.add(name, in, func(idx int) string {
+				if substitute {
+					return strings.Replace(s, "*", fmt.Sprint(idx), 1)
+				}
+				return s
+			})
 		}
 	}
 
@@ -204,11 +2015,58 @@ func (a *parsedArgs) Rules() ([]*execsanitize.Rule, error) {
 			return nil, fmt.Errorf("parsing pattern %s: %w", rule.pattern, err)
 		}
 
-		rules = append(rules, &execsanitize.Rule{
-			Pattern: rgxp,
-			Replacer: withLogger(func(in string) string {
+		name := rule.name
+		if name == "" {
+			name = rule.pattern
+		}
+
+		action := rule.action
+		switch {
+		case action != execsanitize.ActionReplace, rule.expand:
+		case rule.replacement == discardToken:
+			action = execsanitize.ActionDiscardLine
+		case rule.replacement == discardWriteToken:
+			action = execsanitize.ActionDiscardWrite
+		case rule.replacement == alertToken:
+			action = execsanitize.ActionAlert
+		}
+
+		var (
+			replacer      execsanitize.ReplacerFunc
+			matchReplacer execsanitize.MatchReplacerFunc
+		)
+		switch {
+		case rule.replacer == "template" || strings.HasPrefix(rule.replacer, "template:"):
+			if matchReplacer, err = rc.buildTemplate(name, strings.TrimPrefix(rule.replacer, "template")); err != nil {
+				return nil, err
+			}
+		case rule.replacer != "":
+			r, err := rc.buildReplacer(rule.replacer, rule.command)
+			if err != nil {
+				return nil, err
+			}
+			replacer = withLogger(name, r, false)
+		case rule.expand:
+			replacer = withLogger(name, func(in string) string {
+				return rgxp.ReplaceAllString(in, rule.replacement)
+			}, false)
+		case rc.log != nil:
+			replacer = withLogger(name, func(in string) string {
 				return rule.replacement
-			}),
+			}, true)
+		}
+
+		rules = append(rules, &execsanitize.Rule{
+			Name:          name,
+			Pattern:       rgxp,
+			Replacer:      replacer,
+			MatchReplacer: matchReplacer,
+			Replacement:   rule.replacement,
+			Action:        action,
+
+			MaxReplacements: rule.maxReplacements,
+			CollapseRuns:    rule.collapseRuns,
+			FirstPerLine:    rule.firstPerLine,
 		})
 	}
 
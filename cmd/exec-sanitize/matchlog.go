@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/replacers"
+)
+
+// matchLogCountsFile is the name of the file -log-dedup writes occurrence counts to
+const matchLogCountsFile = "counts"
+
+// -log-backend values
+const (
+	// logBackendDir writes each match to a numbered file in the -log directory
+	logBackendDir = "dir"
+	// logBackendJSONL appends a JSON object per match to the -log file
+	logBackendJSONL = "jsonl"
+	// logBackendSQLite inserts a row per match into the matches table of the -log SQLite database
+	logBackendSQLite = "sqlite"
+)
+
+// matchLogSchema creates the table of sqlite match logs, whose columns are the fields of matchLogEntry
+const matchLogSchema = `
+PRAGMA journal_mode = WAL;
+PRAGMA synchronous = NORMAL;
+CREATE TABLE IF NOT EXISTS matches (
+	run_id      TEXT NOT NULL,
+	number      INTEGER NOT NULL,
+	timestamp   TIMESTAMP NOT NULL,
+	rule        TEXT NOT NULL,
+	match       TEXT,
+	encrypted   BOOLEAN NOT NULL DEFAULT FALSE,
+	hash        TEXT,
+	length      INTEGER,
+	replacement TEXT NOT NULL,
+	stream      TEXT,
+	attempt     INTEGER
+);
+CREATE INDEX IF NOT EXISTS matches_run_id ON matches (run_id, number);
+`
+
+// matchLogEntry is a line of a jsonl match log, or a row of a sqlite one
+type matchLogEntry struct {
+	Number int       `json:"number"`
+	RunID  string    `json:"run_id"`
+	Time   time.Time `json:"time"`
+	Rule   string    `json:"rule"`
+	// Match is base64 encoded age ciphertext if Encrypted is set, and empty if the log only stores hashes
+	Match     string `json:"match,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// Hash is the hex HMAC-SHA256 of the match and Length its length in bytes, if the log only stores hashes
+	Hash        string `json:"hash,omitempty"`
+	Length      int    `json:"length,omitempty"`
+	Replacement string `json:"replacement"`
+	Stream      string `json:"stream,omitempty"`
+	// Attempt is the attempt at running the command the match was found in, if -retries is set
+	Attempt int `json:"attempt,omitempty"`
+	// Context holds the sanitized lines around the match if -log-context is set
+	Context *matchContext `json:"context,omitempty"`
+}
+
+type pendingKey struct {
+	rule, match string
+}
+
+// matchLog logs matched strings, to numbered files in a directory, a jsonl file or a SQLite database
+type matchLog struct {
+	path    string
+	backend string
+	// dedup logs identical strings once and counts their occurrences instead. jsonl logs still get a line
+	// for every occurrence, under the same number
+	dedup bool
+	// recipients are the age recipients matches are encrypted to, if any
+	recipients []age.Recipient
+	// hash is set if only the keyed hashes and lengths of matches are logged
+	hash execsanitize.ReplacerFunc
+
+	runID string
+	file  io.WriteCloser
+	// db and insert write the entries of sqlite logs
+	db     *sql.DB
+	insert *sql.Stmt
+
+	mu     sync.Mutex
+	next   int
+	seen   map[string]int
+	counts map[int]int
+	// pending holds jsonl entries waiting for the stream of their match, see waitForStreams
+	pending map[pendingKey][]matchLogEntry
+	// context is the number of lines to record before and after each match
+	context int
+	streams map[string]*streamContext
+	// attempt is the number of the current attempt at running the command, if it may be retried
+	attempt int
+}
+
+// matchLogOptions configures a matchLog
+type matchLogOptions struct {
+	backend    string
+	dedup      bool
+	recipients []age.Recipient
+	// hashKey makes the log store the HMAC-SHA256 of matches keyed with it instead of the matches
+	hashKey []byte
+	// rotation limits the size of jsonl logs
+	rotation rotation
+	// context is the number of lines around each match to record in jsonl logs
+	context int
+}
+
+func newMatchLog(path string, opts matchLogOptions) (*matchLog, error) {
+	l := &matchLog{
+		path:       path,
+		backend:    opts.backend,
+		dedup:      opts.dedup,
+		recipients: opts.recipients,
+		context:    opts.context,
+		seen:       make(map[string]int),
+		counts:     make(map[int]int),
+	}
+	if opts.hashKey != nil {
+		if opts.recipients != nil {
+			return nil, fmt.Errorf("-log-hash and -log-encrypt cannot be combined")
+		}
+		l.hash = replacers.Hash(opts.hashKey, sha256.Size*2)
+	}
+
+	switch opts.backend {
+	case "", logBackendDir:
+		if opts.rotation.enabled() {
+			return nil, fmt.Errorf("log rotation needs -log-backend jsonl")
+		}
+		if opts.context > 0 {
+			return nil, fmt.Errorf("-log-context needs -log-backend jsonl")
+		}
+		l.backend = logBackendDir
+	case logBackendJSONL:
+		f, err := openRotatingFile(path, opts.rotation)
+		if err != nil {
+			return nil, fmt.Errorf("opening match log: %w", err)
+		}
+		l.file = f
+	case logBackendSQLite:
+		if opts.rotation.enabled() {
+			return nil, fmt.Errorf("log rotation needs -log-backend jsonl")
+		}
+		if opts.context > 0 {
+			return nil, fmt.Errorf("-log-context needs -log-backend jsonl")
+		}
+		if err := l.openDB(path); err != nil {
+			return nil, fmt.Errorf("opening match log: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown -log-backend %s", opts.backend)
+	}
+
+	if l.backend != logBackendDir {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			l.closeFiles()
+			return nil, err
+		}
+		l.runID = hex.EncodeToString(id)
+	}
+
+	return l, nil
+}
+
+// openDB opens the SQLite database of a sqlite log, creating its table if needed
+func (l *matchLog) openDB(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	// entries are written one at a time, and a single connection keeps the pragmas in effect
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(matchLogSchema); err != nil {
+		db.Close()
+		return err
+	}
+	insert, err := db.Prepare(`INSERT INTO matches (run_id, number, timestamp, rule, match, encrypted, hash, length,
+		replacement, stream, attempt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	l.db, l.insert = db, insert
+
+	return nil
+}
+
+// closeFiles closes the file or database of a jsonl or sqlite log
+func (l *matchLog) closeFiles() error {
+	if l.db != nil {
+		l.insert.Close()
+		return l.db.Close()
+	}
+
+	return l.file.Close()
+}
+
+// add logs a string matched by a rule and its replacement, returning the string's number.
+// replace computes the replacement given the number
+func (l *matchLog) add(rule, match string, replace func(idx int) string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx, seen := l.seen[match]
+	if seen {
+		l.counts[idx]++
+	} else {
+		idx = l.next
+		l.next++
+		if l.dedup {
+			l.seen[match] = idx
+			l.counts[idx] = 1
+		}
+	}
+	replacement := replace(idx)
+
+	var logged []byte
+	if l.hash != nil {
+		logged = []byte(fmt.Sprintf("%s %d\n", l.hash(match), len(match)))
+	} else {
+		var err error
+		if logged, err = l.encrypt(match); err != nil {
+			return replacement
+		}
+	}
+
+	switch l.backend {
+	case logBackendDir:
+		if !seen {
+			_ = ioutil.WriteFile(filepath.Join(l.path, fmt.Sprint(idx)), logged, 0644)
+		}
+	case logBackendJSONL, logBackendSQLite:
+		entry := matchLogEntry{
+			Number:      idx,
+			RunID:       l.runID,
+			Time:        time.Now(),
+			Rule:        rule,
+			Replacement: replacement,
+			Attempt:     l.attempt,
+		}
+		switch {
+		case l.hash != nil:
+			entry.Hash, entry.Length = l.hash(match), len(match)
+		case l.recipients != nil:
+			entry.Match, entry.Encrypted = base64.StdEncoding.EncodeToString(logged), true
+		default:
+			entry.Match = string(logged)
+		}
+		if l.pending == nil {
+			l.writeEntry(entry)
+			break
+		}
+		key := pendingKey{rule: rule, match: match}
+		l.pending[key] = append(l.pending[key], entry)
+	}
+
+	return replacement
+}
+
+// setAttempt sets the attempt at running the command that later matches are found in
+func (l *matchLog) setAttempt(attempt int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attempt = attempt
+}
+
+// encrypt encrypts a match to the log's recipients, if it has any
+func (l *matchLog) encrypt(match string) ([]byte, error) {
+	if l.recipients == nil {
+		return []byte(match), nil
+	}
+
+	var b bytes.Buffer
+	w, err := age.Encrypt(&b, l.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(match)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// waitForStreams holds back jsonl entries until matched reports the stream they were found in.
+// the sanitizer's OnMatch hook must then call matched for every match
+func (l *matchLog) waitForStreams() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.backend != logBackendDir {
+		l.pending = make(map[pendingKey][]matchLogEntry)
+	}
+}
+
+// matched writes the pending entry of a match, if any, with the stream it was found in
+func (l *matchLog) matched(m execsanitize.Match) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := pendingKey{rule: m.Rule.Name, match: m.Text}
+	entries := l.pending[key]
+	if len(entries) == 0 {
+		return
+	}
+
+	entry := entries[0]
+	entry.Stream = m.Stream
+	if l.context > 0 {
+		sc := l.stream(m.Stream)
+		sc.unplaced = append(sc.unplaced, entry)
+	} else {
+		l.writeEntry(entry)
+	}
+	if len(entries) == 1 {
+		delete(l.pending, key)
+	} else {
+		l.pending[key] = entries[1:]
+	}
+}
+
+func (l *matchLog) writeEntry(entry matchLogEntry) {
+	if l.insert != nil {
+		_, _ = l.insert.Exec(entry.RunID, entry.Number, entry.Time, entry.Rule, nullString(entry.Match), entry.Encrypted,
+			nullString(entry.Hash), sql.NullInt64{Int64: int64(entry.Length), Valid: entry.Hash != ""},
+			entry.Replacement, nullString(entry.Stream), sql.NullInt64{Int64: int64(entry.Attempt), Valid: entry.Attempt > 0})
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_, _ = l.file.Write(append(line, '\n'))
+}
+
+// close finishes the log. for directory logs, it writes the number of occurrences of each deduplicated match to
+// the counts file, one "<number> <count>" per line
+func (l *matchLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.backend != logBackendDir {
+		l.flushContext()
+		for _, entries := range l.pending {
+			for _, entry := range entries {
+				l.writeEntry(entry)
+			}
+		}
+		l.pending = nil
+		return l.closeFiles()
+	}
+
+	if !l.dedup || l.next == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	for idx := 0; idx < l.next; idx++ {
+		fmt.Fprintf(&b, "%d %d\n", idx, l.counts[idx])
+	}
+
+	return ioutil.WriteFile(filepath.Join(l.path, matchLogCountsFile), []byte(b.String()), 0644)
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// otelScope is the instrumentation scope exec-sanitize's telemetry is reported under
+const otelScope = "github.com/kamaln7/exec-sanitize/v2"
+
+// otelExporter records a run as an OpenTelemetry span, with an event for each match, and its counters as metrics,
+// and exports them with OTLP over HTTP as JSON once the run ends. it is configured with the standard OTEL_*
+// environment variables
+type otelExporter struct {
+	tracesURL, metricsURL string
+	headers               http.Header
+	client                *http.Client
+	resource              otlpResource
+	// ruleID names the rules of matches, see execsanitize.Sanitizer.RuleID
+	ruleID func(*execsanitize.Rule) string
+
+	traceID, spanID, parentSpanID string
+	start                         time.Time
+
+	mu            sync.Mutex
+	events        []otlpEvent
+	maxEvents     int
+	droppedEvents int
+}
+
+// newOTelExporter returns an exporter if telemetry is enabled by -otel or an OTEL_EXPORTER_OTLP_*ENDPOINT variable in
+// environ, and not disabled by OTEL_SDK_DISABLED, or nil otherwise. telemetry is also disabled, with a warning written
+// to warnings, if it is to be exported with a protocol other than http/json
+func newOTelExporter(enabled bool, environ []string, ruleID func(*execsanitize.Rule) string, warnings io.Writer) (*otelExporter, error) {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"} {
+		enabled = enabled || env[key] != ""
+	}
+	if !enabled || strings.EqualFold(env["OTEL_SDK_DISABLED"], "true") {
+		return nil, nil
+	}
+	if protocol := env["OTEL_EXPORTER_OTLP_PROTOCOL"]; protocol != "" && protocol != "http/json" {
+		fmt.Fprintf(warnings, "OTEL_EXPORTER_OTLP_PROTOCOL %s is not supported, exec-sanitize exports http/json, disabling telemetry\n", protocol)
+		return nil, nil
+	}
+
+	o := &otelExporter{
+		ruleID:    ruleID,
+		headers:   make(http.Header),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		maxEvents: 128,
+		start:     time.Now(),
+	}
+	endpoint := strings.TrimSuffix(env["OTEL_EXPORTER_OTLP_ENDPOINT"], "/")
+	if endpoint == "" {
+		endpoint = "http://localhost:4318"
+	}
+	if env["OTEL_TRACES_EXPORTER"] != "none" {
+		o.tracesURL = env["OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"]
+		if o.tracesURL == "" {
+			o.tracesURL = endpoint + "/v1/traces"
+		}
+	}
+	if env["OTEL_METRICS_EXPORTER"] != "none" {
+		o.metricsURL = env["OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"]
+		if o.metricsURL == "" {
+			o.metricsURL = endpoint + "/v1/metrics"
+		}
+	}
+
+	headers, err := parseOTelList("OTEL_EXPORTER_OTLP_HEADERS", env["OTEL_EXPORTER_OTLP_HEADERS"])
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range headers {
+		o.headers.Add(kv[0], kv[1])
+	}
+	if timeout := env["OTEL_EXPORTER_OTLP_TIMEOUT"]; timeout != "" {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_TIMEOUT %s, must be a number of milliseconds", timeout)
+		}
+		o.client.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if limit := env["OTEL_SPAN_EVENT_COUNT_LIMIT"]; limit != "" {
+		if o.maxEvents, err = strconv.Atoi(limit); err != nil || o.maxEvents < 0 {
+			return nil, fmt.Errorf("invalid OTEL_SPAN_EVENT_COUNT_LIMIT %s", limit)
+		}
+	}
+
+	attrs, err := parseOTelList("OTEL_RESOURCE_ATTRIBUTES", env["OTEL_RESOURCE_ATTRIBUTES"])
+	if err != nil {
+		return nil, err
+	}
+	serviceName := "exec-sanitize"
+	for _, kv := range attrs {
+		if kv[0] == "service.name" {
+			serviceName = kv[1]
+			continue
+		}
+		o.resource.Attributes = append(o.resource.Attributes, stringAttr(kv[0], kv[1]))
+	}
+	if name := env["OTEL_SERVICE_NAME"]; name != "" {
+		serviceName = name
+	}
+	o.resource.Attributes = append(o.resource.Attributes, stringAttr("service.name", serviceName))
+	if v := currentBuild().Version; v != "" {
+		o.resource.Attributes = append(o.resource.Attributes, stringAttr("service.version", v))
+	}
+
+	// the span continues the trace of a TRACEPARENT passed down by whatever started exec-sanitize
+	if traceID, parentID, ok := parseTraceparent(env["TRACEPARENT"]); ok {
+		o.traceID, o.parentSpanID = traceID, parentID
+	} else {
+		o.traceID = randomHex(16)
+	}
+	o.spanID = randomHex(8)
+
+	return o, nil
+}
+
+// parseOTelList parses the comma-separated key=value pairs of a variable such as OTEL_EXPORTER_OTLP_HEADERS, whose
+// values are percent-encoded
+func parseOTelList(name, list string) ([][2]string, error) {
+	var kvs [][2]string
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q, must be key=value", name, item)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", name, item, err)
+		}
+		kvs = append(kvs, [2]string{strings.TrimSpace(key), value})
+	}
+
+	return kvs, nil
+}
+
+// parseTraceparent returns the trace and span IDs of a W3C traceparent header
+func parseTraceparent(header string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	for _, id := range parts[1:3] {
+		b, err := hex.DecodeString(id)
+		if err != nil || bytes.Count(b, []byte{0}) == len(b) {
+			return "", "", false
+		}
+	}
+
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceparent returns the W3C traceparent of the run's span, passed to the command as TRACEPARENT so that its own
+// spans are children of it
+func (o *otelExporter) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", o.traceID, o.spanID)
+}
+
+// matched adds an event for a match to the span. only the rule is recorded, never the matched text
+func (o *otelExporter) matched(m execsanitize.Match) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.events) >= o.maxEvents {
+		o.droppedEvents++
+		return
+	}
+
+	attrs := []otlpKeyValue{stringAttr("rule.name", o.ruleID(m.Rule))}
+	if m.Stream != "" {
+		attrs = append(attrs, stringAttr("stream", m.Stream))
+	}
+	o.events = append(o.events, otlpEvent{TimeUnixNano: unixNano(time.Now()), Name: "match", Attributes: attrs})
+}
+
+// export sends the run's span and metrics
+func (o *otelExporter) export(ctx context.Context, command string, st execsanitize.Stats, exitCode int) error {
+	end := time.Now()
+	var errs []error
+	if o.tracesURL != "" {
+		errs = append(errs, o.post(ctx, o.tracesURL, o.traces(command, st, exitCode, end)))
+	}
+	if o.metricsURL != "" {
+		errs = append(errs, o.post(ctx, o.metricsURL, o.metrics(st, end)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o *otelExporter) traces(command string, st execsanitize.Stats, exitCode int, end time.Time) any {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var matches int64
+	for _, rs := range st.Rules {
+		matches += rs.Matches
+	}
+	span := otlpSpan{
+		TraceID:           o.traceID,
+		SpanID:            o.spanID,
+		ParentSpanID:      o.parentSpanID,
+		Name:              "exec-sanitize",
+		Kind:              1,
+		StartTimeUnixNano: unixNano(o.start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes: []otlpKeyValue{
+			intAttr("process.exit.code", int64(exitCode)),
+			intAttr("exec_sanitize.matches", matches),
+			intAttr("exec_sanitize.bytes", st.BytesProcessed),
+		},
+		Events:             o.events,
+		DroppedEventsCount: o.droppedEvents,
+	}
+	// the command's arguments are left out, as they may hold secrets
+	if command != "" {
+		span.Attributes = append(span.Attributes, stringAttr("process.command", command))
+	}
+	if exitCode != 0 {
+		span.Status = &otlpStatus{Code: 2, Message: fmt.Sprintf("exit code %d", exitCode)}
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": o.resource,
+			"scopeSpans": []any{map[string]any{
+				"scope": o.scope(),
+				"spans": []otlpSpan{span},
+			}},
+		}},
+	}
+}
+
+func (o *otelExporter) metrics(st execsanitize.Stats, end time.Time) any {
+	matches, byStream := []otlpDataPoint{}, []otlpDataPoint{}
+	for _, rs := range st.Rules {
+		matches = append(matches, o.dataPoint(rs.Matches, end, stringAttr("rule.name", o.ruleID(rs.Rule))))
+	}
+	streams := make([]string, 0, len(st.BytesByStream))
+	for stream := range st.BytesByStream {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	for _, stream := range streams {
+		byStream = append(byStream, o.dataPoint(st.BytesByStream[stream], end, stringAttr("stream", stream)))
+	}
+
+	return map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": o.resource,
+			"scopeMetrics": []any{map[string]any{
+				"scope": o.scope(),
+				"metrics": []otlpMetric{
+					{Name: "exec_sanitize.matches", Description: "matches of each rule", Unit: "{match}", Sum: newOTLPSum(matches)},
+					{Name: "exec_sanitize.bytes", Description: "bytes processed on each stream", Unit: "By", Sum: newOTLPSum(byStream)},
+				},
+			}},
+		}},
+	}
+}
+
+func (o *otelExporter) scope() map[string]string {
+	scope := map[string]string{"name": otelScope}
+	if v := currentBuild().Version; v != "" {
+		scope["version"] = v
+	}
+	return scope
+}
+
+func (o *otelExporter) dataPoint(value int64, end time.Time, attrs ...otlpKeyValue) otlpDataPoint {
+	return otlpDataPoint{
+		Attributes:        attrs,
+		StartTimeUnixNano: unixNano(o.start),
+		TimeUnixNano:      unixNano(end),
+		AsInt:             strconv.FormatInt(value, 10),
+	}
+}
+
+func (o *otelExporter) post(ctx context.Context, endpoint string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range o.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", endpoint, res.Status)
+	}
+
+	return nil
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// the OTLP types below are the JSON encoding of the protobuf messages, which encodes 64-bit integers as strings
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+func stringAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+func intAttr(key string, value int64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+type otlpSpan struct {
+	TraceID            string         `json:"traceId"`
+	SpanID             string         `json:"spanId"`
+	ParentSpanID       string         `json:"parentSpanId,omitempty"`
+	Name               string         `json:"name"`
+	Kind               int            `json:"kind"`
+	StartTimeUnixNano  string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano    string         `json:"endTimeUnixNano"`
+	Attributes         []otlpKeyValue `json:"attributes"`
+	Events             []otlpEvent    `json:"events,omitempty"`
+	DroppedEventsCount int            `json:"droppedEventsCount,omitempty"`
+	Status             *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Unit        string   `json:"unit"`
+	Sum         *otlpSum `json:"sum"`
+}
+
+// otlpSum is a monotonic sum with cumulative temporality, as the counters only grow over a run
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+func newOTLPSum(points []otlpDataPoint) *otlpSum {
+	return &otlpSum{DataPoints: points, AggregationTemporality: 2, IsMonotonic: true}
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsInt             string         `json:"asInt"`
+}
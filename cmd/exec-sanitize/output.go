@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// journaldSocket is where journald receives entries with its native protocol
+const journaldSocket = "/run/systemd/journal/socket"
+
+// syslog severities, by name
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3, "warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// syslog facilities, by name
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8, "cron": 9,
+	"authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21,
+	"local6": 22, "local7": 23,
+}
+
+// outputSpec is a parsed -output destination: syslog://host:port, syslog+tcp://host:port or syslog:///path for a
+// local socket, or journald: for the local journal
+type outputSpec struct {
+	dest string
+	// network and address are where to send entries to. an empty address is the default local syslog socket
+	network, address string
+	journald         bool
+
+	tag      string
+	facility int
+	// severity is the severity of each stream's lines
+	severity map[string]int
+	// matches also sends an entry naming the rule of each match
+	matches bool
+}
+
+func parseOutput(dest string) (*outputSpec, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -output %s: %w", dest, err)
+	}
+
+	spec := &outputSpec{
+		dest:     dest,
+		facility: facilities["user"],
+		severity: map[string]int{"stdout": severities["info"], "stderr": severities["err"]},
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		spec.network = "udp"
+		if u.Scheme == "syslog+tcp" {
+			spec.network = "tcp"
+		}
+		switch {
+		case u.Host != "":
+			spec.address = u.Host
+			if u.Port() == "" {
+				spec.address = net.JoinHostPort(u.Hostname(), "514")
+			}
+		case u.Path != "":
+			spec.network, spec.address = "unixgram", u.Path
+		}
+	case "journald":
+		spec.journald = true
+		spec.network, spec.address = "unixgram", journaldSocket
+		if u.Path != "" {
+			spec.address = u.Path
+		}
+	default:
+		return nil, fmt.Errorf("invalid -output %s, must be syslog://host:port, syslog+tcp://host:port or journald:", dest)
+	}
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "tag":
+			spec.tag = value
+		case "facility":
+			f, ok := facilities[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid -output %s, unknown facility %s", dest, value)
+			}
+			spec.facility = f
+		case "stdout", "stderr":
+			sev, ok := severities[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid -output %s, unknown severity %s", dest, value)
+			}
+			spec.severity[key] = sev
+		case "matches":
+			if spec.matches, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid -output %s, matches must be true or false", dest)
+			}
+		default:
+			return nil, fmt.Errorf("invalid -output %s, unknown option %s", dest, key)
+		}
+	}
+
+	return spec, nil
+}
+
+// logEntry is a line of output, or a match, sent to a log sink
+type logEntry struct {
+	severity int
+	msg      string
+	// stream is the stream of a line of output, rule the rule of a match
+	stream, rule string
+}
+
+type logSender interface {
+	send(e logEntry) error
+	Close() error
+}
+
+// logSink sends the sanitized output to system logging, a line per entry. it does not fail the run if sending
+// fails, as the command would then fail on its output, but reports the first failure to notice.
+// with a checkpoint, the lines it delivered earlier are skipped, and it stops sending after a failure, so that the
+// lines it delivered are always those before the checkpoint
+type logSink struct {
+	spec   *outputSpec
+	sender logSender
+	// dial connects to the destination again, for later attempts at running the command after a failure
+	dial   func() (logSender, error)
+	notice io.Writer
+	// ruleID names the rules of matches, see execsanitize.Sanitizer.RuleID
+	ruleID func(*execsanitize.Rule) string
+
+	mu      sync.Mutex
+	failed  bool
+	partial map[string][]byte
+	// checkpoint records delivered lines, if set. seen counts the lines of each stream of the current attempt
+	checkpoint *checkpoint
+	seen       map[string]int64
+}
+
+// open connects to the destination. tag is the identifier entries are sent with if the spec does not set one, and
+// ruleID names the rules of matches
+func (spec *outputSpec) open(tag string, notice io.Writer, ruleID func(*execsanitize.Rule) string) (*logSink, error) {
+	if spec.tag != "" {
+		tag = spec.tag
+	}
+	pid := os.Getpid()
+
+	dial := func() (logSender, error) {
+		if spec.journald {
+			conn, err := net.Dial(spec.network, spec.address)
+			if err != nil {
+				return nil, err
+			}
+			return &journaldSender{conn: conn, facility: spec.facility, tag: tag, pid: pid}, nil
+		}
+		return dialSyslog(spec.network, spec.address, spec.facility, tag, pid)
+	}
+	sender, err := dial()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to -output %s: %w", spec.dest, err)
+	}
+
+	return &logSink{spec: spec, sender: sender, dial: dial, notice: notice, ruleID: ruleID, partial: make(map[string][]byte)}, nil
+}
+
+// resumeFrom makes the sink skip the lines that a checkpoint records as delivered, and record those it delivers
+func (ls *logSink) resumeFrom(cp *checkpoint) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.checkpoint, ls.seen = cp, make(map[string]int64)
+}
+
+// restart prepares the sink for another attempt at running the command: the incomplete last lines are sent, and
+// with a checkpoint, lines are counted from the start again and the sink reconnects if sending failed
+func (ls *logSink) restart() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.sendPartial()
+	if ls.checkpoint == nil {
+		return
+	}
+	ls.seen = make(map[string]int64)
+	if !ls.failed {
+		return
+	}
+
+	sender, err := ls.dial()
+	if err != nil {
+		fmt.Fprintf(ls.notice, "[exec-sanitize] reconnecting to %s: %v\n", ls.spec.dest, err)
+		return
+	}
+	_ = ls.sender.Close()
+	ls.sender, ls.failed = sender, false
+}
+
+// writer returns a writer whose lines are sent as entries of a stream
+func (ls *logSink) writer(stream string) io.Writer {
+	return &logSinkWriter{sink: ls, stream: stream}
+}
+
+type logSinkWriter struct {
+	sink   *logSink
+	stream string
+}
+
+func (w *logSinkWriter) Write(p []byte) (int, error) {
+	ls := w.sink
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	buf := append(ls.partial[w.stream], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		ls.sendLine(w.stream, buf[:i])
+		buf = buf[i+1:]
+	}
+	ls.partial[w.stream] = append([]byte(nil), buf...)
+
+	return len(p), nil
+}
+
+func (ls *logSink) sendLine(stream string, line []byte) {
+	if ls.checkpoint != nil {
+		n := ls.seen[stream]
+		ls.seen[stream]++
+		if n < ls.checkpoint.deliveredLines(ls.spec.dest, stream) || ls.failed {
+			// delivered earlier, or to be sent after the checkpoint by a later attempt or run
+			return
+		}
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if ls.send(logEntry{severity: ls.spec.severity[stream], msg: string(line), stream: stream}) && ls.checkpoint != nil {
+		if err := ls.checkpoint.advance(ls.spec.dest, stream); err != nil {
+			fmt.Fprintf(ls.notice, "[exec-sanitize] writing -output-checkpoint: %v\n", err)
+		}
+	}
+}
+
+// send sends an entry, reporting whether it was sent
+func (ls *logSink) send(e logEntry) bool {
+	err := ls.sender.send(e)
+	if err != nil && !ls.failed {
+		ls.failed = true
+		fmt.Fprintf(ls.notice, "[exec-sanitize] sending output to %s: %v\n", ls.spec.dest, err)
+	}
+
+	return err == nil
+}
+
+// sendPartial sends the incomplete last lines of the streams
+func (ls *logSink) sendPartial() {
+	for _, stream := range []string{"stdout", "stderr"} {
+		if len(ls.partial[stream]) > 0 {
+			ls.sendLine(stream, ls.partial[stream])
+			ls.partial[stream] = nil
+		}
+	}
+}
+
+// flush sends the incomplete last lines of the streams
+func (ls *logSink) flush() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.sendPartial()
+}
+
+// delivered reports whether every line was sent, as far as the sink knows
+func (ls *logSink) delivered() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return !ls.failed
+}
+
+// matched sends an entry naming the rule of a match, if the spec asks for them. the matched text is never sent
+func (ls *logSink) matched(m execsanitize.Match) {
+	if !ls.spec.matches {
+		return
+	}
+
+	id := ls.ruleID(m.Rule)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.send(logEntry{severity: severities["notice"], msg: fmt.Sprintf("output matched rule %s", id), rule: id})
+}
+
+// Close sends the incomplete last lines of the streams and closes the connection
+func (ls *logSink) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.sendPartial()
+
+	return ls.sender.Close()
+}
+
+// syslogSender sends entries as syslog messages, in the format of the log/syslog package
+type syslogSender struct {
+	conn          net.Conn
+	local         bool
+	facility      int
+	tag, hostname string
+	pid           int
+}
+
+// dialSyslog connects to a syslog server, or to the local syslog socket if address is empty
+func dialSyslog(network, address string, facility int, tag string, pid int) (*syslogSender, error) {
+	s := &syslogSender{facility: facility, tag: tag, pid: pid}
+	if address == "" {
+		s.local = true
+		var err error
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			for _, network := range []string{"unixgram", "unix"} {
+				if s.conn, err = net.Dial(network, path); err == nil {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("no local syslog socket: %w", err)
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s.conn, s.local = conn, network == "unixgram"
+	s.hostname, _ = os.Hostname()
+
+	return s, nil
+}
+
+func (s *syslogSender) send(e logEntry) error {
+	pri := s.facility*8 + e.severity
+	var err error
+	if s.local {
+		_, err = fmt.Fprintf(s.conn, "<%d>%s %s[%d]: %s\n", pri, time.Now().Format(time.Stamp), s.tag, s.pid, e.msg)
+	} else {
+		_, err = fmt.Fprintf(s.conn, "<%d>%s %s %s[%d]: %s\n", pri, time.Now().Format(time.RFC3339), s.hostname, s.tag, s.pid, e.msg)
+	}
+	return err
+}
+
+func (s *syslogSender) Close() error {
+	return s.conn.Close()
+}
+
+// journaldSender sends entries to journald with its native protocol, with the stream or rule as a field of its own
+type journaldSender struct {
+	conn     net.Conn
+	facility int
+	tag      string
+	pid      int
+}
+
+func (j *journaldSender) send(e logEntry) error {
+	var b bytes.Buffer
+	field := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", key, value)
+			return
+		}
+		// values with newlines are sent as their length followed by their bytes
+		b.WriteString(key + "\n")
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	field("MESSAGE", e.msg)
+	field("PRIORITY", strconv.Itoa(e.severity))
+	field("SYSLOG_FACILITY", strconv.Itoa(j.facility))
+	field("SYSLOG_IDENTIFIER", j.tag)
+	field("SYSLOG_PID", strconv.Itoa(j.pid))
+	if e.stream != "" {
+		field("EXEC_SANITIZE_STREAM", e.stream)
+	}
+	if e.rule != "" {
+		field("EXEC_SANITIZE_RULE", e.rule)
+	}
+
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *journaldSender) Close() error {
+	return j.conn.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// -max-output-policy values
+const (
+	// maxOutputTruncate writes a marker line once the limit is reached and drops the rest of the output
+	maxOutputTruncate = "truncate"
+	// maxOutputDiscard silently drops the output past the limit
+	maxOutputDiscard = "discard"
+	// maxOutputKill truncates the output and terminates the command
+	maxOutputKill = "kill"
+)
+
+// outputLimit caps the total size of the sanitized output the command writes to stdout and stderr
+type outputLimit struct {
+	max    int64
+	policy string
+	// onExceeded is called once the output first exceeds the limit
+	onExceeded func()
+
+	mu       sync.Mutex
+	written  int64
+	exceeded bool
+	// dropped counts the bytes dropped from each stream
+	dropped map[string]int64
+}
+
+func newOutputLimit(max int64, policy string, onExceeded func()) *outputLimit {
+	if policy == "" {
+		policy = maxOutputTruncate
+	}
+
+	return &outputLimit{max: max, policy: policy, onExceeded: onExceeded, dropped: make(map[string]int64)}
+}
+
+// writer limits the output of a stream
+func (l *outputLimit) writer(stream string, w io.Writer) io.Writer {
+	return &limitWriter{l: l, stream: stream, w: w}
+}
+
+// droppedBytes returns the bytes dropped from each stream, or nil if nothing was dropped
+func (l *outputLimit) droppedBytes() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.dropped) == 0 {
+		return nil
+	}
+	dropped := make(map[string]int64, len(l.dropped))
+	for stream, n := range l.dropped {
+		dropped[stream] = n
+	}
+	return dropped
+}
+
+// limitWriter writes a stream's output until the limit is reached. output past it is reported as written,
+// so that the sanitizer does not fail
+type limitWriter struct {
+	l      *outputLimit
+	stream string
+	w      io.Writer
+	// midLine is set if the last write did not end with a newline
+	midLine bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	l := lw.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.exceeded {
+		l.dropped[lw.stream] += int64(len(p))
+		return len(p), nil
+	}
+
+	out := p
+	if l.written+int64(len(p)) > l.max {
+		// the output ends with the last line that fits in full
+		out = p[:bytes.LastIndexByte(p[:l.max-l.written], '\n')+1]
+		l.exceeded = true
+		l.dropped[lw.stream] += int64(len(p) - len(out))
+	}
+	if len(out) > 0 {
+		if _, err := lw.w.Write(out); err != nil {
+			return 0, err
+		}
+		l.written += int64(len(out))
+		lw.midLine = out[len(out)-1] != '\n'
+	}
+
+	if l.exceeded {
+		if l.policy != maxOutputDiscard {
+			marker := fmt.Sprintf("[exec-sanitize] output truncated, -max-output of %d bytes reached\n", l.max)
+			if lw.midLine {
+				marker = "\n" + marker
+			}
+			_, _ = io.WriteString(lw.w, marker)
+		}
+		if l.onExceeded != nil {
+			l.onExceeded()
+		}
+	}
+
+	return len(p), nil
+}
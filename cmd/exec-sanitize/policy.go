@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// policyQuery is the rule of a -policy that decides the action taken on a match
+const policyQuery = "data.execsanitize.action"
+
+// policyTimeout limits each evaluation of a -policy
+const policyTimeout = 5 * time.Second
+
+// policyInput describes a match to a -policy, without its text. the policy is also given the command's environment,
+// as env
+type policyInput struct {
+	Rule   string `json:"rule"`
+	Stream string `json:"stream"`
+	// Action is the action of the match's rule
+	Action string `json:"action"`
+	// Length is the length of the match rounded up to a power of 2, so that matches of a rule need few decisions
+	Length  int    `json:"length"`
+	Command string `json:"command"`
+}
+
+// policyDecision is a decision of a -policy, which is ready once done is closed
+type policyDecision struct {
+	done   chan struct{}
+	action execsanitize.Action
+}
+
+// policy decides the action taken on each match with a Rego policy, which is evaluated with the opa binary.
+// decisions are cached for each distinct input, and the rule's own action is taken if the policy fails
+type policy struct {
+	opa, path string
+	command   string
+	errors    io.Writer
+	// env is the command's environment, with its values sanitized
+	env map[string]string
+
+	mu    sync.Mutex
+	cache map[policyInput]*policyDecision
+}
+
+// loadPolicy checks a -policy file with opa. command is the command being run, whose name the policy is given
+func loadPolicy(path, command string, errors io.Writer) (*policy, error) {
+	opa, err := exec.LookPath("opa")
+	if err != nil {
+		return nil, fmt.Errorf("-policy needs opa in PATH: %w", err)
+	}
+	if out, err := exec.Command(opa, "check", path).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("checking -policy %s: %v: %s", path, err, bytes.TrimSpace(out))
+	}
+
+	if command != "" {
+		command = filepath.Base(command)
+	}
+	return &policy{
+		opa:     opa,
+		path:    path,
+		command: command,
+		errors:  errors,
+		cache:   make(map[policyInput]*policyDecision),
+	}, nil
+}
+
+// decide returns the action to take on a match, for the sanitizer's ActionPolicy. the policy is evaluated once for
+// each distinct input, without holding up the decisions of other inputs
+func (pl *policy) decide(m execsanitize.Match) execsanitize.Action {
+	in := policyInput{
+		Rule:    m.Rule.Name,
+		Stream:  m.Stream,
+		Action:  m.Action.String(),
+		Length:  lengthBucket(len(m.Text)),
+		Command: pl.command,
+	}
+
+	pl.mu.Lock()
+	if d, ok := pl.cache[in]; ok {
+		pl.mu.Unlock()
+		<-d.done
+		return d.action
+	}
+	d := &policyDecision{done: make(chan struct{})}
+	pl.cache[in] = d
+	env := pl.env
+	pl.mu.Unlock()
+
+	action, err := pl.eval(in, env, m.Action)
+	if err != nil {
+		fmt.Fprintf(pl.errors, "-policy: %v, taking action %s on matches of rule %s\n", err, m.Action.String(), m.Rule.Name)
+	}
+	d.action = action
+	close(d.done)
+
+	return action
+}
+
+// lengthBucket rounds the length of a match up to a power of 2
+func lengthBucket(n int) int {
+	bucket := 1
+	for bucket < n {
+		bucket <<= 1
+	}
+
+	return bucket
+}
+
+// setEnv gives the policy the command's environment, with the values sanitized with the rules of s so that the
+// secrets it holds are not passed on
+func (pl *policy) setEnv(s *execsanitize.Sanitizer, environ []string) {
+	// the values are sanitized with the rules' own actions, before the policy has the environment to decide on
+	scan := newSanitizer(s)
+	scan.ActionPolicy, scan.OnTrace = nil, nil
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = scan.Sanitize(value)
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.env = env
+}
+
+// eval evaluates the policy for an input and the command's environment, returning fallback if it does not define
+// an action for the input
+func (pl *policy) eval(in policyInput, env map[string]string, fallback execsanitize.Action) (execsanitize.Action, error) {
+	input, err := json.Marshal(struct {
+		policyInput
+		Env map[string]string `json:"env"`
+	}{in, env})
+	if err != nil {
+		return fallback, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, pl.opa, "eval", "--format", "json", "--stdin-input", "--data", pl.path, policyQuery)
+	c.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return fallback, fmt.Errorf("evaluating %s: %v: %s", pl.path, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value any `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fallback, fmt.Errorf("evaluating %s: %w", pl.path, err)
+	}
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		// the policy leaves matches it does not define an action for to their rules
+		return fallback, nil
+	}
+
+	name, ok := result.Result[0].Expressions[0].Value.(string)
+	if !ok {
+		return fallback, fmt.Errorf("%s of %s is %v, not an action", policyQuery, pl.path, result.Result[0].Expressions[0].Value)
+	}
+	action, err := parsePolicyAction(name)
+	if err != nil {
+		return fallback, fmt.Errorf("%s of %s: %w", policyQuery, pl.path, err)
+	}
+	return action, nil
+}
+
+// parsePolicyAction parses an action a policy decided on: a name of execsanitize.ParseAction, discard for
+// discard-line or kill for terminate
+func parsePolicyAction(name string) (execsanitize.Action, error) {
+	switch name {
+	case "discard":
+		return execsanitize.ActionDiscardLine, nil
+	case "kill":
+		return execsanitize.ActionTerminate, nil
+	}
+
+	return execsanitize.ParseAction(name)
+}
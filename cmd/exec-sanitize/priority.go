@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// I/O scheduling classes of -ionice
+var ioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// priority is the CPU and I/O priority the command runs with
+type priority struct {
+	// nice is the command's niceness, if set
+	nice *int
+	// ioClass is the command's I/O scheduling class, or 0 to leave it as is
+	ioClass int
+	ioLevel int
+}
+
+// parseIOPriority parses a CLASS[:LEVEL] -ionice spec. the level defaults to 4, and the idle class takes none
+func parseIOPriority(spec string) (class, level int, err error) {
+	name, levelSpec, hasLevel := strings.Cut(spec, ":")
+	class, ok := ioClasses[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("-ionice class must be realtime, best-effort or idle")
+	}
+	if !hasLevel {
+		if class == ioClasses["idle"] {
+			return class, 0, nil
+		}
+		return class, 4, nil
+	}
+	if class == ioClasses["idle"] {
+		return 0, 0, fmt.Errorf("-ionice class idle does not take a level")
+	}
+
+	level, err = strconv.Atoi(levelSpec)
+	if err != nil || level < 0 || level > 7 {
+		return 0, 0, fmt.Errorf("-ionice level must be between 0 and 7")
+	}
+
+	return class, level, nil
+}
+
+// apply sets the priority of a process, or on linux of a single thread
+func (p priority) apply(pid int) error {
+	if p.nice != nil {
+		if err := setNice(pid, *p.nice); err != nil {
+			return fmt.Errorf("setting -nice: %w", err)
+		}
+	}
+	if p.ioClass != 0 {
+		if err := setIOPriority(pid, p.ioClass, p.ioLevel); err != nil {
+			return fmt.Errorf("setting -ionice: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isSet reports whether the command runs with a priority of its own
+func (p priority) isSet() bool {
+	return p.nice != nil || p.ioClass != 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// ioprioWhoProcess makes ioprio_set set the I/O priority of a single process
+const ioprioWhoProcess = 1
+
+// dieWithParent makes the kernel kill the command if exec-sanitize dies without getting to kill it, such as when it
+// is OOM killed, so that the command cannot keep writing unsanitized output to the terminal or files it inherited.
+// attr's other attributes are kept
+func dieWithParent(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Pdeathsig = syscall.SIGKILL
+
+	return attr
+}
+
+// setIOPriority sets the I/O scheduling class and level of a process
+func setIOPriority(pid, class, level int) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(class<<13|level)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// run calls start to start a command and wait to wait for it, with the priority set before the command executes.
+// the priority is set on an OS thread of its own that start forks the command from, so the command inherits it
+// without exec-sanitize running with it. the thread is kept until wait returns, as dieWithParent kills the command
+// once the thread that started it exits, and is discarded after, as lowering its priority back may not be allowed
+func (p priority) run(c *exec.Cmd, start, wait func() error) error {
+	if !p.isSet() {
+		if err := start(); err != nil {
+			return err
+		}
+		return wait()
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		// the thread is never unlocked, so that it exits along with the goroutine
+		runtime.LockOSThread()
+		if err := p.apply(syscall.Gettid()); err != nil {
+			errc <- err
+			return
+		}
+		if err := start(); err != nil {
+			errc <- err
+			return
+		}
+		errc <- wait()
+	}()
+
+	return <-errc
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+func dieWithParent(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return attr
+}
+
+func setIOPriority(pid, class, level int) error {
+	return errors.New("only supported on linux")
+}
+
+// run calls start to start a command and wait to wait for it, setting the priority once the command has started.
+// processes it starts right away may miss it
+func (p priority) run(c *exec.Cmd, start, wait func() error) error {
+	if err := start(); err != nil {
+		return err
+	}
+	if p.isSet() {
+		if err := p.apply(c.Process.Pid); err != nil {
+			_ = killCommand(c.Process)
+			_ = wait()
+			return err
+		}
+	}
+
+	return wait()
+}
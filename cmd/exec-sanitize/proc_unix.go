@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential looks up the user and optional group of a -user name[:group] spec. without a group,
+// the command runs with the user's primary and supplementary groups
+func credential(spec string) (*syscall.Credential, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("looking up -user %s: %w", name, err)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("looking up -user %s: invalid uid %s", name, u.Uid)
+	}
+
+	gids := []string{u.Gid}
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("looking up -user group %s: %w", group, err)
+			}
+		}
+		gids = []string{g.Gid}
+	} else if ids, err := u.GroupIds(); err == nil {
+		gids = append(gids, ids...)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid)}
+	for i, id := range gids {
+		gid, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("looking up -user %s: invalid gid %s", spec, id)
+		}
+		if i == 0 {
+			cred.Gid = uint32(gid)
+		}
+		cred.Groups = append(cred.Groups, uint32(gid))
+	}
+
+	return cred, nil
+}
+
+// runAs sets the user and groups the command runs as
+func runAs(attr *syscall.SysProcAttr, spec string) (*syscall.SysProcAttr, error) {
+	cred, err := credential(spec)
+	if err != nil {
+		return nil, err
+	}
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Credential = cred
+
+	return attr, nil
+}
+
+// setUmask sets the process umask, returning a function that restores the previous one
+func setUmask(mask int) (restore func(), err error) {
+	old := syscall.Umask(mask)
+	return func() {
+		syscall.Umask(old)
+	}, nil
+}
+
+// setNice sets the niceness of a process
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// startCommand starts a command, returning a function to call once it has exited
+func startCommand(c *exec.Cmd) (release func(), err error) {
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() {}, nil
+}
+
+// signalCommand forwards a signal to a command
+func signalCommand(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// killCommand kills a command
+func killCommand(p *os.Process) error {
+	return p.Kill()
+}
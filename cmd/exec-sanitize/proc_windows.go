@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject          = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+)
+
+const (
+	createNewProcessGroup = 0x00000200
+	ctrlBreakEvent        = 1
+
+	jobObjectExtendedLimitInformationClass = 9
+	jobObjectLimitKillOnJobClose           = 0x00002000
+
+	processSetQuota  = 0x0100
+	processTerminate = 0x0001
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// jobs holds the job object of each running command by pid
+var jobs sync.Map
+
+func runAs(attr *syscall.SysProcAttr, spec string) (*syscall.SysProcAttr, error) {
+	return nil, errors.New("-user is not supported on windows")
+}
+
+func setUmask(mask int) (restore func(), err error) {
+	return nil, errors.New("-umask is not supported on windows")
+}
+
+func setNice(pid, nice int) error {
+	return errors.New("not supported on windows")
+}
+
+// startCommand starts a command in a process group of its own, so that it can be sent CTRL_BREAK, and in a job
+// object, so that killing it kills its whole process tree. the job is closed by the returned function, or by
+// windows if exec-sanitize exits first, which kills whatever is left of the tree either way
+func startCommand(c *exec.Cmd) (release func(), err error) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.CreationFlags |= createNewProcessGroup
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	job, err := newKillOnCloseJob()
+	if err == nil {
+		err = assignToJob(job, c.Process.Pid)
+		if err != nil {
+			syscall.CloseHandle(job)
+		}
+	}
+	if err != nil {
+		// the command still runs, but only it is killed, not the processes it started
+		return func() {}, nil
+	}
+
+	pid := c.Process.Pid
+	jobs.Store(pid, job)
+	return func() {
+		jobs.Delete(pid)
+		syscall.CloseHandle(job)
+	}, nil
+}
+
+func newKillOnCloseJob() (syscall.Handle, error) {
+	h, _, err := procCreateJobObject.Call(0, 0)
+	if h == 0 {
+		return 0, fmt.Errorf("creating job object: %w", err)
+	}
+	job := syscall.Handle(h)
+
+	var info jobObjectExtendedLimitInformation
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	ok, _, err := procSetInformationJobObject.Call(
+		uintptr(job),
+		jobObjectExtendedLimitInformationClass,
+		uintptr(unsafe.Pointer(&info)),
+		unsafe.Sizeof(info),
+	)
+	if ok == 0 {
+		syscall.CloseHandle(job)
+		return 0, fmt.Errorf("configuring job object: %w", err)
+	}
+
+	return job, nil
+}
+
+func assignToJob(job syscall.Handle, pid int) error {
+	p, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(p)
+
+	if ok, _, err := procAssignProcessToJobObject.Call(uintptr(job), uintptr(p)); ok == 0 {
+		return fmt.Errorf("assigning process to job object: %w", err)
+	}
+	return nil
+}
+
+// signalCommand forwards a signal to a command as CTRL_BREAK, the only console event that can be sent to
+// a process group of its own
+func signalCommand(p *os.Process, sig os.Signal) error {
+	if sig == os.Kill {
+		return killCommand(p)
+	}
+
+	if ok, _, err := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(p.Pid)); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// killCommand kills a command and every process in its job
+func killCommand(p *os.Process) error {
+	if job, ok := jobs.Load(p.Pid); ok {
+		if ok, _, err := procTerminateJobObject.Call(uintptr(job.(syscall.Handle)), 1); ok == 0 {
+			return err
+		}
+		return nil
+	}
+
+	return p.Kill()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// proxy directions
+const (
+	directionDown = "down"
+	directionUp   = "up"
+	directionBoth = "both"
+)
+
+// proxier forwards connections to an upstream address, sanitizing the streams in between
+type proxier struct {
+	base              *execsanitize.Sanitizer
+	network, upstream string
+	sanitizeUp        bool
+	sanitizeDown      bool
+	writerOpts        []execsanitize.WriterOption
+	stderr            io.Writer
+}
+
+func newProxier(base *execsanitize.Sanitizer, upstream, direction string, writerOpts []execsanitize.WriterOption, stderr io.Writer) (*proxier, error) {
+	if upstream == "" {
+		return nil, fmt.Errorf("proxy needs an -upstream address")
+	}
+
+	px := &proxier{base: base, writerOpts: writerOpts, stderr: stderr}
+	px.network, px.upstream = splitAddr(upstream)
+	switch direction {
+	case "", directionDown:
+		px.sanitizeDown = true
+	case directionUp:
+		px.sanitizeUp = true
+	case directionBoth:
+		px.sanitizeUp, px.sanitizeDown = true, true
+	default:
+		return nil, fmt.Errorf("unknown direction %s, expected %s, %s or %s", direction, directionDown, directionUp, directionBoth)
+	}
+
+	return px, nil
+}
+
+// splitAddr splits a unix:<path> or [tcp:]<host:port> address into its network and address
+func splitAddr(addr string) (network, address string) {
+	if network, address, ok := strings.Cut(addr, ":"); ok && (network == "unix" || network == "tcp") {
+		return network, address
+	}
+
+	return "tcp", addr
+}
+
+// serve accepts connections until the listener is closed
+func (px *proxier) serve(ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			px.handle(conn)
+		}()
+	}
+}
+
+// handle forwards a single connection. each connection has its own sanitizer,
+// so a tripwire rule only closes the connection it matched in
+func (px *proxier) handle(client net.Conn) {
+	defer client.Close()
+
+	upstream, err := net.Dial(px.network, px.upstream)
+	if err != nil {
+		fmt.Fprintf(px.stderr, "connecting to upstream: %v\n", err)
+		return
+	}
+	defer upstream.Close()
+
+	s := newSanitizer(px.base)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		px.pipe(upstream, client, px.sanitizeUp, s, "up")
+	}()
+	go func() {
+		defer wg.Done()
+		px.pipe(client, upstream, px.sanitizeDown, s, "down")
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst until src is exhausted, then closes the write side of dst.
+// if a tripwire rule matches, both connections are closed
+func (px *proxier) pipe(dst, src net.Conn, sanitize bool, s *execsanitize.Sanitizer, stream string) {
+	var w io.Writer = dst
+	var sw *execsanitize.SanitizerWriter
+	if sanitize {
+		sw = s.Writer(dst, append([]execsanitize.WriterOption{execsanitize.WithStream(stream)}, px.writerOpts...)...)
+		w = sw
+	}
+
+	_, err := io.Copy(w, src)
+	if sw != nil {
+		_ = sw.Flush()
+	}
+	if errors.Is(err, execsanitize.ErrTerminated) {
+		dst.Close()
+		src.Close()
+		return
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// runProxy runs the proxy until it fails or a signal is received
+func runProxy(parsedArgs *parsedArgs, base *execsanitize.Sanitizer, stderr io.Writer) int {
+	var writerOpts []execsanitize.WriterOption
+	if parsedArgs.lineBuffered {
+		writerOpts = append(writerOpts, execsanitize.LineBuffered())
+	}
+	px, err := newProxier(base, parsedArgs.upstream, parsedArgs.direction, writerOpts, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	if parsedArgs.listen == "" {
+		fmt.Fprintf(stderr, "proxy needs a -listen address\n")
+		return 1
+	}
+
+	ln, err := net.Listen(splitAddr(parsedArgs.listen))
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	go func() {
+		chanSig := make(chan os.Signal, 1)
+		signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
+		<-chanSig
+		ln.Close()
+	}()
+
+	fmt.Fprintf(stderr, "proxying %s to %s\n", ln.Addr(), parsedArgs.upstream)
+	if err := px.serve(ln); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
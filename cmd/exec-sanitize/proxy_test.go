@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func Test_proxier(t *testing.T) {
+	// the upstream echoes everything back, prefixed with what it received
+	upstreamLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstreamLn.Close()
+	go func() {
+		for {
+			conn, err := upstreamLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				_, _ = conn.Write(append([]byte("got: "), data...))
+			}()
+		}
+	}()
+
+	base := &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{
+		{
+			Name:    "auth",
+			Pattern: regexp.MustCompile(`Authorization: \S+`),
+			Replacer: func(string) string {
+				return "Authorization: <redacted>"
+			},
+		},
+		{
+			Name:    "boom",
+			Pattern: regexp.MustCompile(`boom`),
+			Action:  execsanitize.ActionTerminate,
+		},
+	}}
+
+	tcs := []struct {
+		direction, send, want string
+	}{
+		{"", "Authorization: abc\n", "got: Authorization: <redacted>\n"},
+		{"up", "Authorization: abc\n", "got: Authorization: <redacted>\n"},
+		{"both", "Authorization: abc\n", "got: Authorization: <redacted>\n"},
+		{"up", "boom", ""},
+	}
+	for _, tc := range tcs {
+		px, err := newProxier(base, "tcp:"+upstreamLn.Addr().String(), tc.direction, nil, io.Discard)
+		require.NoError(t, err)
+
+		ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "proxy.sock"))
+		require.NoError(t, err)
+		done := make(chan error)
+		go func() {
+			done <- px.serve(ln)
+		}()
+
+		conn, err := net.Dial("unix", ln.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(tc.send))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.UnixConn).CloseWrite())
+
+		var got bytes.Buffer
+		_, _ = io.Copy(&got, conn)
+		conn.Close()
+		assert.Equal(t, tc.want, got.String(), tc.direction)
+
+		ln.Close()
+		assert.NoError(t, <-done)
+	}
+
+	_, err = newProxier(base, "x:1", "sideways", nil, io.Discard)
+	assert.EqualError(t, err, "unknown direction sideways, expected down, up or both")
+	_, err = newProxier(base, "", "", nil, io.Discard)
+	assert.EqualError(t, err, "proxy needs an -upstream address")
+}
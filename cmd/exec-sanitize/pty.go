@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// runPTY runs a command attached to a pseudo-terminal, copying everything it prints to out.
+// if stdin is a terminal, it is switched to raw mode for the duration of the command so that
+// keystrokes and escape sequences are passed through to the child untouched. proc is set to the command's
+// process while it runs
+func runPTY(c *exec.Cmd, proc *runningProcess, stdin io.Reader, out io.Writer, prio priority) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	if f, ok := stdin.(*os.File); ok && isTerminal(f) {
+		_ = copyWinsize(f, slave)
+		if restore, err := makeRaw(f); err == nil {
+			defer restore()
+		}
+	}
+
+	c.Stdin, c.Stdout, c.Stderr = slave, slave, slave
+	c.SysProcAttr = ptySysProcAttr(c.SysProcAttr)
+	return prio.run(c, func() error {
+		if err := c.Start(); err != nil {
+			slave.Close()
+			return err
+		}
+		proc.set(c.Process)
+		return nil
+	}, func() error {
+		defer proc.set(nil)
+		done := make(chan struct{})
+		go func() {
+			// reading from the master fails with EIO once the child side is closed. that is only once the command
+			// has exited and the slave is closed below, as the kernel may otherwise drop output that it has yet to
+			// hand to the master when a command that exits right away closes the last file of the slave
+			_, _ = io.Copy(out, master)
+			close(done)
+		}()
+		if stdin != nil {
+			go func() {
+				_, _ = io.Copy(master, stdin)
+			}()
+		}
+
+		err := c.Wait()
+		slave.Close()
+		<-done
+		return err
+	})
+}
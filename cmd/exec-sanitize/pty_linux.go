@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY allocates a pseudo-terminal pair, returning the master and slave ends
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlocking pty: %w", err)
+	}
+
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("getting pty number: %w", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+// ptySysProcAttr makes the child a session leader with the pty on its stdin as controlling terminal,
+// keeping the other attributes of attr
+func ptySysProcAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Setsid, attr.Setctty, attr.Ctty = true, true, 0
+
+	return attr
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t))) == nil
+}
+
+// copyWinsize copies the window size of one terminal to another
+func copyWinsize(from, to *os.File) error {
+	var ws [4]uint16
+	if err := ioctl(from.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return err
+	}
+
+	return ioctl(to.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// makeRaw puts a terminal into raw mode, returning a function that restores its previous state
+func makeRaw(f *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
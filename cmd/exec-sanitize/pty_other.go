@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errPTYUnsupported = errors.New("pty mode is only supported on linux")
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errPTYUnsupported
+}
+
+func ptySysProcAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return attr
+}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func copyWinsize(from, to *os.File) error {
+	return errPTYUnsupported
+}
+
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errPTYUnsupported
+}
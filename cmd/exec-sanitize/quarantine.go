@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// -quarantine modes
+const (
+	// quarantineSuppress silently drops the output after the first leak
+	quarantineSuppress = "suppress"
+	// quarantineNotice drops the output after the first leak, writing a single notice to stderr instead
+	quarantineNotice = "notice"
+)
+
+// quarantine suppresses all output once a rule redacts a match, as output after a leak cannot be trusted either.
+// -threshold rules with the discard action use it as well
+type quarantine struct {
+	mode   string
+	notice io.Writer
+
+	active atomic.Bool
+}
+
+// matched quarantines the output if a match was redacted. it is meant for the sanitizer's OnMatch hook, so
+// the rest of the write the match was found in is suppressed as well
+func (q *quarantine) matched(m execsanitize.Match) {
+	if m.Action == execsanitize.ActionAlert || m.Action == execsanitize.ActionTerminate {
+		return
+	}
+	notice := ""
+	if q.mode == quarantineNotice {
+		notice = fmt.Sprintf("output quarantined: rule %s matched, the rest of the output is suppressed", m.Rule.Name)
+	}
+	q.activate(notice)
+}
+
+// activate suppresses the rest of the output, writing a notice to stderr the first time if it is not empty
+func (q *quarantine) activate(notice string) {
+	if !q.active.Swap(true) && notice != "" {
+		fmt.Fprintf(q.notice, "\n[exec-sanitize] %s\n", notice)
+	}
+}
+
+// writer drops everything written to w once the output is quarantined
+func (q *quarantine) writer(w io.Writer) io.Writer {
+	return &quarantineWriter{q: q, w: w}
+}
+
+type quarantineWriter struct {
+	q *quarantine
+	w io.Writer
+}
+
+func (qw *quarantineWriter) Write(p []byte) (int, error) {
+	if qw.q.active.Load() {
+		return len(p), nil
+	}
+
+	return qw.w.Write(p)
+}
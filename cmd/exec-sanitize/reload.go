@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// configPollInterval is how often -watch-config checks the configs for changes
+const configPollInterval = time.Second
+
+// ruleSet holds the parts the sanitizer's rules are assembled from, so that the rules of configs and secret
+// sources can be replaced while the command runs
+type ruleSet struct {
+	s *execsanitize.Sanitizer
+
+	mu sync.Mutex
+	// args are the values of the command's arguments and environment that other rules match, see checkArgs.
+	// flags are the rules given on the command line, which come before those of the configs,
+	// and rest those of packs, presets and gitleaks configs, which come after them
+	args, flags, configs, rest, secrets []*execsanitize.Rule
+}
+
+func (set *ruleSet) rules() []*execsanitize.Rule {
+	parts := [][]*execsanitize.Rule{set.args, set.flags, set.configs, set.rest, set.secrets}
+	var n int
+	for _, part := range parts {
+		n += len(part)
+	}
+
+	rules := make([]*execsanitize.Rule, 0, n)
+	for _, part := range parts {
+		rules = append(rules, part...)
+	}
+
+	return rules
+}
+
+func (set *ruleSet) setArgs(rules []*execsanitize.Rule) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.args = rules
+	set.s.SetRules(set.rules())
+}
+
+func (set *ruleSet) setConfigs(rules []*execsanitize.Rule) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.configs = rules
+	set.s.SetRules(set.rules())
+}
+
+func (set *ruleSet) setSecrets(rules []*execsanitize.Rule) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.secrets = rules
+	set.s.SetRules(set.rules())
+}
+
+// reloadConfigs reloads the configs in the background on SIGHUP, when config files change if watch is set,
+// and every refresh interval if it is positive, until ctx is done. the new rules are only installed if all
+// configs load, otherwise the previous ones are kept
+func reloadConfigs(ctx context.Context, set *ruleSet, l *configLoader, watch bool, refresh time.Duration, stderr io.Writer) {
+	// SIGHUP is handled from here on, rather than once the goroutine runs, so that it cannot terminate the process
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	modTimes := configModTimes(l.paths)
+
+	var (
+		tickers       []*time.Ticker
+		poll, refetch <-chan time.Time
+	)
+	if watch {
+		ticker := time.NewTicker(configPollInterval)
+		tickers, poll = append(tickers, ticker), ticker.C
+	}
+	if refresh > 0 {
+		ticker := time.NewTicker(refresh)
+		tickers, refetch = append(tickers, ticker), ticker.C
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		for _, ticker := range tickers {
+			defer ticker.Stop()
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			case <-refetch:
+			case <-poll:
+				current := configModTimes(l.paths)
+				if sameModTimes(modTimes, current) {
+					continue
+				}
+				modTimes = current
+			}
+
+			rules, err := l.load(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(stderr, "reloading rules: %v\n", err)
+				}
+				continue
+			}
+			set.setConfigs(rules)
+		}
+	}()
+}
+
+// configModTimes returns the modification times of the config files, zero for URLs and files that cannot be read
+func configModTimes(paths []string) []time.Time {
+	times := make([]time.Time, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+
+	return times
+}
+
+func sameModTimes(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// remoteConfigTimeout bounds fetching a remote config and its signature
+const remoteConfigTimeout = 10 * time.Second
+
+// maxRemoteConfigSize bounds the size of remote configs
+const maxRemoteConfigSize = 10 << 20
+
+// configLoader loads -config files and URLs. remote configs are fetched conditionally with their ETag,
+// so that unchanged configs are not downloaded again
+type configLoader struct {
+	paths []string
+	// profiles are the profiles of the configs to use
+	profiles []string
+	// maxProgramSize is the -max-regex-size the configs' rules must keep to, if set
+	maxProgramSize int
+	// publicKey verifies the signatures of remote configs, if set
+	publicKey ed25519.PublicKey
+	client    *http.Client
+
+	// remote holds the last version of each remote config
+	remote map[string]*remoteConfig
+}
+
+type remoteConfig struct {
+	etag  string
+	rules []*execsanitize.Rule
+}
+
+// configLoader sets up loading the -config files and URLs
+func (a *parsedArgs) configLoader() (*configLoader, error) {
+	l := &configLoader{
+		paths:          a.configs,
+		profiles:       a.configProfiles,
+		maxProgramSize: a.maxRegexSize,
+		client:         http.DefaultClient,
+		remote:         make(map[string]*remoteConfig),
+	}
+	if a.configPublicKey != "" {
+		data, err := ioutil.ReadFile(a.configPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading -config-public-key: %w", err)
+		}
+		if l.publicKey, err = parsePublicKey(data); err != nil {
+			return nil, fmt.Errorf("parsing -config-public-key: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// parsePublicKey parses an ed25519 public key, either PEM encoded as written by openssl or as base64
+func parsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("expected an ed25519 key, got %T", key)
+		}
+		return edKey, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
+
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// load loads the rules of all configs
+func (l *configLoader) load(ctx context.Context) ([]*execsanitize.Rule, error) {
+	var rules []*execsanitize.Rule
+	for _, path := range l.paths {
+		var (
+			config []*execsanitize.Rule
+			err    error
+		)
+		if isRemoteConfig(path) {
+			config, err = l.fetch(ctx, path)
+		} else {
+			config, err = loadRules(path, l.profiles)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, config...)
+	}
+	if err := checkProgramSizes(rules, l.maxProgramSize); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// fetch returns the rules of a remote config, downloading it only if it changed since it was last fetched
+func (l *configLoader) fetch(ctx context.Context, url string) ([]*execsanitize.Rule, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteConfigTimeout)
+	defer cancel()
+
+	cached := l.remote[url]
+	header := make(http.Header)
+	if cached != nil && cached.etag != "" {
+		header.Set("If-None-Match", cached.etag)
+	}
+	data, res, err := l.get(ctx, url, header)
+	if err != nil {
+		return nil, fmt.Errorf("fetching config %s: %w", url, err)
+	}
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.rules, nil
+	}
+
+	if l.publicKey != nil {
+		if err := l.verify(ctx, url, data); err != nil {
+			return nil, fmt.Errorf("verifying config %s: %w", url, err)
+		}
+	}
+
+	c, err := execsanitize.ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("loading config %s: %w", url, err)
+	}
+	if len(c.Include) > 0 {
+		return nil, fmt.Errorf("config %s: include is only supported in local configs", url)
+	}
+	rules, err := configRules(c, l.profiles)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", url, err)
+	}
+
+	l.remote[url] = &remoteConfig{etag: res.Header.Get("ETag"), rules: rules}
+	return rules, nil
+}
+
+// verify checks a remote config against the base64 ed25519 signature published next to it at <url>.sig
+func (l *configLoader) verify(ctx context.Context, url string, data []byte) error {
+	sig, _, err := l.get(ctx, url+".sig", nil)
+	if err != nil {
+		return fmt.Errorf("fetching signature: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if !ed25519.Verify(l.publicKey, data, raw) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// get fetches a URL, treating responses other than 200 and 304 as errors
+func (l *configLoader) get(ctx context.Context, url string, header http.Header) ([]byte, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if header.Get("If-None-Match") != "" {
+			return nil, res, nil
+		}
+		fallthrough
+	default:
+		return nil, nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxRemoteConfigSize {
+		return nil, nil, fmt.Errorf("larger than %d bytes", maxRemoteConfigSize)
+	}
+
+	return data, res, nil
+}
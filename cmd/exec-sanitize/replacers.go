@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"filippo.io/age"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/replacers"
+)
+
+const (
+	defaultMaskChar = '*'
+	// defaultIPv6Bits is the IPv6 prefix length kept by -r:anon-ip if only an IPv4 one is given
+	defaultIPv6Bits = 48
+)
+
+// replacerContext holds settings and state shared by replacers
+type replacerContext struct {
+	hashKey []byte
+	// randomKey is used by -r:anon-ip if there is no hashKey
+	randomKey []byte
+
+	// log is the -log directory of matches, if set
+	log *matchLog
+
+	// tokenizers are shared between all -r:tokenize rules with the same prefix
+	tokenizers map[string]*replacers.Tokenizer
+
+	// scripts are the -replacer-script files, whose functions -r:script calls
+	scripts []*replacers.Script
+
+	// errors receives the errors of replacers that fail while running, such as -r:exec
+	errors io.Writer
+
+	// attempt is the number of the current attempt at running the command, see -retries
+	attempt int64
+}
+
+// setAttempt records the number of the attempt at running the command that matches are found in
+func (rc *replacerContext) setAttempt(attempt int) {
+	atomic.StoreInt64(&rc.attempt, int64(attempt))
+}
+
+// anonymizationKey returns the -hash-key-file key, or a random key that is kept for the rest of the run
+func (rc *replacerContext) anonymizationKey() ([]byte, error) {
+	if rc.hashKey != nil {
+		return rc.hashKey, nil
+	}
+	if rc.randomKey == nil {
+		rc.randomKey = make([]byte, 32)
+		if _, err := rand.Read(rc.randomKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return rc.randomKey, nil
+}
+
+func (rc *replacerContext) tokenizer(prefix string) *replacers.Tokenizer {
+	if prefix == "" {
+		prefix = "SECRET"
+	}
+	if rc.tokenizers == nil {
+		rc.tokenizers = make(map[string]*replacers.Tokenizer)
+	}
+
+	t, ok := rc.tokenizers[prefix]
+	if !ok {
+		t = replacers.NewTokenizer("<" + strings.ReplaceAll(prefix, "%", "%%") + "-%d>")
+		rc.tokenizers[prefix] = t
+	}
+	return t
+}
+
+// writeTokenMap writes the mapping of all tokenizers to a file, encrypting it if any age recipients are given
+func (rc *replacerContext) writeTokenMap(path string, recipients []string) error {
+	ageRecipients := make([]age.Recipient, 0, len(recipients))
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return err
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+
+	mapping := make(map[string]string)
+	for _, t := range rc.tokenizers {
+		for token, value := range t.Mapping() {
+			mapping[token] = value
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if len(ageRecipients) == 0 {
+		err = writeJSON(f, mapping)
+	} else {
+		var encrypted io.WriteCloser
+		if encrypted, err = age.Encrypt(f, ageRecipients...); err == nil {
+			if err = writeJSON(encrypted, mapping); err == nil {
+				err = encrypted.Close()
+			}
+		}
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// replacerKinds are the kinds of -r:<kind> replacers buildReplacer builds
+var replacerKinds = []string{"mask", "mask-fixed", "hash", "preserve", "tokenize", "anon-ip", "exec", "script"}
+
+// buildReplacer builds a replacer from the spec of a -r:<kind>[:<params>] flag, without the -r: prefix, and the
+// flag's value for kinds that take one
+func (rc *replacerContext) buildReplacer(spec, value string) (execsanitize.ReplacerFunc, error) {
+	kind, params := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, params = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "mask":
+		if params == "" {
+			return replacers.MaskAll(defaultMaskChar), nil
+		}
+
+		nums, maskChar, err := maskParams(params, 2)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -r:%s: %w", spec, err)
+		}
+		return replacers.Mask(nums[0], nums[1], maskChar), nil
+	case "mask-fixed":
+		nums, maskChar, err := maskParams(params, 1)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -r:%s: %w", spec, err)
+		}
+		return replacers.MaskFixed(nums[0], maskChar), nil
+	case "hash":
+		var length int
+		if params != "" {
+			var err error
+			if length, err = strconv.Atoi(params); err != nil || length <= 0 {
+				return nil, fmt.Errorf("parsing -r:%s: invalid length %q", spec, params)
+			}
+		}
+		return replacers.Hash(rc.hashKey, length), nil
+	case "preserve":
+		if params == "" {
+			return replacers.FormatPreserving(0, 0), nil
+		}
+
+		nums, _, err := maskParams(params, 2)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -r:%s: %w", spec, err)
+		}
+		return replacers.FormatPreserving(nums[0], nums[1]), nil
+	case "tokenize":
+		return rc.tokenizer(params).Replace, nil
+	case "anon-ip":
+		if params == "" {
+			key, err := rc.anonymizationKey()
+			if err != nil {
+				return nil, err
+			}
+			return replacers.AnonymizeIP(key), nil
+		}
+
+		parts := strings.Split(params, ",")
+		bits := []int{0, defaultIPv6Bits}
+		if len(parts) > len(bits) {
+			return nil, fmt.Errorf("parsing -r:%s: expected IPv4 and optional IPv6 prefix lengths", spec)
+		}
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n > 32*(i*3+1) {
+				return nil, fmt.Errorf("parsing -r:%s: invalid prefix length %q", spec, part)
+			}
+			bits[i] = n
+		}
+		return replacers.TruncateIP(bits[0], bits[1]), nil
+	case "exec":
+		return rc.buildExec(spec, params, value)
+	case "script":
+		return rc.buildScript(params)
+	default:
+		return nil, fmt.Errorf("unknown replacer -r:%s", kind)
+	}
+}
+
+// buildExec builds the replacer of a -r:exec[:timeout[,concurrency]] flag
+func (rc *replacerContext) buildExec(spec, params, command string) (execsanitize.ReplacerFunc, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("parsing -r:%s: empty command", spec)
+	}
+
+	timeout, concurrency := replacers.DefaultExecTimeout, runtime.NumCPU()
+	if params != "" {
+		parts := strings.Split(params, ",")
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("parsing -r:%s: expected a timeout and an optional concurrency", spec)
+		}
+		var err error
+		if timeout, err = time.ParseDuration(parts[0]); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("parsing -r:%s: invalid timeout %q", spec, parts[0])
+		}
+		if len(parts) > 1 {
+			if concurrency, err = strconv.Atoi(parts[1]); err != nil || concurrency <= 0 {
+				return nil, fmt.Errorf("parsing -r:%s: invalid concurrency %q", spec, parts[1])
+			}
+		}
+	}
+
+	e := replacers.NewExecReplacer(fields[0], fields[1:], concurrency)
+	e.Timeout = timeout
+	if rc.errors != nil {
+		e.OnError = func(err error) {
+			fmt.Fprintf(rc.errors, "-r:exec: %v\n", err)
+		}
+	}
+
+	return e.Replace, nil
+}
+
+// buildScript builds the replacer of a -r:script[:function] flag from the -replacer-script that defines the function
+func (rc *replacerContext) buildScript(function string) (execsanitize.ReplacerFunc, error) {
+	if function == "" {
+		function = "replace"
+	}
+	if len(rc.scripts) == 0 {
+		return nil, fmt.Errorf("-r:script needs -replacer-script")
+	}
+
+	var script *replacers.Script
+	for _, sc := range rc.scripts {
+		if !sc.Defines(function) {
+			continue
+		}
+		if script != nil {
+			return nil, fmt.Errorf("parsing -r:script: both %s and %s define %s", script.Name, sc.Name, function)
+		}
+		script = sc
+	}
+	if script == nil {
+		return nil, fmt.Errorf("parsing -r:script: no -replacer-script defines %s", function)
+	}
+	if rc.errors != nil {
+		script.OnError = func(err error) {
+			fmt.Fprintf(rc.errors, "-r:script: %v\n", err)
+		}
+	}
+
+	return script.Replacer(function)
+}
+
+// buildTemplate builds the replacer of a -r:template:<template> flag. matches are numbered by the -log, if set
+func (rc *replacerContext) buildTemplate(name, params string) (execsanitize.MatchReplacerFunc, error) {
+	if !strings.HasPrefix(params, ":") {
+		return nil, fmt.Errorf("parsing -r:template: expected -r:template:<template>")
+	}
+	t, err := replacers.NewTemplateReplacer(params[1:], rc.hashKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing -r:template: %w", err)
+	}
+	if rc.log == nil {
+		return func(m execsanitize.Match) string {
+			data := t.Data(m)
+			data.Attempt = int(atomic.LoadInt64(&rc.attempt))
+			return t.Execute(data)
+		}, nil
+	}
+
+	return func(m execsanitize.Match) string {
+		data := t.Data(m)
+		data.Attempt = int(atomic.LoadInt64(&rc.attempt))
+		return rc.log.add(name, m.Text, func(idx int) string {
+			data.MatchIndex = int64(idx)
+			return t.Execute(data)
+		})
+	}, nil
+}
+
+// maskParams parses n comma-separated numbers optionally followed by a mask character
+func maskParams(params string, n int) ([]int, rune, error) {
+	parts := strings.Split(params, ",")
+	if len(parts) != n && len(parts) != n+1 {
+		return nil, 0, fmt.Errorf("expected %d numbers and an optional mask character", n)
+	}
+
+	nums := make([]int, n)
+	for i := range nums {
+		num, err := strconv.Atoi(parts[i])
+		if err != nil || num < 0 {
+			return nil, 0, fmt.Errorf("invalid number %q", parts[i])
+		}
+		nums[i] = num
+	}
+
+	maskChar := rune(defaultMaskChar)
+	if len(parts) > n {
+		if utf8.RuneCountInString(parts[n]) != 1 {
+			return nil, 0, fmt.Errorf("mask character must be a single character")
+		}
+		maskChar, _ = utf8.DecodeRuneInString(parts[n])
+	}
+
+	return nums, maskChar, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetryBackoff is the delay before the first retry if -retries is set without -retry-backoff
+const defaultRetryBackoff = time.Second
+
+// retryPolicy decides whether and when a failed command is run again
+type retryPolicy struct {
+	// retries is the number of times the command is run again after it fails
+	retries int
+	// backoff is the delay before the first retry, which doubles after every retry
+	backoff time.Duration
+	// exitCodes are the exit codes that are retried, any non-zero exit code if empty
+	exitCodes []int
+}
+
+// retry reports whether the command should be run again after an attempt, numbered from 1, failed with err.
+// commands that could not be started or were killed by a signal are not retried
+func (p retryPolicy) retry(attempt int, err error) bool {
+	var exerr *exec.ExitError
+	if attempt > p.retries || !errors.As(err, &exerr) || exerr.ExitCode() <= 0 {
+		return false
+	}
+	if len(p.exitCodes) == 0 {
+		return true
+	}
+
+	for _, code := range p.exitCodes {
+		if code == exerr.ExitCode() {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before retrying after an attempt
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	return backoff << (attempt - 1)
+}
+
+// parseExitCodes parses a comma-separated list of exit codes
+func parseExitCodes(s string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || code <= 0 || code > 255 {
+			return nil, fmt.Errorf("invalid exit code %q", part)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// retryCmd returns a command with the settings of c, which cannot be run again itself
+func retryCmd(ctx context.Context, c *exec.Cmd) *exec.Cmd {
+	next := exec.CommandContext(ctx, c.Path, c.Args[1:]...)
+	next.Args = c.Args
+	next.Env = c.Env
+	next.Dir = c.Dir
+	next.Stdin, next.Stdout, next.Stderr = c.Stdin, c.Stdout, c.Stderr
+	next.SysProcAttr = c.SysProcAttr
+
+	return next
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
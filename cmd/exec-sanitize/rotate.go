@@ -0,0 +1,210 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotatedTimeFormat is the suffix of rotated log files, which sorts in the order they were rotated in
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// rotation limits the size of a log file and how many of its rotated files are kept around
+type rotation struct {
+	// maxSize is the size above which the file is rotated
+	maxSize int64
+	// maxAge is how long rotated files are kept for
+	maxAge time.Duration
+	// maxCount is the number of rotated files to keep
+	maxCount int
+	// compress gzips rotated files
+	compress bool
+}
+
+func (r rotation) enabled() bool {
+	return r.maxSize > 0 || r.maxAge > 0 || r.maxCount > 0 || r.compress
+}
+
+// rotatingFile appends to a file, moving it aside to path.<time> once it grows past the rotation's max size
+type rotatingFile struct {
+	path string
+	rotation
+	now func() time.Time
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, r rotation) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, rotation: r, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	if err := rf.prune(); err != nil {
+		rf.f.Close()
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+
+	return nil
+}
+
+// Write writes b to the file, rotating it first if b would take it past its max size.
+// b is never split across files
+func (rf *rotatingFile) Write(b []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %w", rf.path, err)
+		}
+	}
+
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, compressing it if needed, prunes old rotated files and starts a new file
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	rotated := rf.path + "." + rf.now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return err
+	}
+	if rf.compress {
+		if err := gzipFile(rotated); err != nil {
+			return err
+		}
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return rf.prune()
+}
+
+// prune removes rotated files past the max count or older than the max age
+func (rf *rotatingFile) prune() error {
+	if rf.maxCount <= 0 && rf.maxAge <= 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	type rotatedFile struct {
+		path string
+		time time.Time
+	}
+	var files []rotatedFile
+	for _, path := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(path, rf.path+"."), ".gz")
+		t, err := time.Parse(rotatedTimeFormat, suffix)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: path, time: t})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].time.After(files[j].time)
+	})
+
+	cutoff := rf.now().Add(-rf.maxAge)
+	for i, file := range files {
+		if (rf.maxCount > 0 && i >= rf.maxCount) || (rf.maxAge > 0 && file.time.Before(cutoff)) {
+			if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.f.Close()
+}
+
+// gzipFile compresses a file to path.gz and removes the original
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// parseSize parses a size in bytes with an optional k, m or g suffix for KiB, MiB or GiB, which may be
+// spelled out as in 50MB or 50MiB
+func parseSize(s string) (int64, error) {
+	digits, shift := strings.ToLower(s), 0
+	for _, spelled := range []string{"ib", "b"} {
+		if unit := strings.TrimSuffix(digits, spelled); unit != digits && unit != "" && strings.ContainsAny(unit[len(unit)-1:], "kmg") {
+			digits = unit
+			break
+		}
+	}
+	if n := len(digits); n > 0 {
+		switch digits[n-1] {
+		case 'k':
+			shift = 10
+		case 'm':
+			shift = 20
+		case 'g':
+			shift = 30
+		}
+		if shift > 0 {
+			digits = digits[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n << shift, nil
+}
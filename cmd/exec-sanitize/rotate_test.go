@@ -0,0 +1,92 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matches.jsonl")
+	rf, err := openRotatingFile(path, rotation{maxSize: 10, maxCount: 2, compress: true})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		n, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+		assert.Equal(t, len(line), n)
+	}
+	require.NoError(t, rf.Close())
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "six\n", string(current))
+
+	rotated, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	sort.Strings(rotated)
+	require.Len(t, rotated, 2)
+
+	var contents []string
+	for _, name := range rotated {
+		assert.True(t, strings.HasSuffix(name, ".gz"), name)
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		f.Close()
+		contents = append(contents, string(data))
+	}
+	assert.Equal(t, []string{"three\n", "four\nfive\n"}, contents)
+}
+
+func Test_rotatingFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "matches.jsonl")
+	now := time.Now()
+	old := path + "." + now.Add(-48*time.Hour).UTC().Format(rotatedTimeFormat)
+	recent := path + "." + now.Add(-time.Hour).UTC().Format(rotatedTimeFormat) + ".gz"
+	unrelated := path + ".bak"
+	for _, name := range []string{old, recent, unrelated} {
+		require.NoError(t, os.WriteFile(name, nil, 0600))
+	}
+
+	rf, err := openRotatingFile(path, rotation{maxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, rf.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, filepath.Join(dir, entry.Name()))
+	}
+	assert.ElementsMatch(t, []string{path, recent, unrelated}, names)
+}
+
+func Test_parseSize(t *testing.T) {
+	for in, want := range map[string]int64{"0": 0, "512": 512, "4k": 4 << 10, "10M": 10 << 20, "2g": 2 << 30, "50MB": 50 << 20, "1GiB": 1 << 30} {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "k", "-1", "1.5m", "10x", "10b", "mb"} {
+		_, err := parseSize(in)
+		assert.True(t, err != nil && strings.Contains(err.Error(), "invalid size"), in)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// rulesCommand runs a rules subcommand, which works with rules without running a command
+func rulesCommand(stdout, stderr io.Writer, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "rules needs a subcommand: list or lint")
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			fmt.Fprintln(stderr, "rules list does not take arguments")
+			return 1
+		}
+		if err := listBuiltin(stdout); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		return 0
+	case "lint":
+		return lintRules(stdout, stderr, args[1:])
+	default:
+		fmt.Fprintf(stderr, "unknown rules subcommand %s\n", args[0])
+		return 1
+	}
+}
+
+// lintIssue is a problem found in a config, as printed by rules lint -format json
+type lintIssue struct {
+	Config string `json:"config"`
+	execsanitize.LintIssue
+}
+
+// lintRules lints config files, printing the problems found as text or, with -format json, as a JSON array.
+// it exits with code 1 if it finds any
+func lintRules(stdout, stderr io.Writer, args []string) int {
+	format := "text"
+	if len(args) >= 2 && args[0] == "-format" {
+		format, args = args[1], args[2:]
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintln(stderr, "-format must be text or json")
+		return 1
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "rules lint needs at least one config file")
+		return 1
+	}
+
+	issues := []lintIssue{}
+	for _, path := range args {
+		c, err := execsanitize.LoadConfig(path)
+		if err != nil {
+			issues = append(issues, lintIssue{Config: path, LintIssue: execsanitize.LintIssue{
+				Check:    "load",
+				Severity: execsanitize.LintError,
+				Message:  err.Error(),
+			}})
+			continue
+		}
+		for _, issue := range c.Lint() {
+			issues = append(issues, lintIssue{Config: path, LintIssue: issue})
+		}
+	}
+
+	if format == "json" {
+		if err := writeJSON(stdout, issues); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Fprintf(stdout, "%s: %v\n", issue.Config, issue.LintIssue)
+		}
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// testRules runs the test cases of config files, printing the result for each file. it returns 1 if any failed
+func testRules(stdout, stderr io.Writer, paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(stderr, "test needs at least one config file")
+		return 1
+	}
+
+	exitCode := 0
+	for _, path := range paths {
+		c, err := execsanitize.LoadConfig(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "loading config %s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		failures, err := c.RunTests()
+		if err != nil {
+			fmt.Fprintf(stderr, "config %s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+		if len(failures) > 0 {
+			fmt.Fprintf(stdout, "FAIL %s: %d of %d tests failed\n", path, len(failures), len(c.Tests))
+			for _, failure := range failures {
+				fmt.Fprintf(stdout, "    %v\n", failure)
+			}
+			exitCode = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "ok   %s: %d tests passed\n", path, len(c.Tests))
+	}
+
+	return exitCode
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// maxSARIFResults caps the results of a -report-sarif report, later matches are only counted
+const maxSARIFResults = 5000
+
+// sarifReport collects the matches of a run as the results of a SARIF log, for -report-sarif. results name the rule,
+// stream and line of a match, never its text
+type sarifReport struct {
+	// ruleID names the rules of matches, see execsanitize.Sanitizer.RuleID
+	ruleID func(*execsanitize.Rule) string
+
+	mu      sync.Mutex
+	rules   []sarifRule
+	index   map[*execsanitize.Rule]int
+	results []sarifResult
+	dropped int
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+	// Properties holds the number of matches left out past maxSARIFResults
+	Properties map[string]int `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Version        string      `json:"version,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+// sarifPhysicalLocation locates a match in a stream of the command's output, as there is no file to point to
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+func newSARIFReport(ruleID func(*execsanitize.Rule) string) *sarifReport {
+	return &sarifReport{ruleID: ruleID, index: make(map[*execsanitize.Rule]int)}
+}
+
+// matched adds a result for a match
+func (r *sarifReport) matched(m execsanitize.Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.results) >= maxSARIFResults {
+		r.dropped++
+		return
+	}
+
+	id := r.ruleID(m.Rule)
+	i, ok := r.index[m.Rule]
+	if !ok {
+		i = len(r.rules)
+		r.index[m.Rule] = i
+		r.rules = append(r.rules, sarifRule{
+			ID:               id,
+			ShortDescription: sarifMessage{Text: fmt.Sprintf("output matched rule %s", id)},
+		})
+	}
+
+	// alerts only report a match, other actions mean a secret reached the output, even if it was redacted
+	level := "error"
+	if m.Action == execsanitize.ActionAlert {
+		level = "warning"
+	}
+	stream := m.Stream
+	if stream == "" {
+		stream = "stdout"
+	}
+	loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: stream}}}
+	msg := fmt.Sprintf("%s matched rule %s", stream, id)
+	if m.Line > 0 {
+		loc.PhysicalLocation.Region = &sarifRegion{StartLine: m.Line}
+		msg = fmt.Sprintf("line %d of %s matched rule %s", m.Line, stream, id)
+	}
+	r.results = append(r.results, sarifResult{
+		RuleID:    id,
+		RuleIndex: i,
+		Level:     level,
+		Message:   sarifMessage{Text: msg},
+		Locations: []sarifLocation{loc},
+	})
+}
+
+// write writes the report to a file as a SARIF 2.1.0 log
+func (r *sarifReport) write(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "exec-sanitize",
+			InformationURI: "https://github.com/kamaln7/exec-sanitize",
+			Version:        currentBuild().Version,
+			Rules:          append([]sarifRule{}, r.rules...),
+		}},
+		Results: append([]sarifResult{}, r.results...),
+	}
+	if r.dropped > 0 {
+		run.Properties = map[string]int{"droppedResults": r.dropped}
+	}
+
+	f, err := openTee(path, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
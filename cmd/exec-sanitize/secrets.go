@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/vault"
+)
+
+// secretsTimeout bounds fetching the secrets of a single source
+const secretsTimeout = 10 * time.Second
+
+// secretSource is a parsed -secrets-from source or -secrets-file
+type secretSource struct {
+	uri string
+	src execsanitize.SecretSource
+	// replacement is the -secrets-replacement template, if set
+	replacement string
+}
+
+// secretSources parses the -secrets-from sources and -secrets-file files
+func (a *parsedArgs) secretSources() ([]secretSource, error) {
+	sources := make([]secretSource, 0, len(a.secretsFrom)+len(a.secretsFiles))
+	for _, uri := range a.secretsFrom {
+		switch {
+		case strings.HasPrefix(uri, "vault://"):
+			sources = append(sources, secretSource{uri: uri, src: &vault.Source{Path: strings.TrimPrefix(uri, "vault://")}})
+		default:
+			return nil, fmt.Errorf("unsupported secret source %s", uri)
+		}
+	}
+	for _, path := range a.secretsFiles {
+		sources = append(sources, secretSource{uri: path, src: &execsanitize.SecretFile{Path: path}})
+	}
+
+	for i := range sources {
+		sources[i].replacement = a.secretsReplacement
+	}
+
+	return sources, nil
+}
+
+// rules turns the source's secrets into rules named after the source and secret
+func (source secretSource) rules(secrets map[string]string) []*execsanitize.Rule {
+	if source.replacement == "" {
+		return execsanitize.SecretRules(source.uri+"#", secrets)
+	}
+
+	return execsanitize.SecretRulesFunc(source.uri+"#", secrets, func(name string) string {
+		return strings.ReplaceAll(source.replacement, "{name}", name)
+	})
+}
+
+// secretRules fetches the secrets of all sources and turns them into rules named after the source and secret
+func secretRules(ctx context.Context, sources []secretSource) ([]*execsanitize.Rule, error) {
+	var rules []*execsanitize.Rule
+	for _, source := range sources {
+		fetchCtx, cancel := context.WithTimeout(ctx, secretsTimeout)
+		secrets, err := source.src.Secrets(fetchCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("fetching secrets from %s: %w", source.uri, err)
+		}
+
+		rules = append(rules, source.rules(secrets)...)
+	}
+
+	return rules, nil
+}
+
+// refreshSecrets refetches the secrets every interval until ctx is done, installing them after the other rules.
+// if fetching fails, the previous secrets are kept
+func refreshSecrets(ctx context.Context, set *ruleSet, sources []secretSource, interval time.Duration, stderr io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		secrets, err := secretRules(ctx, sources)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(stderr, "refreshing secrets: %v\n", err)
+			}
+			continue
+		}
+		set.setSecrets(secrets)
+	}
+}
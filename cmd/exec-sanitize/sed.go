@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// parseSedExpr parses a sed-style s/pattern/replacement/flags expression of a -e flag into a rule.
+// any delimiter may be used instead of /. the pattern uses Go regexp syntax, similar to sed -E.
+// in the replacement, & is the whole match and \1 through \9 are capture groups.
+// flags are g to replace every match on a line instead of the first one, and i and m as in -p:regex
+func parseSedExpr(expr string) (parsedRule, error) {
+	if len(expr) < 2 || expr[0] != 's' || expr[1] == '\\' || expr[1] == '\n' {
+		return parsedRule{}, fmt.Errorf("parsing -e %s: expected s/pattern/replacement/flags", expr)
+	}
+
+	delim := expr[1]
+	parts := splitSedExpr(expr[2:], delim)
+	if len(parts) != 3 {
+		return parsedRule{}, fmt.Errorf("parsing -e %s: expected s/pattern/replacement/flags", expr)
+	}
+
+	rule := parsedRule{
+		pattern:      strings.ReplaceAll(parts[0], `\`+string(delim), regexp.QuoteMeta(string(delim))),
+		replacement:  sedReplacement(parts[1]),
+		expand:       true,
+		firstPerLine: true,
+	}
+
+	var modifiers string
+	for _, flag := range parts[2] {
+		switch flag {
+		case 'g':
+			rule.firstPerLine = false
+		case 'i', 'I':
+			modifiers += "i"
+		case 'm', 'M':
+			modifiers += "m"
+		default:
+			return parsedRule{}, fmt.Errorf("parsing -e %s: unsupported flag %q", expr, flag)
+		}
+	}
+	rule.pattern = withModifiers(rule.pattern, modifiers)
+
+	return rule, nil
+}
+
+// splitSedExpr splits an expression at unescaped delimiters, keeping escapes in place
+func splitSedExpr(expr string, delim byte) []string {
+	var (
+		parts []string
+		start int
+	)
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			i++
+		case delim:
+			parts = append(parts, expr[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, expr[start:])
+}
+
+// sedReplacement converts a sed replacement into a regexp.Expand template
+func sedReplacement(repl string) string {
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		case c == '\\' && i+1 < len(repl):
+			i++
+			switch next := repl[i]; {
+			case next >= '0' && next <= '9':
+				b.WriteString("${" + string(next) + "}")
+			case next == 'n':
+				b.WriteByte('\n')
+			case next == 't':
+				b.WriteByte('\t')
+			case next == '$':
+				b.WriteString("$$")
+			default:
+				// \&, \\ and the escaped delimiter are literal
+				b.WriteByte(next)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
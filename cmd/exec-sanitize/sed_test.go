@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSedExpr(t *testing.T) {
+	tcs := []struct {
+		expr    string
+		want    parsedRule
+		wantErr string
+	}{
+		{
+			expr: `s/password=\S+/password=***/g`,
+			want: parsedRule{pattern: `password=\S+`, replacement: "password=***", expand: true},
+		},
+		{
+			expr: `s|(token) ([a-z]+)|\1: & \\ $2|i`,
+			want: parsedRule{pattern: `(?i)(token) ([a-z]+)`, replacement: `${1}: ${0} \ $$2`, expand: true, firstPerLine: true},
+		},
+		{
+			expr: `s/a\/b/c\/d\&/`,
+			want: parsedRule{pattern: `a/b`, replacement: "c/d&", expand: true, firstPerLine: true},
+		},
+		{
+			expr: `s,a\,b,x,gm`,
+			want: parsedRule{pattern: `(?m)a,b`, replacement: "x", expand: true},
+		},
+		{
+			expr: `s|a\|b|x|`,
+			want: parsedRule{pattern: `a\|b`, replacement: "x", expand: true, firstPerLine: true},
+		},
+		{expr: "s/a/b", wantErr: "parsing -e s/a/b: expected s/pattern/replacement/flags"},
+		{expr: "y/abc/xyz/", wantErr: "parsing -e y/abc/xyz/: expected s/pattern/replacement/flags"},
+		{expr: "s/a/b/2", wantErr: "parsing -e s/a/b/2: unsupported flag '2'"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.expr, func(t *testing.T) {
+			rule, err := parseSedExpr(tc.expr)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, rule)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+const (
+	defaultListenAddr = "localhost:8080"
+	// defaultProfile is the name of the profile made of the rules given on the command line
+	defaultProfile = "default"
+	// maxSanitizeBody bounds the size of texts submitted to /sanitize, /stream is not limited
+	maxSanitizeBody = 10 << 20
+	// matchesTrailer holds the number of matches found by /stream
+	matchesTrailer = "X-Exec-Sanitize-Matches"
+)
+
+// server sanitizes text submitted over HTTP using named rule profiles
+type server struct {
+	profiles map[string]*execsanitize.Sanitizer
+	mux      *http.ServeMux
+	// pipeline sanitizes /stream requests on a pool of workers, if set
+	pipeline *execsanitize.Pipeline
+	// spillAfter is the size of a /stream line above which it is spilled to disk, see -spill-after
+	spillAfter int
+}
+
+// newServer creates a server. base holds the rules of the default profile and the settings used by all profiles
+func newServer(base *execsanitize.Sanitizer, profiles []string) (*server, error) {
+	srv := &server{
+		profiles: map[string]*execsanitize.Sanitizer{defaultProfile: base},
+		mux:      http.NewServeMux(),
+	}
+	for _, p := range profiles {
+		name, path, ok := strings.Cut(p, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid profile %s, expected name=path", p)
+		}
+
+		rules, err := loadRules(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		p := newSanitizer(base)
+		p.Rules = rules
+		srv.profiles[name] = p
+	}
+
+	srv.mux.HandleFunc("/sanitize", srv.handleSanitize)
+	srv.mux.HandleFunc("/stream", srv.handleStream)
+	return srv, nil
+}
+
+func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// sanitizer returns a fresh sanitizer for a request, so that limits and statistics are not shared between requests
+func (srv *server) sanitizer(w http.ResponseWriter, r *http.Request) *execsanitize.Sanitizer {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		name = defaultProfile
+	}
+	p, ok := srv.profiles[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown profile %s", name), http.StatusNotFound)
+		return nil
+	}
+
+	return newSanitizer(p)
+}
+
+// serveMatch describes a match without the matched text
+type serveMatch struct {
+	Rule        string `json:"rule"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+type sanitizeResponse struct {
+	Text    string       `json:"text"`
+	Matches []serveMatch `json:"matches"`
+	// Terminated is set if a tripwire rule matched, in which case Text is empty
+	Terminated bool `json:"terminated,omitempty"`
+}
+
+// handleSanitize sanitizes the request body and responds with the sanitized text and the matches found in it
+func (srv *server) handleSanitize(w http.ResponseWriter, r *http.Request) {
+	s := srv.sanitizer(w, r)
+	if s == nil {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSanitizeBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := sanitizeResponse{Matches: []serveMatch{}}
+	s.OnMatch = func(m execsanitize.Match) {
+		resp.Matches = append(resp.Matches, serveMatch{
+			Rule:        m.Rule.Name,
+			Start:       m.Start,
+			End:         m.End,
+			Replacement: m.Replacement,
+		})
+	}
+	resp.Text = s.Sanitize(string(body))
+	resp.Terminated = s.Terminated()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleStream sanitizes the request body line by line as it arrives, streaming the result back.
+// the number of matches is sent in a trailer
+func (srv *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	s := srv.sanitizer(w, r)
+	if s == nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// without full duplex, writing the response would discard the rest of the request body
+	_ = rc.EnableFullDuplex()
+
+	var matches atomic.Int64
+	s.OnMatch = func(execsanitize.Match) {
+		matches.Add(1)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", matchesTrailer)
+	var (
+		out  = io.Writer(w)
+		opts = []execsanitize.WriterOption{execsanitize.LineBuffered()}
+	)
+	if srv.spillAfter > 0 {
+		opts = append(opts, execsanitize.SpillAfter(srv.spillAfter, ""))
+	}
+	if srv.pipeline != nil {
+		// the pipeline's workers write the output, so they flush it too, until the writer is flushed below
+		out = flushingWriter{w: w, flush: rc.Flush}
+		opts = append(opts, execsanitize.WithPipeline(srv.pipeline))
+	}
+	sw := s.Writer(out, opts...)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			if _, werr := sw.Write(buf[:n]); werr != nil {
+				break
+			}
+			if srv.pipeline == nil {
+				_ = rc.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = sw.Flush()
+
+	w.Header().Set(matchesTrailer, strconv.FormatInt(matches.Load(), 10))
+}
+
+// flushingWriter flushes each write through to the client
+type flushingWriter struct {
+	w     io.Writer
+	flush func() error
+}
+
+func (fw flushingWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, fw.flush()
+}
+
+// serve runs the HTTP server until it fails or a signal is received
+func serve(parsedArgs *parsedArgs, base *execsanitize.Sanitizer, stderr io.Writer) int {
+	srv, err := newServer(base, parsedArgs.profiles)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	srv.spillAfter = int(parsedArgs.spillAfter)
+	if parsedArgs.workers > 0 {
+		srv.pipeline = execsanitize.NewPipeline(parsedArgs.workers)
+		defer srv.pipeline.Close()
+	}
+
+	addr := parsedArgs.listen
+	if addr == "" {
+		addr = defaultListenAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	httpServer := &http.Server{
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		chanSig := make(chan os.Signal, 1)
+		signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
+		<-chanSig
+
+		grace := parsedArgs.killGrace
+		if grace <= 0 {
+			grace = defaultKillGrace
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		_ = httpServer.Shutdown(ctx)
+	}()
+
+	fmt.Fprintf(stderr, "listening on %s\n", ln.Addr())
+	if err := httpServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
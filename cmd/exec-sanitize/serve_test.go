@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func Test_server(t *testing.T) {
+	dir := t.TempDir()
+	profilePath := filepath.Join(dir, "emails.yaml")
+	require.NoError(t, os.WriteFile(profilePath, []byte(`
+rules:
+  - name: email
+    regex: '\w+@example\.com'
+    replace: '<email>'
+`), 0644))
+
+	base := &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{{
+		Name:    "password",
+		Pattern: regexp.MustCompile(`hunter2`),
+		Replacer: func(string) string {
+			return "***"
+		},
+	}}}
+	srv, err := newServer(base, []string{"emails=" + profilePath})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/sanitize", "text/plain", strings.NewReader("password: hunter2 hunter2"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var sanitized sanitizeResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sanitized))
+	assert.Equal(t, sanitizeResponse{
+		Text: "password: *** ***",
+		Matches: []serveMatch{
+			{Rule: "password", Start: 10, End: 17, Replacement: "***"},
+			{Rule: "password", Start: 18, End: 25, Replacement: "***"},
+		},
+	}, sanitized)
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("from joe@example.com\nhunter2 "))
+		_, _ = pw.Write([]byte("jane@example.com"))
+		pw.Close()
+	}()
+	resp, err = http.Post(ts.URL+"/stream?profile=emails", "text/plain", pr)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "from <email>\nhunter2 <email>", string(body))
+	assert.Equal(t, "2", resp.Trailer.Get(matchesTrailer))
+
+	// with a pipeline, its workers write the output
+	srv.pipeline = execsanitize.NewPipeline(2)
+	defer srv.pipeline.Close()
+	resp, err = http.Post(ts.URL+"/stream?profile=emails", "text/plain", strings.NewReader("from joe@example.com\nhunter2 jane@example.com"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "from <email>\nhunter2 <email>", string(body))
+	assert.Equal(t, "2", resp.Trailer.Get(matchesTrailer))
+
+	resp, err = http.Post(ts.URL+"/sanitize?profile=nope", "text/plain", strings.NewReader(""))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/sanitize")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	_, err = newServer(base, []string{"emails"})
+	assert.EqualError(t, err, "invalid profile emails, expected name=path")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// jsonSummary is the document written by -summary-json
+type jsonSummary struct {
+	// Command is the command and its arguments, empty when filtering stdin
+	Command         []string         `json:"command"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	ExitCode        int              `json:"exit_code"`
+	BytesProcessed  int64            `json:"bytes_processed"`
+	BytesByStream   map[string]int64 `json:"bytes_by_stream"`
+	// BytesDroppedByStream is the output of each stream dropped by -max-output
+	BytesDroppedByStream map[string]int64 `json:"bytes_dropped_by_stream,omitempty"`
+	Rules                []jsonRuleStats  `json:"rules"`
+}
+
+type jsonRuleStats struct {
+	Name    string `json:"name"`
+	Matches int64  `json:"matches"`
+}
+
+// newJSONSummary returns the summary of a run, naming its rules with ruleID, see execsanitize.Sanitizer.RuleID
+func newJSONSummary(a *parsedArgs, st execsanitize.Stats, ruleID func(*execsanitize.Rule) string, duration time.Duration, exitCode int) *jsonSummary {
+	summary := &jsonSummary{
+		Command:         []string{},
+		DurationSeconds: duration.Seconds(),
+		ExitCode:        exitCode,
+		BytesProcessed:  st.BytesProcessed,
+		BytesByStream:   st.BytesByStream,
+		Rules:           make([]jsonRuleStats, 0, len(st.Rules)),
+	}
+	if a.cmd != "" {
+		summary.Command = append([]string{a.cmd}, a.cmdArgs...)
+	}
+	for _, rs := range st.Rules {
+		summary.Rules = append(summary.Rules, jsonRuleStats{Name: ruleID(rs.Rule), Matches: rs.Matches})
+	}
+
+	return summary
+}
+
+// writeJSONSummary writes a summary to a -summary-json destination, a file path or fd:N for an open file descriptor
+func writeJSONSummary(dest string, summary *jsonSummary) error {
+	var w io.WriteCloser
+	if fd := strings.TrimPrefix(dest, "fd:"); fd != dest {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid file descriptor %s", dest)
+		}
+		w = os.NewFile(uintptr(n), dest)
+	} else {
+		f, err := openTee(dest, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(summary); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,17 @@
+package main
+
+import "os"
+
+// openTee creates or truncates a -tee-* file. the mode is applied to existing files as well
+func openTee(path string, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
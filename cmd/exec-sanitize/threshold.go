@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// -threshold actions
+const (
+	// thresholdNotify writes a notice to stderr
+	thresholdNotify = "notify"
+	// thresholdDiscard discards the rest of the output
+	thresholdDiscard = "discard"
+	// thresholdKill terminates the command
+	thresholdKill = "kill"
+)
+
+// thresholdSpec is a -threshold flag, <rule>:<count>/<window>:<action>
+type thresholdSpec struct {
+	// rule is the name of the rule, or * for all rules
+	rule   string
+	count  int
+	window time.Duration
+	action string
+}
+
+func parseThreshold(s string) (thresholdSpec, error) {
+	var (
+		spec   thresholdSpec
+		errFmt = fmt.Errorf("expected <rule>:<count>/<window>:<action>")
+	)
+	// rule names may contain colons, so the spec is split from the right
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return spec, errFmt
+	}
+	j := strings.LastIndex(s[:i], ":")
+	if j <= 0 {
+		return spec, errFmt
+	}
+	spec.rule, spec.action = s[:j], s[i+1:]
+
+	count, window, ok := strings.Cut(s[j+1:i], "/")
+	if !ok {
+		return spec, fmt.Errorf("expected <count>/<window>, e.g. 1000/1m")
+	}
+	var err error
+	if spec.count, err = strconv.Atoi(count); err != nil || spec.count <= 0 {
+		return spec, fmt.Errorf("invalid count %q", count)
+	}
+	if spec.window, err = time.ParseDuration(window); err != nil || spec.window <= 0 {
+		return spec, fmt.Errorf("invalid window %q", window)
+	}
+
+	switch spec.action {
+	case thresholdNotify, thresholdDiscard, thresholdKill:
+	default:
+		return spec, fmt.Errorf("action must be %s, %s or %s", thresholdNotify, thresholdDiscard, thresholdKill)
+	}
+
+	return spec, nil
+}
+
+// thresholdHooks returns the OnMatch hooks of -threshold flags. discard thresholds activate q, and kill thresholds
+// call kill with the reason
+func thresholdHooks(specs []thresholdSpec, stderr io.Writer, q *quarantine, kill func(reason string)) []func(execsanitize.Match) {
+	hooks := make([]func(execsanitize.Match), 0, len(specs))
+	for _, spec := range specs {
+		spec := spec
+		th := &execsanitize.Threshold{Count: spec.count, Window: spec.window}
+		if spec.rule != "*" {
+			th.Rule = spec.rule
+		}
+		th.OnExceeded = func(rule *execsanitize.Rule) {
+			reason := fmt.Sprintf("rule %s matched %d times within %s", rule.Name, spec.count, spec.window)
+			switch spec.action {
+			case thresholdNotify:
+				fmt.Fprintf(stderr, "\n[exec-sanitize] %s\n", reason)
+			case thresholdDiscard:
+				q.activate(reason + ", the rest of the output is discarded")
+			case thresholdKill:
+				kill(reason)
+			}
+		}
+		hooks = append(hooks, th.Add)
+	}
+
+	return hooks
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize/presets"
+)
+
+// set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=...", see the Makefile
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes the exec-sanitize binary
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+// currentBuild returns the version, commit and date the binary was built with. values not set at build time are
+// taken from the module and VCS information go embeds, as in binaries built with go install
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, Date: date}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "(devel)" {
+			b.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = setting.Value
+			case setting.Key == "vcs.time" && b.Date == "":
+				b.Date = setting.Value
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+
+	return b
+}
+
+func printVersion(w io.Writer) {
+	b := currentBuild()
+	fmt.Fprintf(w, "exec-sanitize %s", b.Version)
+	if b.Commit != "" {
+		fmt.Fprintf(w, " commit %s", b.Commit)
+	}
+	if b.Date != "" {
+		fmt.Fprintf(w, " built %s", b.Date)
+	}
+	fmt.Fprintln(w)
+}
+
+// capabilities describes what the binary supports, for tools that generate exec-sanitize command lines
+type capabilities struct {
+	buildInfo
+	Subcommands []string `json:"subcommands"`
+	// Patterns are the kinds of -p:<kind> flags
+	Patterns []string `json:"patterns"`
+	// Replacers are the kinds of -r:<kind> flags
+	Replacers   []string `json:"replacers"`
+	Presets     []string `json:"presets"`
+	LogBackends []string `json:"log_backends"`
+}
+
+func printCapabilities(w io.Writer) error {
+	return writeJSON(w, capabilities{
+		buildInfo:   currentBuild(),
+		Subcommands: subcommands,
+		Patterns:    patternKinds,
+		Replacers:   replacerKinds,
+		Presets:     presets.Packs(),
+		LogBackends: []string{logBackendDir, logBackendJSONL, logBackendSQLite},
+	})
+}
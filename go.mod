@@ -1,5 +1,19 @@
 module github.com/kamaln7/exec-sanitize/v2
 
-go 1.14
+go 1.21
 
-require github.com/stretchr/testify v1.6.1
+require (
+	filippo.io/age v1.1.1
+	github.com/BurntSushi/toml v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/stretchr/testify v1.6.1
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/crypto v0.4.0 // indirect
+	golang.org/x/sys v0.3.0 // indirect
+)
@@ -0,0 +1,48 @@
+package execsanitize
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Action is what a rule does with its matches
+type Action int
+
+const (
+	// ActionReplace replaces each match with the output of the rule's Replacer
+	ActionReplace Action = iota
+	// ActionDiscardLine drops every line containing a match, including its line ending
+	ActionDiscardLine
+	// ActionDiscardWrite drops the entire text being sanitized, i.e. the whole write
+	ActionDiscardWrite
+	// ActionAlert leaves matches untouched, only reporting them
+	ActionAlert
+	// ActionTerminate drops the text and marks the sanitizer as terminated, after which its writers fail
+	// with ErrTerminated. embedders should stop the producer of the output, e.g. from an OnMatch hook. the
+	// sanitizer stays terminated, so it cannot be reused for other output afterwards
+	ActionTerminate
+)
+
+// ErrTerminated is returned by writers once a rule with ActionTerminate has matched
+var ErrTerminated = errors.New("output terminated by rule match")
+
+var actionNames = []string{"replace", "discard-line", "discard-write", "alert", "terminate"}
+
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+
+	return actionNames[a]
+}
+
+// ParseAction parses an action from its String form
+func ParseAction(s string) (Action, error) {
+	for i, name := range actionNames {
+		if name == s {
+			return Action(i), nil
+		}
+	}
+
+	return 0, withKind(ErrUnknownAction, fmt.Errorf("unknown action %s", s))
+}
@@ -0,0 +1,144 @@
+package execsanitize
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActions(t *testing.T) {
+	withAction := func(a Action, rules []*Rule) []*Rule {
+		rules[len(rules)-1].Action = a
+		return rules
+	}
+
+	tcs := []struct {
+		name  string
+		rules []*Rule
+		tests [][]string
+	}{
+		{
+			name:  "replacement equal to the discard token is kept",
+			rules: withAction(ActionReplace, makeRules("x", "y", "token", "@discard")),
+			tests: [][]string{
+				{"x", "y"},
+			},
+		},
+		{
+			name:  "discard line",
+			rules: withAction(ActionDiscardLine, makeRules("hi", "hello", "secret", "")),
+			tests: [][]string{
+				{"hi\na secret\nthere secret secret\nbye\n", "hello\nbye\n"},
+				{"first secret\nsecond", "second"},
+				{"first\nlast secret", "first\n"},
+				{"only secret", ""},
+				{"nobody here", "nobody here"},
+			},
+		},
+		{
+			name:  "discard write",
+			rules: withAction(ActionDiscardWrite, makeRules("secret", "@discard")),
+			tests: [][]string{
+				{"hi\na secret\n", ""},
+				{"hi\n", "hi\n"},
+			},
+		},
+		{
+			name:  "alert",
+			rules: withAction(ActionAlert, makeRules("secret", "<redacted>")),
+			tests: [][]string{
+				{"a secret", "a secret"},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var matches int
+			s := &Sanitizer{
+				Rules: tc.rules,
+				OnMatch: func(Match) {
+					matches++
+				},
+			}
+			for _, c := range tc.tests {
+				assert.Equal(t, c[1], s.Sanitize(c[0]))
+			}
+			assert.NotZero(t, matches)
+		})
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	rules := makeRules(regexp.MustCompile(`postgres://\S+`), "")
+	rules[0].Action = ActionTerminate
+	s := &Sanitizer{Rules: rules}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered())
+	_, err := w.Write([]byte("connecting\n"))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("dsn: postgres://user:pass@db/prod\nmore output\n"))
+	assert.Equal(t, ErrTerminated, err)
+	assert.True(t, s.Terminated())
+
+	n, err := w.Write([]byte("even more\n"))
+	assert.Zero(t, n)
+	assert.Equal(t, ErrTerminated, err)
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, "connecting\n", buf.String())
+}
+
+func TestActionPolicy(t *testing.T) {
+	for _, exclusive := range []bool{false, true} {
+		var actions []Action
+		rules := makeRules("secret", "<redacted>", "token", "<token>")
+		rules[0].Name, rules[1].Name = "secret", "token"
+		s := &Sanitizer{
+			Rules: rules,
+			// secrets are dropped along with their lines on stderr, and tokens only reported if they are short
+			ActionPolicy: func(m Match) Action {
+				switch {
+				case m.Rule.Name == "secret" && m.Stream == "stderr":
+					return ActionDiscardLine
+				case m.Rule.Name == "token" && m.Line > 1:
+					return ActionAlert
+				}
+				return m.Action
+			},
+			OnMatch: func(m Match) {
+				actions = append(actions, m.Action)
+			},
+			Exclusive: exclusive,
+		}
+
+		var stdout, stderr bytes.Buffer
+		_, err := s.Writer(&stdout, WithStream("stdout")).Write([]byte("a secret token\nanother token\n"))
+		require.NoError(t, err)
+		_, err = s.Writer(&stderr, WithStream("stderr")).Write([]byte("a secret token\nplain\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "a <redacted> <token>\nanother token\n", stdout.String())
+		assert.Equal(t, "plain\n", stderr.String())
+		expect := []Action{ActionReplace, ActionReplace, ActionAlert, ActionDiscardLine}
+		if exclusive {
+			// the token of the dropped line is only matched if rules match the original text
+			expect = append(expect, ActionReplace)
+		}
+		assert.Equal(t, expect, actions)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for a := ActionReplace; a <= ActionTerminate; a++ {
+		parsed, err := ParseAction(a.String())
+		require.NoError(t, err)
+		assert.Equal(t, a, parsed)
+	}
+	assert.Equal(t, "Action(9)", Action(9).String())
+}
@@ -0,0 +1,77 @@
+package execsanitize
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// ansiPattern matches CSI sequences (including SGR colors), OSC sequences and two-byte escapes
+var ansiPattern = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// escape is an ANSI escape sequence stripped from a text at a byte offset of the stripped text
+type escape struct {
+	pos int
+	seq string
+}
+
+// stripANSI removes ANSI escape sequences from a text, returning them with their positions
+func stripANSI(in string) (string, []escape) {
+	locs := ansiPattern.FindAllStringIndex(in, -1)
+	if locs == nil {
+		return in, nil
+	}
+
+	var (
+		b       strings.Builder
+		escapes = make([]escape, 0, len(locs))
+		last    int
+	)
+	for _, loc := range locs {
+		b.WriteString(in[last:loc[0]])
+		escapes = append(escapes, escape{pos: b.Len(), seq: in[loc[0]:loc[1]]})
+		last = loc[1]
+	}
+	b.WriteString(in[last:])
+
+	return b.String(), escapes
+}
+
+// onlyEscapes reports whether b holds nothing but NUL bytes and ANSI escape sequences
+func onlyEscapes(b []byte) bool {
+	return len(bytes.Trim(ansiPattern.ReplaceAll(b, nil), "\x00")) == 0
+}
+
+// restoreANSI inserts escape sequences back into a text
+func restoreANSI(in string, escapes []escape) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, e := range escapes {
+		b.WriteString(in[last:e.pos])
+		b.WriteString(e.seq)
+		last = e.pos
+	}
+	b.WriteString(in[last:])
+
+	return b.String()
+}
+
+// remapEscapes moves escape positions to account for edits. escapes inside an edited range are moved after it
+func remapEscapes(escapes []escape, edits []edit) {
+	var j, shift int
+	for i := range escapes {
+		pos := escapes[i].pos
+		for j < len(edits) && edits[j].start < pos && edits[j].end <= pos {
+			shift += len(edits[j].repl) - (edits[j].end - edits[j].start)
+			j++
+		}
+
+		if j < len(edits) && edits[j].start < pos {
+			escapes[i].pos = edits[j].start + shift + len(edits[j].repl)
+		} else {
+			escapes[i].pos = pos + shift
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package execsanitize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// syntheticLog returns about size bytes of log lines, a few of which hold secrets
+func syntheticLog(size int) []byte {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"GET", "POST", "/api/v1/users", "200", "404", "took", "ms", "request", "upstream", "cache", "miss", "hit"}
+
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "2024-01-02T15:04:05.%06dZ level=info", i)
+		for j := 0; j < 8; j++ {
+			b.WriteString(" " + words[r.Intn(len(words))])
+		}
+		if i%100 == 0 {
+			b.WriteString(" token=tok_" + strings.Repeat("x", 20) + " password=hunter2")
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes()
+}
+
+// manyRules returns n literal rules with constant replacements and a few regexp rules
+func manyRules(n int) []*Rule {
+	var args []interface{}
+	for i := 0; i < n; i++ {
+		args = append(args, fmt.Sprintf("secret-%03d", i), "<secret>")
+	}
+	args = append(args,
+		regexp.MustCompile(`tok_\w+`), "<token>",
+		regexp.MustCompile(`password=\S+`), "password=***",
+		regexp.MustCompile(`\b[\w.]+@[\w.]+\.com\b`), "<email>",
+	)
+
+	return constantRules(args...)
+}
+
+// benchmarkWriter writes in to a writer in chunks of chunk bytes, as a pipe would hand them over
+func benchmarkWriter(b *testing.B, s *Sanitizer, in []byte, chunk int, opts ...WriterOption) {
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := s.Writer(io.Discard, opts...)
+		for off := 0; off < len(in); off += chunk {
+			end := min(off+chunk, len(in))
+			if _, err := w.Write(in[off:end]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	log := syntheticLog(4 << 20)
+	clean := bytes.ReplaceAll(bytes.ReplaceAll(log, []byte("tok_"), []byte("tok-")), []byte("password="), []byte("pass: "))
+
+	b.Run("no rules", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{}, log, 32<<10)
+	})
+	b.Run("passthrough", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, clean, 32<<10)
+	})
+	b.Run("passthrough line-buffered", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, clean, 32<<10, LineBuffered())
+	})
+	b.Run("matches", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, log, 32<<10)
+	})
+	b.Run("matches line-buffered", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, log, 32<<10, LineBuffered())
+	})
+	b.Run("matches line-buffered pipeline", func(b *testing.B) {
+		pl := NewPipeline(0)
+		defer pl.Close()
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, log, 32<<10, LineBuffered(), WithPipeline(pl))
+	})
+	b.Run("small writes", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(100)}, log[:256<<10], 64, LineBuffered())
+	})
+}
+
+func BenchmarkWriterLongLines(b *testing.B) {
+	// a single line without newlines, as printed by minified JSON or progress bars
+	line := bytes.Repeat([]byte("abcdefghij"), 1<<20)
+	line[len(line)/2] = ' '
+	copy(line[len(line)/3:], "password=hunter2 ")
+
+	b.Run("line-buffered", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(10)}, line, 32<<10, LineBuffered())
+	})
+	b.Run("unbuffered", func(b *testing.B) {
+		benchmarkWriter(b, &Sanitizer{Rules: manyRules(10)}, line, 32<<10)
+	})
+}
+
+func BenchmarkSanitize(b *testing.B) {
+	in := string(syntheticLog(64 << 10))
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("%d rules", n), func(b *testing.B) {
+			s := &Sanitizer{Rules: manyRules(n)}
+			b.SetBytes(int64(len(in)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.Sanitize(in)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package execsanitize
+
+import (
+	"regexp"
+	"time"
+)
+
+// ProgramSize returns the number of instructions of a regexp's compiled program, which the time and memory it takes
+// to match text grow with
+func ProgramSize(re *regexp.Regexp) int {
+	size, _ := programSize(re.String())
+	return size
+}
+
+// find finds the matches of a rule, checking the time it took against the sanitizer's RuleTimeBudget
+func (s *Sanitizer) find(rule *Rule, in string, p *pass) (locs [][]int, cont []int) {
+	if s.RuleTimeBudget <= 0 {
+		return p.find(rule, in)
+	}
+
+	start := time.Now()
+	locs, cont = p.find(rule, in)
+	if took := time.Since(start); took > s.RuleTimeBudget {
+		s.slowRule(rule, took)
+	}
+
+	return locs, cont
+}
+
+// slowRule reports a rule that went over its time budget, disabling it if DisableSlowRules is set
+func (s *Sanitizer) slowRule(rule *Rule, took time.Duration) {
+	if s.DisableSlowRules {
+		if _, loaded := s.disabledRules.LoadOrStore(rule, struct{}{}); loaded {
+			return
+		}
+		// rebuild the fast path without the rule, so that it stops matching its pattern too
+		s.prefilter.Store(nil)
+	}
+	if s.OnSlowRule != nil {
+		s.OnSlowRule(rule, took)
+	}
+}
+
+// disabled reports whether a rule was disabled for going over its time budget
+func (s *Sanitizer) disabled(rule *Rule) bool {
+	if !s.DisableSlowRules {
+		return false
+	}
+	_, ok := s.disabledRules.Load(rule)
+	return ok
+}
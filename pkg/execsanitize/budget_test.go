@@ -0,0 +1,45 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleTimeBudget(t *testing.T) {
+	for _, exclusive := range []bool{false, true} {
+		for _, disable := range []bool{false, true} {
+			var slow int
+			s := &Sanitizer{
+				Rules:            makeRules(regexp.MustCompile(`s\w+t`), "<s>"),
+				Exclusive:        exclusive,
+				RuleTimeBudget:   time.Nanosecond,
+				DisableSlowRules: disable,
+				OnSlowRule: func(rule *Rule, took time.Duration) {
+					slow++
+				},
+			}
+
+			// a rule is only disabled once it went over its budget, the text it did so on is still sanitized
+			assert.Equal(t, "a <s>", s.Sanitize("a secret"))
+			if disable {
+				assert.Equal(t, "a secret", s.Sanitize("a secret"))
+				assert.Equal(t, 1, slow)
+			} else {
+				assert.Equal(t, "a <s>", s.Sanitize("a secret"))
+				assert.Equal(t, 2, slow)
+			}
+		}
+	}
+
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(`s\w+t`), "<s>"), RuleTimeBudget: time.Hour, DisableSlowRules: true}
+	assert.Equal(t, "a <s>", s.Sanitize("a secret"))
+	assert.Equal(t, "a <s>", s.Sanitize("a secret"))
+}
+
+func TestProgramSize(t *testing.T) {
+	assert.Less(t, ProgramSize(regexp.MustCompile(`abc`)), 10)
+	assert.Greater(t, ProgramSize(regexp.MustCompile(`a{1000}`)), 1000)
+}
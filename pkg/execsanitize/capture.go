@@ -0,0 +1,28 @@
+package execsanitize
+
+import "bytes"
+
+// Capture is a writer that keeps its sanitized output in memory, see CaptureWriter
+type Capture struct {
+	*SanitizerWriter
+	buf bytes.Buffer
+}
+
+// CaptureWriter returns a writer that sanitizes its input into memory, such as to check what a test would have
+// printed, or to post a command's output somewhere once it is done
+func (s *Sanitizer) CaptureWriter(opts ...WriterOption) *Capture {
+	c := &Capture{}
+	c.SanitizerWriter = s.Writer(&c.buf, opts...)
+
+	return c
+}
+
+// String flushes the writer and returns its sanitized output so far. as flushing sanitizes held back input on its
+// own, it should be called once all input has been written
+func (c *Capture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.flush()
+
+	return c.buf.String()
+}
@@ -0,0 +1,22 @@
+package execsanitize
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureWriter(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+
+	c := s.CaptureWriter(LineBuffered())
+	fmt.Fprint(c, "a hunt")
+	fmt.Fprint(c, "er2\nb hun")
+	fmt.Fprint(c, "ter2")
+	assert.Equal(t, "a ***\nb ***", c.String())
+	assert.Equal(t, "a ***\nb ***", c.String())
+
+	fmt.Fprint(c, "\nc hunter2\n")
+	assert.Equal(t, "a ***\nb ***\nc ***\n", c.String())
+}
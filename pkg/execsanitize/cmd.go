@@ -0,0 +1,119 @@
+package execsanitize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// WrapCmd sanitizes the output of a command that has yet to be started, wrapping its Stdout and Stderr with writers
+// of s named stdout and stderr and configured with opts. writers that are nil are left as is, so their output is
+// still discarded, and if Stdout and Stderr are the same writer they share a single sanitizing writer, keeping the
+// command's stdout and stderr in a single pipe. the returned flush must be called once the command's Wait returns,
+// to write out the input the writers held back
+func WrapCmd(cmd *exec.Cmd, s *Sanitizer, opts ...WriterOption) (flush func() error) {
+	var writers []*SanitizerWriter
+	wrap := func(w io.Writer, stream string) *SanitizerWriter {
+		sw := s.Writer(w, append([]WriterOption{WithStream(stream)}, opts...)...)
+		writers = append(writers, sw)
+		return sw
+	}
+
+	shared := cmd.Stdout != nil && cmd.Stdout == cmd.Stderr
+	if cmd.Stdout != nil {
+		cmd.Stdout = wrap(cmd.Stdout, "stdout")
+		if shared {
+			cmd.Stderr = cmd.Stdout
+		}
+	}
+	if cmd.Stderr != nil && !shared {
+		cmd.Stderr = wrap(cmd.Stderr, "stderr")
+	}
+
+	return func() error {
+		var errs []error
+		for _, sw := range writers {
+			errs = append(errs, sw.Flush())
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Result is the sanitized output of a command run with Run
+type Result struct {
+	Stdout, Stderr []byte
+	// ExitCode is the command's exit code, or -1 if it did not exit, such as if it could not be started or was
+	// killed by a signal
+	ExitCode int
+}
+
+// Run runs a command, capturing its sanitized output line by line. as with exec.Cmd's Run, the error is an
+// *exec.ExitError if the command ran but did not exit successfully, and the command is killed if ctx is done
+// before it exits. if a rule with ActionTerminate matches, the output stops there, the command's later writes
+// fail with a broken pipe, and the error is ErrTerminated however the command exits. as s then stays terminated,
+// later runs with it return ErrTerminated without starting their command, so commands that may be terminated
+// should each be run with a sanitizer of their own
+func Run(ctx context.Context, s *Sanitizer, name string, args ...string) (Result, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := run(ctx, s, name, args, &stdout, &stderr)
+
+	return Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode}, err
+}
+
+// Output runs a command like Run, returning its sanitized stdout and stderr and its exit code. unlike Run, a
+// command that exits unsuccessfully is not an error, so err is only set if the command could not run or its output
+// was terminated
+func Output(ctx context.Context, s *Sanitizer, name string, args ...string) (stdout, stderr string, exitCode int, err error) {
+	res, err := Run(ctx, s, name, args...)
+
+	return string(res.Stdout), string(res.Stderr), res.ExitCode, exitOK(err)
+}
+
+// CombinedOutput runs a command like Output, returning its sanitized stdout and stderr interleaved as they were
+// written, as with exec.Cmd's CombinedOutput
+func CombinedOutput(ctx context.Context, s *Sanitizer, name string, args ...string) (output string, exitCode int, err error) {
+	var combined bytes.Buffer
+	exitCode, err = run(ctx, s, name, args, &combined, &combined)
+
+	return combined.String(), exitCode, exitOK(err)
+}
+
+// run runs a command with its output sanitized into stdout and stderr, returning its exit code
+func run(ctx context.Context, s *Sanitizer, name string, args []string, stdout, stderr io.Writer) (int, error) {
+	if s.Terminated() {
+		// the command's output could not be written
+		return -1, ErrTerminated
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	flush := WrapCmd(cmd, s, LineBuffered())
+
+	err := cmd.Run()
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	if s.Terminated() {
+		// the command most likely failed on its output being cut off
+		err = ErrTerminated
+	}
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	return exitCode, err
+}
+
+// exitOK drops the error of a command that ran but exited unsuccessfully
+func exitOK(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,87 @@
+package execsanitize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapCmd(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", "printf 'a hunt'; printf 'er2\\n'; printf 'b hunter2' >&2")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	flush := WrapCmd(cmd, s, LineBuffered())
+	require.NoError(t, cmd.Run())
+	require.NoError(t, flush())
+	assert.Equal(t, "a ***\n", stdout.String())
+	assert.Equal(t, "b ***", stderr.String())
+
+	// a single writer for both streams is kept as a single pipe, and nil writers are left discarding output
+	var combined bytes.Buffer
+	cmd = exec.Command("sh", "-c", "echo hunter2; echo hunter2 >&2")
+	cmd.Stdout, cmd.Stderr = &combined, &combined
+	flush = WrapCmd(cmd, s, LineBuffered())
+	assert.Same(t, cmd.Stdout, cmd.Stderr)
+	require.NoError(t, cmd.Run())
+	require.NoError(t, flush())
+	assert.Equal(t, "***\n***\n", combined.String())
+
+	cmd = exec.Command("true")
+	flush = WrapCmd(cmd, s)
+	assert.Nil(t, cmd.Stdout)
+	assert.Nil(t, cmd.Stderr)
+	assert.NoError(t, flush())
+}
+
+func TestRun(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+
+	res, err := Run(context.Background(), s, "sh", "-c", "echo hunter2; echo err hunter2 >&2; exit 3")
+	var exitErr *exec.ExitError
+	require.True(t, errors.As(err, &exitErr), "%v", err)
+	assert.Equal(t, Result{Stdout: []byte("***\n"), Stderr: []byte("err ***\n"), ExitCode: 3}, res)
+
+	res, err = Run(context.Background(), s, "echo", "ok")
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(res.Stdout))
+	assert.Zero(t, res.ExitCode)
+
+	_, err = Run(context.Background(), s, "/nonexistent/command")
+	assert.Error(t, err)
+
+	s.Rules[0].Action = ActionTerminate
+	res, err = Run(context.Background(), s, "sh", "-c", "echo before; echo hunter2; echo after")
+	assert.Equal(t, ErrTerminated, err)
+	assert.Equal(t, "before\n", string(res.Stdout))
+
+	// the sanitizer stays terminated, so later commands are not started
+	res, err = Run(context.Background(), s, "echo", "ok")
+	assert.Equal(t, ErrTerminated, err)
+	assert.Equal(t, Result{ExitCode: -1}, res)
+}
+
+func TestOutput(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+
+	stdout, stderr, exitCode, err := Output(context.Background(), s, "sh", "-c", "echo hunter2; echo err hunter2 >&2; exit 3")
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", stdout)
+	assert.Equal(t, "err ***\n", stderr)
+	assert.Equal(t, 3, exitCode)
+
+	output, exitCode, err := CombinedOutput(context.Background(), s, "sh", "-c", "echo a hunter2; echo b hunter2 >&2; echo c")
+	require.NoError(t, err)
+	assert.Equal(t, "a ***\nb ***\nc\n", output)
+	assert.Zero(t, exitCode)
+
+	_, exitCode, err = CombinedOutput(context.Background(), s, "/nonexistent/command")
+	assert.Error(t, err)
+	assert.Equal(t, -1, exitCode)
+}
@@ -0,0 +1,188 @@
+package execsanitize
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// plan is the order in which the rules run, with consecutive rules that can be matched in a single pass
+// combined into groups
+type plan struct {
+	rules []*Rule
+	steps []step
+}
+
+// step runs either a single rule or a group of rules
+type step struct {
+	rule  *Rule
+	group *constantGroup
+}
+
+// constantGroup is a run of rules with constant replacements and literal patterns, none of which can match
+// another's literal or text created by an earlier rule's replacement. matching all of them at once then finds
+// the same matches as running them one after the other
+type constantGroup struct {
+	rules []*Rule
+	re    *regexp.Regexp
+	// index maps each literal to its rule
+	index map[string]int
+}
+
+// plan returns the steps to run the rules in, rebuilding them if the rules have changed
+func (s *Sanitizer) plan() []step {
+	rules := s.rules()
+	pl := s.steps.Load()
+	if pl == nil || !sameRules(pl.rules, rules) {
+		pl = newPlan(rules)
+		s.steps.Store(pl)
+	}
+
+	return pl.steps
+}
+
+func newPlan(rules []*Rule) *plan {
+	var (
+		steps    []step
+		literals []string
+		group    []*Rule
+	)
+	flush := func() {
+		switch len(group) {
+		case 0:
+		case 1:
+			steps = append(steps, step{rule: group[0]})
+		default:
+			steps = append(steps, step{group: newConstantGroup(group, literals)})
+		}
+		group, literals = nil, nil
+	}
+
+	for _, rule := range prioritize(rules) {
+		literal, ok := constantLiteral(rule)
+		if !ok {
+			flush()
+			steps = append(steps, step{rule: rule})
+			continue
+		}
+
+		if !combinable(group, literals, rule, literal) {
+			flush()
+		}
+		group = append(group, rule)
+		literals = append(literals, literal)
+	}
+	flush()
+
+	return &plan{rules: rules, steps: steps}
+}
+
+// constantLiteral returns the literal a rule matches, if it is a plain replacement rule that matches a literal
+// string with a constant replacement
+func constantLiteral(rule *Rule) (string, bool) {
+	if rule.Pattern == nil || rule.Replacer != nil || rule.MatchReplacer != nil || rule.Action != ActionReplace ||
+		rule.Replacement == DiscardToken || rule.Region != nil || rule.MaxReplacements > 0 || rule.CollapseRuns ||
+		rule.FirstPerLine || rule.Validate != nil || rule.Verify != nil {
+		return "", false
+	}
+
+	literal, complete := rule.Pattern.LiteralPrefix()
+	if !complete || literal == "" {
+		return "", false
+	}
+
+	return literal, true
+}
+
+// combinable reports whether a rule can join a group without changing what any of the rules match
+func combinable(group []*Rule, literals []string, rule *Rule, literal string) bool {
+	for i, other := range literals {
+		// the literals must not overlap, or they would compete for the same text
+		if strings.Contains(other, literal) || strings.Contains(literal, other) || overlaps(other, literal) || overlaps(literal, other) {
+			return false
+		}
+		// the rule must not match text that an earlier rule's replacement creates
+		if touches(literal, group[i].Replacement) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// overlaps reports whether a proper suffix of a is a prefix of b
+func overlaps(a, b string) bool {
+	for k := 1; k < len(a); k++ {
+		if strings.HasPrefix(b, a[k:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// touches reports whether an occurrence of literal could include any part of repl once repl is written
+// into a text, or span the place it was written at
+func touches(literal, repl string) bool {
+	if repl == "" || strings.Contains(repl, literal) || strings.Contains(literal, repl) {
+		return true
+	}
+
+	return overlaps(literal, repl) || overlaps(repl, literal)
+}
+
+func newConstantGroup(rules []*Rule, literals []string) *constantGroup {
+	g := &constantGroup{rules: rules, index: make(map[string]int, len(rules))}
+	quoted := make([]string, len(literals))
+	for i, literal := range literals {
+		quoted[i] = regexp.QuoteMeta(literal)
+		g.index[literal] = i
+	}
+	g.re = regexp.MustCompile(strings.Join(quoted, "|"))
+
+	return g
+}
+
+// applyGroup replaces the matches of a group of rules in a single pass. matches are reported rule by rule,
+// with the offsets they would have had had the rules been applied one after the other
+func (s *Sanitizer) applyGroup(g *constantGroup, in string, p *pass) string {
+	all := g.re.FindAllStringIndex(in, -1)
+	if all == nil {
+		return in
+	}
+
+	locs := make([][][]int, len(g.rules))
+	for _, loc := range all {
+		i := g.index[in[loc[0]:loc[1]]]
+		locs[i] = append(locs[i], loc)
+	}
+
+	var edits []edit
+	for i, rule := range g.rules {
+		ruleLocs := s.exempt(rule, in, locs[i])
+		if ruleLocs == nil {
+			continue
+		}
+
+		if !s.DetectOnly {
+			p.shifts = edits
+		}
+		// the rules of groups replace their matches, as actions are not decided per match while rules are grouped
+		ruleEdits, _ := s.matchEdits(rule, in, ruleLocs, p)
+		edits = append(edits, ruleEdits...)
+	}
+	p.shifts = nil
+	if len(edits) == 0 {
+		return in
+	}
+
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	out := applyEdits(in, edits)
+	if !s.DetectOnly {
+		remapEscapes(p.escapes, edits)
+	}
+
+	return out
+}
@@ -0,0 +1,101 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// constantRules makes rules like makeRules, but with constant replacements
+func constantRules(args ...interface{}) []*Rule {
+	rules := makeRules(args...)
+	for _, rule := range rules {
+		rule.Replacement = rule.Replacer("")
+		rule.Replacer = nil
+	}
+
+	return rules
+}
+
+func TestConstantGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []interface{}
+		groups []int
+		inputs []string
+	}{
+		{
+			name:   "secrets",
+			args:   []interface{}{"hunter2", "<a>", "s3cre7", "<b>", "tok_123", "<c>"},
+			groups: []int{3},
+			inputs: []string{"", "hunter2 s3cre7 tok_123", "xhunter2s3cre7x hunter2", "nothing here"},
+		},
+		{
+			name:   "overlapping literals",
+			args:   []interface{}{"abc", "1", "cde", "2", "xyz", "3"},
+			groups: []int{1, 2},
+			inputs: []string{"abcde", "cdeabc xyz", "abcdexyz"},
+		},
+		{
+			name:   "replacement creates a later match",
+			args:   []interface{}{"foo", "bar", "bar", "baz", "qux", "<q>"},
+			groups: []int{1, 2},
+			inputs: []string{"foo bar", "foobar qux"},
+		},
+		{
+			name:   "replacement joins text",
+			args:   []interface{}{"-", "", "ab", "<ab>"},
+			groups: []int{1, 1},
+			inputs: []string{"a-b", "ab"},
+		},
+		{
+			name:   "regexp rules are not combined",
+			args:   []interface{}{"one", "1", regexp.MustCompile(`t\w+`), "<t>", "three", "3", "four", "4"},
+			groups: []int{1, 1, 2},
+			inputs: []string{"one two three four", "four three"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var groups []int
+			for _, step := range newPlan(constantRules(tt.args...)).steps {
+				if step.group != nil {
+					groups = append(groups, len(step.group.rules))
+				} else {
+					groups = append(groups, 1)
+				}
+			}
+			assert.Equal(t, tt.groups, groups)
+
+			for _, detectOnly := range []bool{false, true} {
+				for _, in := range tt.inputs {
+					var want, got []Match
+					sequential := &Sanitizer{Rules: makeRules(tt.args...), DetectOnly: detectOnly, OnMatch: func(m Match) {
+						m.Rule = nil
+						want = append(want, m)
+					}}
+					combined := &Sanitizer{Rules: constantRules(tt.args...), DetectOnly: detectOnly, OnMatch: func(m Match) {
+						m.Rule = nil
+						got = append(got, m)
+					}}
+
+					assert.Equal(t, sequential.Sanitize(in), combined.Sanitize(in), in)
+					assert.Equal(t, want, got, in)
+				}
+			}
+		})
+	}
+}
+
+func TestConstantGroupsAllow(t *testing.T) {
+	s := &Sanitizer{
+		Rules: constantRules("AKIAEXAMPLE", "<aws>", "hunter2", "<password>"),
+		Allow: []*regexp.Regexp{regexp.MustCompile(`EXAMPLE`)},
+	}
+	assert.Equal(t, "AKIAEXAMPLE <password>", s.Sanitize("AKIAEXAMPLE hunter2"))
+	stats := s.Stats()
+	assert.Equal(t, int64(0), stats.Rules[0].Matches)
+	assert.Equal(t, int64(1), stats.Rules[1].Matches)
+}
@@ -0,0 +1,338 @@
+package execsanitize
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the serializable form of a set of rules
+type Config struct {
+	Description string       `yaml:"description,omitempty"`
+	Rules       []RuleConfig `yaml:"rules"`
+	// Profiles are named sets of rules that are only used if selected, see Profile
+	Profiles map[string]ProfileConfig `yaml:"profiles,omitempty"`
+	// Include lists other config files, or globs of them, whose rules, profiles and tests come before the config's
+	// own. relative paths are relative to the including config. includes are resolved by LoadConfig
+	Include []string `yaml:"include,omitempty"`
+	// Tests are examples the rules are expected to handle, see RunTests
+	Tests []TestCase `yaml:"tests,omitempty"`
+}
+
+// ProfileConfig is a named set of rules in a config
+type ProfileConfig struct {
+	Description string `yaml:"description,omitempty"`
+	// Extends names the profiles whose rules come before the profile's own
+	Extends []string     `yaml:"extends,omitempty"`
+	Rules   []RuleConfig `yaml:"rules"`
+}
+
+// RuleConfig is the serializable form of a Rule. at most one of Regex, Plain, Glob and Word may be set,
+// see GlobPattern and WordPattern
+type RuleConfig struct {
+	Name  string `yaml:"name,omitempty"`
+	Regex string `yaml:"regex,omitempty"`
+	Plain string `yaml:"plain,omitempty"`
+	Glob  string `yaml:"glob,omitempty"`
+	Word  string `yaml:"word,omitempty"`
+	// Flags are inline regexp flags applied to the pattern, e.g. "i" for case-insensitive matching
+	Flags string `yaml:"flags,omitempty"`
+	// Replace is the replacement for each match
+	Replace string `yaml:"replace"`
+	// Expand allows Replace to reference capture groups of a regex using $1 syntax
+	Expand bool `yaml:"expand,omitempty"`
+	// Action is the name of the rule's action, see Action.String. defaults to replace
+	Action string `yaml:"action,omitempty"`
+	// Priority is the rule's priority, see Rule.Priority
+	Priority int `yaml:"priority,omitempty"`
+	// Region optionally limits the rule to the text between two markers
+	Region *RegionConfig `yaml:"region,omitempty"`
+	// Validate is the name of a validator that matches must pass, see ParseValidator
+	Validate string `yaml:"validate,omitempty"`
+	// MaxReplacements and CollapseRuns limit how matches are handled, see the Rule fields of the same name
+	MaxReplacements int  `yaml:"max_replacements,omitempty"`
+	CollapseRuns    bool `yaml:"collapse_runs,omitempty"`
+}
+
+// RegionConfig is the serializable form of a Region, its markers are regular expressions
+type RegionConfig struct {
+	Begin string `yaml:"begin"`
+	End   string `yaml:"end"`
+}
+
+// ParseConfig parses a YAML config, rejecting unknown fields
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, withKind(ErrInvalidConfig, fmt.Errorf("parsing config: %w", err))
+	}
+
+	return c, nil
+}
+
+// LoadConfig reads and parses a YAML config file along with the files it includes
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path, nil)
+}
+
+// loadConfig loads a config included by the configs in parents
+func loadConfig(path string, parents []string) (*Config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		if parent == abs {
+			return nil, withKind(ErrCycle, fmt.Errorf("%s includes itself", path))
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(data)
+	if err != nil || len(c.Include) == 0 {
+		return c, err
+	}
+
+	merged := &Config{Description: c.Description}
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, withKind(ErrInvalidPattern, fmt.Errorf("include %s: %w", pattern, err))
+		}
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			// a missing file is an error, a glob that matches nothing is not
+			matches = []string{pattern}
+		}
+
+		for _, match := range matches {
+			included, err := loadConfig(match, append(parents, abs))
+			if err != nil {
+				return nil, fmt.Errorf("including %s: %w", match, err)
+			}
+			if err := merged.merge(included); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := merged.merge(c); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// merge appends the rules, profiles and tests of another config
+func (c *Config) merge(other *Config) error {
+	c.Rules = append(c.Rules, other.Rules...)
+	c.Tests = append(c.Tests, other.Tests...)
+	for name, profile := range other.Profiles {
+		if _, ok := c.Profiles[name]; ok {
+			return withKind(ErrConflictingRules, fmt.Errorf("profile %s is defined more than once", name))
+		}
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]ProfileConfig)
+		}
+		c.Profiles[name] = profile
+	}
+
+	return nil
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Profile returns a config holding the config's top-level rules, which every profile shares, followed by the rules
+// of the named profiles. each profile's rules come after those of the profiles it extends, and the rules of a
+// profile extended more than once are only included once. without names, the config is returned as is
+func (c *Config) Profile(names ...string) (*Config, error) {
+	if len(names) == 0 {
+		return c, nil
+	}
+
+	p := &Config{Description: c.Description, Tests: c.Tests}
+	p.Rules = append(p.Rules, c.Rules...)
+	var (
+		added    = make(map[string]bool)
+		visiting = make(map[string]bool)
+		add      func(name string, path []string) error
+	)
+	add = func(name string, path []string) error {
+		if added[name] {
+			return nil
+		}
+		path = append(path, name)
+		if visiting[name] {
+			return withKind(ErrCycle, fmt.Errorf("profile %s extends itself: %s", name, strings.Join(path, " -> ")))
+		}
+		profile, ok := c.Profiles[name]
+		if !ok {
+			if len(path) > 1 {
+				return withKind(ErrUnknownProfile, fmt.Errorf("profile %s extends unknown profile %s", path[len(path)-2], name))
+			}
+			return withKind(ErrUnknownProfile, fmt.Errorf("unknown profile %s", name))
+		}
+
+		visiting[name] = true
+		for _, parent := range profile.Extends {
+			if err := add(parent, path); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+
+		added[name] = true
+		p.Rules = append(p.Rules, profile.Rules...)
+		return nil
+	}
+	for _, name := range names {
+		if err := add(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Compile turns the config into rules
+func (c *Config) Compile() ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(c.Rules))
+	for i, rc := range c.Rules {
+		rule, err := rc.Compile()
+		if err != nil {
+			return nil, &RuleError{Index: i, Name: rc.Name, Err: err}
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Compile turns the config into a rule
+func (rc RuleConfig) Compile() (*Rule, error) {
+	var (
+		pattern string
+		set     int
+	)
+	for _, p := range []string{rc.Regex, rc.Plain, rc.Glob, rc.Word} {
+		if p != "" {
+			set++
+		}
+	}
+	switch {
+	case set > 1:
+		return nil, withKind(ErrConflictingRules, fmt.Errorf("only one of regex, plain, glob and word may be set"))
+	case rc.Regex != "":
+		pattern = rc.Regex
+	case rc.Plain != "":
+		pattern = regexp.QuoteMeta(rc.Plain)
+	case rc.Glob != "":
+		var err error
+		if pattern, err = GlobPattern(rc.Glob); err != nil {
+			return nil, err
+		}
+	case rc.Word != "":
+		pattern = WordPattern(rc.Word)
+	case rc.Region == nil:
+		// a region without a pattern is matched as a whole
+		return nil, ErrMissingPattern
+	}
+
+	var (
+		rgxp *regexp.Regexp
+		err  error
+	)
+	if pattern != "" {
+		if rc.Flags != "" {
+			pattern = "(?" + rc.Flags + ")" + pattern
+		}
+
+		if rgxp, err = regexp.Compile(pattern); err != nil {
+			return nil, withKind(ErrInvalidPattern, fmt.Errorf("parsing pattern %s: %w", pattern, err))
+		}
+	}
+
+	action := ActionReplace
+	if rc.Action != "" {
+		if action, err = ParseAction(rc.Action); err != nil {
+			return nil, err
+		}
+	}
+
+	var validate func(string) bool
+	if rc.Validate != "" {
+		if validate, err = ParseValidator(rc.Validate); err != nil {
+			return nil, err
+		}
+	}
+
+	var region *Region
+	if rc.Region != nil {
+		if region, err = rc.Region.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	name := rc.Name
+	if name == "" {
+		name = pattern
+	}
+	if name == "" {
+		name = rc.Region.Begin
+	}
+
+	var replacer ReplacerFunc
+	if rc.Expand {
+		if rgxp == nil {
+			return nil, withKind(ErrMissingPattern, fmt.Errorf("expand needs a pattern"))
+		}
+		replace := rc.Replace
+		replacer = func(in string) string {
+			return rgxp.ReplaceAllString(in, replace)
+		}
+	}
+
+	return &Rule{
+		Name:        name,
+		Pattern:     rgxp,
+		Replacer:    replacer,
+		Replacement: rc.Replace,
+		Action:      action,
+		Priority:    rc.Priority,
+		Region:      region,
+		Validate:    validate,
+
+		MaxReplacements: rc.MaxReplacements,
+		CollapseRuns:    rc.CollapseRuns,
+	}, nil
+}
+
+func (rc *RegionConfig) compile() (*Region, error) {
+	if rc.Begin == "" || rc.End == "" {
+		return nil, withKind(ErrMissingPattern, fmt.Errorf("region needs both begin and end markers"))
+	}
+
+	begin, err := regexp.Compile(rc.Begin)
+	if err != nil {
+		return nil, withKind(ErrInvalidPattern, fmt.Errorf("parsing region begin %s: %w", rc.Begin, err))
+	}
+	end, err := regexp.Compile(rc.End)
+	if err != nil {
+		return nil, withKind(ErrInvalidPattern, fmt.Errorf("parsing region end %s: %w", rc.End, err))
+	}
+
+	return &Region{Begin: begin, End: end}, nil
+}
@@ -0,0 +1,177 @@
+package execsanitize
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+rules:
+  - name: greeting
+    regex: '(Hi|Bye)(!*)'
+    replace: '<$1>$2'
+    expand: true
+  - plain: '.*welcome'
+    flags: i
+    replace: 'hello'
+  - plain: 'secret'
+    action: discard-line
+    priority: 2
+    max_replacements: 5
+    collapse_runs: true
+  - glob: 'tok_*'
+    replace: '<token>'
+  - word: 'pw'
+    flags: i
+    replace: '<pw>'
+`))
+	require.NoError(t, err)
+
+	rules, err := c.Compile()
+	require.NoError(t, err)
+	require.Len(t, rules, 5)
+	assert.Equal(t, "greeting", rules[0].Name)
+	assert.Equal(t, `(?i)\.\*welcome`, rules[1].Name)
+
+	assert.Equal(t, ActionDiscardLine, rules[2].Action)
+	assert.Equal(t, 2, rules[2].Priority)
+	assert.Equal(t, 5, rules[2].MaxReplacements)
+	assert.True(t, rules[2].CollapseRuns)
+
+	s := &Sanitizer{Rules: rules}
+	assert.Equal(t, "<Hi>!! .*hello\n", s.Sanitize("Hi!! .*.*WELCOME\na secret\n"))
+	assert.Equal(t, "<token> <pw> pwd", s.Sanitize("tok_a1 PW pwd"))
+}
+
+func TestConfigErrors(t *testing.T) {
+	tcs := []struct {
+		config, wantErr string
+		kind            error
+	}{
+		{"rules: [{plain: a, regex: b}]", "rule 0: only one of regex, plain, glob and word may be set", ErrConflictingRules},
+		{"rules: [{glob: a, word: b}]", "rule 0: only one of regex, plain, glob and word may be set", ErrConflictingRules},
+		{"rules: [{glob: '[a'}]", "rule 0: unterminated [ in glob [a", ErrInvalidPattern},
+		{"rules: [{replace: a}]", "rule 0: missing pattern", ErrMissingPattern},
+		{"rules: [{regex: '('}]", "rule 0: parsing pattern (: error parsing regexp: missing closing ): `(`", ErrInvalidPattern},
+		{"rules: [{regex: a, action: explode}]", "rule 0: unknown action explode", ErrUnknownAction},
+		{"rules: [{regex: a, validate: crc}]", "rule 0: unknown validator crc", ErrUnknownValidator},
+		{"rules: [{regex: a, region: {begin: x}}]", "rule 0: region needs both begin and end markers", ErrMissingPattern},
+		{"rules: [{regex: a}, {name: broken, regex: a, region: {begin: x, end: '('}}]", "rule 1 (broken): parsing region end (: error parsing regexp: missing closing ): `(`", ErrInvalidPattern},
+		{"rules: [{regex: a, unknown: b}]", "parsing config: yaml: unmarshal errors:\n  line 1: field unknown not found in type execsanitize.RuleConfig", ErrInvalidConfig},
+	}
+
+	for _, tc := range tcs {
+		c, err := ParseConfig([]byte(tc.config))
+		if err == nil {
+			_, err = c.Compile()
+		}
+		assert.EqualError(t, err, tc.wantErr)
+		assert.True(t, errors.Is(err, tc.kind), "%s is not %v", err, tc.kind)
+		if tc.kind != ErrInvalidConfig {
+			var ruleErr *RuleError
+			require.True(t, errors.As(err, &ruleErr), err.Error())
+			assert.Equal(t, strings.Contains(tc.config, "broken"), ruleErr.Name == "broken")
+		}
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+rules:
+  - {name: shared, plain: shared, replace: x}
+profiles:
+  base:
+    rules:
+      - {name: base, plain: base, replace: x}
+  pii:
+    extends: [base]
+    rules:
+      - {name: pii, plain: pii, replace: x}
+  strict:
+    extends: [base, pii]
+    rules:
+      - {name: strict, plain: strict, replace: x}
+  loop:
+    extends: [cycle]
+  cycle:
+    extends: [loop]
+  broken:
+    extends: [missing]
+`))
+	require.NoError(t, err)
+
+	names := func(c *Config) []string {
+		var names []string
+		for _, rule := range c.Rules {
+			names = append(names, rule.Name)
+		}
+		return names
+	}
+
+	p, err := c.Profile()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared"}, names(p))
+
+	p, err = c.Profile("strict")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared", "base", "pii", "strict"}, names(p))
+
+	p, err = c.Profile("pii", "base")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shared", "base", "pii"}, names(p))
+
+	_, err = c.Profile("nope")
+	assert.EqualError(t, err, "unknown profile nope")
+	assert.True(t, errors.Is(err, ErrUnknownProfile))
+	_, err = c.Profile("loop")
+	assert.EqualError(t, err, "profile loop extends itself: loop -> cycle -> loop")
+	assert.True(t, errors.Is(err, ErrCycle))
+	_, err = c.Profile("broken")
+	assert.EqualError(t, err, "profile broken extends unknown profile missing")
+	assert.True(t, errors.Is(err, ErrUnknownProfile))
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	write("rules.d/a.yaml", "rules: [{name: a, plain: a, replace: x}]")
+	write("rules.d/b.yaml", "rules: [{name: b, plain: b, replace: x}]\nprofiles: {ci: {rules: [{name: ci, plain: ci, replace: x}]}}")
+	write("shared.yaml", "include: [rules.d/*.yaml]\nrules: [{name: shared, plain: shared, replace: x}]")
+	main := write("main.yaml", "include: [shared.yaml, 'none/*.yaml']\nrules: [{name: main, plain: main, replace: x}]")
+
+	c, err := LoadConfig(main)
+	require.NoError(t, err)
+	var names []string
+	for _, rule := range c.Rules {
+		names = append(names, rule.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "shared", "main"}, names)
+	assert.Contains(t, c.Profiles, "ci")
+
+	_, err = LoadConfig(write("missing.yaml", "include: [nope.yaml]"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "including "+filepath.Join(dir, "nope.yaml"))
+
+	loop := write("loop.yaml", "include: [loop2.yaml]")
+	write("loop2.yaml", "include: [loop.yaml]")
+	_, err = LoadConfig(loop)
+	assert.EqualError(t, err, "including "+filepath.Join(dir, "loop2.yaml")+": including "+loop+": "+loop+" includes itself")
+	assert.True(t, errors.Is(err, ErrCycle))
+
+	_, err = LoadConfig(write("twice.yaml", "include: [rules.d/b.yaml]\nprofiles: {ci: {}}"))
+	assert.EqualError(t, err, "profile ci is defined more than once")
+	assert.True(t, errors.Is(err, ErrConflictingRules))
+}
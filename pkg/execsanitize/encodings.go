@@ -0,0 +1,95 @@
+package execsanitize
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Encoding is a way a value may be encoded in output, such as base64 in an HTTP basic auth header
+type Encoding string
+
+const (
+	// EncodingBase64 matches the value in standard and URL-safe base64, including inside a larger encoded
+	// string such as base64("user:" + value)
+	EncodingBase64 Encoding = "base64"
+	// EncodingURL matches the value percent-encoded as in query strings and paths
+	EncodingURL Encoding = "url"
+	// EncodingHex matches the value in lowercase and uppercase hex
+	EncodingHex Encoding = "hex"
+)
+
+var encodings = []Encoding{EncodingBase64, EncodingURL, EncodingHex}
+
+// ParseEncoding parses the name of an encoding
+func ParseEncoding(s string) (Encoding, error) {
+	for _, e := range encodings {
+		if string(e) == s {
+			return e, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown encoding %s", s)
+}
+
+// EncodedVariants returns the forms of value in the given encodings that differ from value, longest first
+func EncodedVariants(value string, encs ...Encoding) []string {
+	var variants []string
+	for _, e := range encs {
+		switch e {
+		case EncodingBase64:
+			variants = append(variants, base64Variants(value)...)
+		case EncodingURL:
+			variants = append(variants, url.QueryEscape(value), url.PathEscape(value))
+		case EncodingHex:
+			variants = append(variants, hex.EncodeToString([]byte(value)), strings.ToUpper(hex.EncodeToString([]byte(value))))
+		}
+	}
+
+	seen := map[string]bool{value: true, "": true}
+	unique := variants[:0]
+	for _, v := range variants {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return len(unique[i]) > len(unique[j])
+	})
+
+	return unique
+}
+
+// base64Variants returns the characters of value's base64 encoding that do not depend on the bytes around it,
+// for each of the three alignments value may have in a larger encoded string
+func base64Variants(value string) []string {
+	var variants []string
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+		for offset := 0; offset < 3; offset++ {
+			encoded := enc.EncodeToString([]byte(strings.Repeat("\x00", offset) + value))
+			// each character holds 6 bits, skip those that hold bits of the padding before or after value
+			start, end := (8*offset+5)/6, 8*(offset+len(value))/6
+			if end-start < 2 {
+				continue
+			}
+			variants = append(variants, encoded[start:end])
+		}
+	}
+
+	return variants
+}
+
+// PlainPattern returns a regexp that matches value literally or in any of the given encodings
+func PlainPattern(value string, encs ...Encoding) string {
+	alternatives := []string{regexp.QuoteMeta(value)}
+	for _, v := range EncodedVariants(value, encs...) {
+		alternatives = append(alternatives, regexp.QuoteMeta(v))
+	}
+
+	return strings.Join(alternatives, "|")
+}
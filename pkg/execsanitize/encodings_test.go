@@ -0,0 +1,41 @@
+package execsanitize
+
+import (
+	"encoding/base64"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainPattern(t *testing.T) {
+	secret := "hunter2/x?"
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(PlainPattern(secret, EncodingBase64, EncodingURL, EncodingHex)), "<secret>")}
+
+	for _, prefix := range []string{"", "a", "ab", "abc"} {
+		encoded := base64.StdEncoding.EncodeToString([]byte(prefix + secret + "!"))
+		out := s.Sanitize("Authorization: Basic " + encoded)
+		assert.Contains(t, out, "<secret>", prefix)
+
+		encoded = base64.URLEncoding.EncodeToString([]byte(prefix + secret))
+		assert.Contains(t, s.Sanitize(encoded), "<secret>", prefix)
+	}
+
+	assert.Equal(t, "raw <secret> query <secret> path <secret> hex <secret> <secret>", s.Sanitize(
+		"raw hunter2/x? query hunter2%2Fx%3F path hunter2%2Fx%3F hex 68756e746572322f783f 68756E746572322F783F",
+	))
+	assert.Equal(t, "aHVudGVyMw==", s.Sanitize("aHVudGVyMw=="), "base64 of a different value")
+}
+
+func TestEncodedVariants(t *testing.T) {
+	assert.Empty(t, EncodedVariants("abc", EncodingURL))
+	assert.Equal(t, []string{"a%20b", "a+b"}, EncodedVariants("a b", EncodingURL))
+	assert.Equal(t, []string{"6162"}, EncodedVariants("ab", EncodingHex))
+
+	_, err := ParseEncoding("rot13")
+	assert.EqualError(t, err, "unknown encoding rot13")
+	e, err := ParseEncoding("hex")
+	require.NoError(t, err)
+	assert.Equal(t, EncodingHex, e)
+}
@@ -0,0 +1,66 @@
+package execsanitize
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors that the errors of compiling and loading configs wrap, to be checked for with errors.Is
+var (
+	// ErrInvalidConfig is wrapped by errors of configs that are not valid YAML or have unknown fields
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrInvalidPattern is wrapped by errors of patterns, globs and region markers that do not compile
+	ErrInvalidPattern = errors.New("invalid pattern")
+	// ErrMissingPattern is wrapped by errors of rules without a pattern, or regions without both markers
+	ErrMissingPattern = errors.New("missing pattern")
+	// ErrConflictingRules is wrapped by errors of rules that set options that cannot be combined, such as two kinds of
+	// pattern, and of profiles that are defined more than once
+	ErrConflictingRules = errors.New("conflicting rules")
+	// ErrUnknownAction is wrapped by errors of action names that ParseAction does not know
+	ErrUnknownAction = errors.New("unknown action")
+	// ErrUnknownValidator is wrapped by errors of validator names that ParseValidator does not know
+	ErrUnknownValidator = errors.New("unknown validator")
+	// ErrUnknownProfile is wrapped by errors of profiles that are selected or extended but not defined
+	ErrUnknownProfile = errors.New("unknown profile")
+	// ErrCycle is wrapped by errors of configs that include themselves and profiles that extend themselves
+	ErrCycle = errors.New("cycle")
+)
+
+// RuleError is an error of a rule of a config, with where the rule is among the config's rules
+type RuleError struct {
+	// Index is the position of the rule in Config.Rules
+	Index int
+	// Name is the name the rule was given, if any
+	Name string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("rule %d (%s): %v", e.Index, e.Name, e.Err)
+	}
+
+	return fmt.Sprintf("rule %d: %v", e.Index, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// kindError is an error that reads as err, and is also one of the sentinel errors above
+type kindError struct {
+	kind, err error
+}
+
+// withKind marks an error as being of the kind of a sentinel error
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
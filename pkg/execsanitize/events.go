@@ -0,0 +1,134 @@
+package execsanitize
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the kind of an Event
+type EventKind int
+
+const (
+	// EventMatch is sent for every match reported to OnMatch
+	EventMatch EventKind = iota
+	// EventDiscard is sent when a piece of text is dropped as a whole, by a rule such as one with ActionDiscardWrite or
+	// by a writer's LineFilter
+	EventDiscard
+	// EventFlush is sent when a writer is flushed, writing out the input it held back
+	EventFlush
+)
+
+var eventKindNames = []string{"match", "discard", "flush"}
+
+func (k EventKind) String() string {
+	if int(k) < len(eventKindNames) {
+		return eventKindNames[k]
+	}
+	return "unknown"
+}
+
+// Event is something that happened while sanitizing, see Subscribe
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Stream is the name of the stream the event happened on, if known
+	Stream string
+	// Match is the match of an EventMatch
+	Match *Match
+	// Bytes is the size of the text dropped by an EventDiscard, or of the held back input an EventFlush wrote out
+	Bytes int
+}
+
+// Subscription receives a sanitizer's events until it is closed
+type Subscription struct {
+	s *Sanitizer
+	c chan Event
+	// mu guards closing c against sending to it
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+}
+
+// Subscribe returns a subscription to the sanitizer's events, which buffers up to buffer of them. sanitizing never
+// waits for a subscriber: events that do not fit in the buffer are dropped and counted, see Dropped. the subscription
+// should be closed once its events are no longer read
+func (s *Sanitizer) Subscribe(buffer int) *Subscription {
+	sub := &Subscription{s: s, c: make(chan Event, buffer)}
+
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	subs := append([]*Subscription(nil), s.subscriptions()...)
+	subs = append(subs, sub)
+	s.subs.Store(&subs)
+
+	return sub
+}
+
+// C returns the channel events are sent to, which is closed when the subscription is
+func (sub *Subscription) C() <-chan Event {
+	return sub.c
+}
+
+// Dropped returns the number of events dropped as the subscription's buffer was full
+func (sub *Subscription) Dropped() int64 {
+	return sub.dropped.Load()
+}
+
+// Close stops the subscription and closes its channel
+func (sub *Subscription) Close() {
+	s := sub.s
+	s.subscriptionsMu.Lock()
+	var subs []*Subscription
+	for _, other := range s.subscriptions() {
+		if other != sub {
+			subs = append(subs, other)
+		}
+	}
+	s.subs.Store(&subs)
+	s.subscriptionsMu.Unlock()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.c)
+	}
+}
+
+func (sub *Subscription) send(e Event) {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+	if sub.closed {
+		return
+	}
+
+	select {
+	case sub.c <- e:
+	default:
+		sub.dropped.Add(1)
+	}
+}
+
+func (s *Sanitizer) subscriptions() []*Subscription {
+	if subs := s.subs.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// emit sends an event to the sanitizer's subscribers, if it has any
+func (s *Sanitizer) emit(kind EventKind, stream string, fill func(*Event)) {
+	subs := s.subscriptions()
+	if len(subs) == 0 {
+		return
+	}
+
+	e := Event{Kind: kind, Time: time.Now(), Stream: stream}
+	if fill != nil {
+		fill(&e)
+	}
+	for _, sub := range subs {
+		sub.send(e)
+	}
+}
@@ -0,0 +1,64 @@
+package execsanitize
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***", "drop me", "")}
+	s.Rules[1].Action = ActionDiscardWrite
+	sub := s.Subscribe(10)
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered(), WithStream("stdout"))
+	_, err := w.Write([]byte("password hunter2\ndrop me\nheld"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "password ***\nheld", buf.String())
+
+	var kinds []EventKind
+	for len(sub.C()) > 0 {
+		e := <-sub.C()
+		kinds = append(kinds, e.Kind)
+		assert.Equal(t, "stdout", e.Stream)
+		switch e.Kind {
+		case EventMatch:
+			require.NotNil(t, e.Match)
+			assert.Contains(t, []string{"hunter2", "drop me"}, e.Match.Text)
+		case EventDiscard:
+			assert.Equal(t, len("drop me"), e.Bytes)
+		case EventFlush:
+			assert.Equal(t, len("held"), e.Bytes)
+		}
+	}
+	// the discarded line is matched before it is dropped
+	assert.Equal(t, []EventKind{EventMatch, EventMatch, EventDiscard, EventFlush}, kinds)
+	assert.Zero(t, sub.Dropped())
+
+	sub.Close()
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+	sub.Close()
+	assert.Equal(t, "***", s.Sanitize("hunter2"))
+}
+
+func TestSubscribeDropped(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+	full, other := s.Subscribe(1), s.Subscribe(5)
+	defer other.Close()
+
+	// sanitizing does not wait for subscribers that fall behind
+	s.Sanitize("hunter2 hunter2 hunter2")
+	assert.Equal(t, int64(2), full.Dropped())
+	assert.Len(t, full.C(), 1)
+	assert.Len(t, other.C(), 3)
+	assert.Zero(t, other.Dropped())
+
+	full.Close()
+	s.Sanitize("hunter2")
+	assert.Len(t, other.C(), 4)
+}
@@ -0,0 +1,148 @@
+package execsanitize
+
+import (
+	"sort"
+	"time"
+)
+
+// orderedRules returns the rules sorted by descending priority
+func (s *Sanitizer) orderedRules() []*Rule {
+	return prioritize(s.rules())
+}
+
+// prioritize sorts rules by descending priority, returning them as is if none has a priority
+func prioritize(all []*Rule) []*Rule {
+	prioritized := false
+	for _, rule := range all {
+		if rule.Priority != 0 {
+			prioritized = true
+			break
+		}
+	}
+	if !prioritized {
+		return all
+	}
+
+	rules := make([]*Rule, len(all))
+	copy(rules, all)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+
+	return rules
+}
+
+// applyExclusive matches all rules against the same text, skipping matches that overlap a region already
+// claimed by an earlier rule
+func (s *Sanitizer) applyExclusive(rules []*Rule, in string, p *pass) string {
+	var claimed, replaced, dropped []edit
+	for _, rule := range rules {
+		if s.disabled(rule) {
+			continue
+		}
+
+		start, from := time.Now(), len(p.matches)
+		locs, cont := s.find(rule, in, p)
+		if cont != nil && rule.Action == ActionReplace && !overlapsAny(claimed, cont[0], cont[1]) {
+			// drop the rest of a block whose replacement was already written
+			if cont[1]-cont[0] == len(in) {
+				p.discard = true
+			}
+			claimed = append(claimed, edit{start: cont[0], end: cont[1]})
+			replaced = append(replaced, edit{start: cont[0], end: cont[1]})
+		}
+
+		var free [][]int
+		for _, loc := range s.exempt(rule, in, locs) {
+			if !overlapsAny(claimed, loc[0], loc[1]) {
+				free = append(free, loc)
+			}
+		}
+		if free == nil {
+			p.traceRule(rule, start, from)
+			continue
+		}
+
+		for _, loc := range free {
+			claimed = append(claimed, edit{start: loc[0], end: loc[1]})
+		}
+
+		ruleEdits, ruleDropped := s.matchEdits(rule, in, free, p)
+		replaced, dropped = append(replaced, ruleEdits...), append(dropped, ruleDropped...)
+		p.traceRule(rule, start, from)
+	}
+
+	edits := withDropped(replaced, dropped)
+	if len(edits) == 0 {
+		return in
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	out := applyEdits(in, edits)
+	if out == "" && len(dropped) > 0 {
+		p.discard = true
+	}
+	if !s.DetectOnly {
+		remapEscapes(p.escapes, edits)
+	}
+
+	return out
+}
+
+// overlapsAny reports whether [start, end) overlaps any of the edits' ranges
+func overlapsAny(edits []edit, start, end int) bool {
+	for _, e := range edits {
+		if start < e.end && e.start < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withDropped merges the edits replacing matches with those dropping lines, which take precedence over the
+// replacements inside them
+func withDropped(edits, dropped []edit) []edit {
+	if len(dropped) == 0 {
+		return edits
+	}
+
+	merged := mergeEdits(dropped)
+	for _, e := range edits {
+		if !overlapsAny(merged, e.start, e.end) {
+			merged = append(merged, e)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].start < merged[j].start
+	})
+
+	return merged
+}
+
+// mergeEdits sorts removal edits and merges overlapping ones
+func mergeEdits(edits []edit) []edit {
+	if len(edits) == 0 {
+		return nil
+	}
+
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	merged := []edit{edits[0]}
+	for _, e := range edits[1:] {
+		last := &merged[len(merged)-1]
+		if e.start < last.end {
+			if e.end > last.end {
+				last.end = e.end
+			}
+			continue
+		}
+		merged = append(merged, e)
+	}
+
+	return merged
+}
@@ -0,0 +1,50 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExclusive(t *testing.T) {
+	rules := makeRules(
+		regexp.MustCompile(`token=\w+`), "token=<redacted>",
+		"redacted", "REMOVED",
+		regexp.MustCompile(`\w+@example\.com`), "<email>",
+		"drop", "",
+	)
+	rules[3].Action = ActionDiscardLine
+
+	chained := &Sanitizer{Rules: rules}
+	assert.Equal(t, "token=<REMOVED>", chained.Sanitize("token=abc"))
+
+	var matches []Match
+	exclusive := &Sanitizer{
+		Rules:     rules,
+		Exclusive: true,
+		OnMatch: func(m Match) {
+			matches = append(matches, m)
+		},
+	}
+	assert.Equal(t, "token=<redacted> REMOVED <email>\n", exclusive.Sanitize("token=abc redacted joe@example.com\ndrop token=x\n"))
+	assert.Len(t, matches, 5)
+	assert.Equal(t, "", exclusive.Sanitize("drop me"))
+}
+
+func TestPriority(t *testing.T) {
+	rules := makeRules(
+		regexp.MustCompile(`\d+`), "<number>",
+		regexp.MustCompile(`\d{4}-\d{4}`), "<card>",
+	)
+
+	s := &Sanitizer{Rules: rules, Exclusive: true}
+	assert.Equal(t, "<number>-<number>", s.Sanitize("1234-5678"))
+
+	rules[1].Priority = 1
+	assert.Equal(t, "<card> <number>", s.Sanitize("1234-5678 9"))
+	assert.Equal(t, rules, s.Rules, "ordering must not modify the rules")
+
+	s.Exclusive = false
+	assert.Equal(t, "<card> <number>", s.Sanitize("1234-5678 9"))
+}
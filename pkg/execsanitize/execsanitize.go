@@ -1,72 +1,583 @@
 package execsanitize
 
 import (
-	"io"
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	// DiscardToken is a special replacement string that discards the write operation completely on match
+	//
+	// Deprecated: a replacement that legitimately equals the token triggers a discard. use ActionDiscardWrite instead
 	DiscardToken = "@discard"
 )
 
 // ReplacerFunc is a function that accept a match and returns its replacement
 type ReplacerFunc func(string) string
 
-// Sanitizer sanitizes strings according to regex matching rules
+// MatchReplacerFunc computes the replacement of a match from the match and its metadata, such as its rule and
+// stream. the match's Replacement is not set yet
+type MatchReplacerFunc func(Match) string
+
+// Sanitizer sanitizes strings according to regex matching rules.
+//
+// a Sanitizer is safe for concurrent use, so that a single one can sanitize both stdout and stderr of a command,
+// as long as its ReplacerFuncs and hooks are. stateful ReplacerFuncs must synchronize their state themselves,
+// see replacers.Counter and replacers.Synchronized
 type Sanitizer struct {
-	Rules []*Rule
+	// Rules must not be modified while the sanitizer is in use, see SetRules
+	Rules   []*Rule
+	rulesMu sync.RWMutex
+
+	// OnMatch is an optional hook called for every match, after its replacement has been computed
+	OnMatch func(Match)
+
+	// ActionPolicy optionally decides what is done with each match, given the match with its rule's action as its
+	// Action, so that the action can depend on more than the rule, such as the stream. the match's Replacement is
+	// not set yet. while it is set, rules are run one by one
+	ActionPolicy func(Match) Action
+
+	// LineFilter is an optional final stage for line-buffered writers. it is called with each sanitized line,
+	// without its line ending, and the matches found in it. it returns the line to emit, or false to drop the line
+	LineFilter func(line string, matches []Match) (string, bool)
+
+	// DetectOnly reports matches without altering the sanitized text
+	DetectOnly bool
+
+	// Allow exempts matches whose text matches any of these patterns, such as documented example keys.
+	// exempt matches are left untouched and are not reported
+	Allow []*regexp.Regexp
+
+	// Exclusive makes every rule match the original text instead of the output of the rules before it.
+	// a region matched by one rule cannot be matched by another, so replacements are never rewritten.
+	// rules with a higher priority, or earlier rules with the same priority, win overlapping matches
+	Exclusive bool
+
+	// FoldUnicode makes rules match text as if look-alike characters were plain ASCII: fullwidth and mathematical
+	// forms of ASCII characters and unusual spaces are folded as by NFKC normalization, Cyrillic and Greek letters
+	// that look like Latin ones are folded to them, and zero-width characters and direction marks are ignored.
+	// the output keeps the original text apart from replaced matches, whose Text is the original text as well
+	FoldUnicode bool
+
+	// IgnoreANSI makes rules match the text as if ANSI escape sequences, such as colors, were not there.
+	// escape sequences are kept in the output, those inside a replaced match are moved after its replacement.
+	// match offsets are relative to the text without escape sequences
+	IgnoreANSI bool
+
+	// OnTrace is an optional hook called with a trace of sanitizing each piece of text, to debug rules that do not
+	// match as expected or profile slow ones. tracing runs every rule over every piece of text, skipping
+	// optimizations such as passing through text that no rule can match
+	OnTrace func(Trace)
+
+	// OnVerify receives the results of rules' Verify hooks, which run asynchronously
+	OnVerify func(Verification)
+	// VerifyTimeout bounds each verification, defaulting to DefaultVerifyTimeout
+	VerifyTimeout time.Duration
+	// VerifyConcurrency bounds the number of verifications that run at once, defaulting to
+	// DefaultVerifyConcurrency. the matches waiting for one are queued
+	VerifyConcurrency int
+
+	// Delimiter separates the records that line-buffered writers, ActionDiscardLine and FirstPerLine handle as lines,
+	// such as "\x00" for NUL-separated output. defaults to a newline
+	Delimiter string
+
+	// RuleTimeBudget is how long a rule may take to match a single piece of text. rules that take longer are reported
+	// to OnSlowRule, and disabled for the rest of the sanitizer's lifetime if DisableSlowRules is set, so that a
+	// pathological pattern cannot keep stalling the output. go regexps cannot be interrupted, so the piece of text
+	// a rule went over its budget on is still sanitized in full. the time replacers take is not counted
+	RuleTimeBudget   time.Duration
+	OnSlowRule       func(rule *Rule, took time.Duration)
+	DisableSlowRules bool
+
+	verifier   verifier
+	stats      stats
+	terminated int32
+	prefilter  atomic.Pointer[prefilter]
+	steps      atomic.Pointer[plan]
+	// disabledRules holds the rules disabled by DisableSlowRules
+	disabledRules sync.Map
+	// subs holds the subscriptions to the sanitizer's events, which are only replaced under subscriptionsMu
+	subs            atomic.Pointer[[]*Subscription]
+	subscriptionsMu sync.Mutex
 }
 
 type Rule struct {
-	Pattern  *regexp.Regexp
+	// Name optionally identifies the rule in matches and statistics
+	Name string
+	// Pattern matches the text to sanitize. it may only be nil for rules with a Region, see Region
+	Pattern *regexp.Regexp
+	// Replacer computes replacements. it is optional for actions other than ActionReplace,
+	// whose matches are left in place or dropped regardless of what it returns
 	Replacer ReplacerFunc
+	// MatchReplacer computes replacements with the match's metadata, see replacers.TemplateReplacer.
+	// it takes precedence over Replacer
+	MatchReplacer MatchReplacerFunc
+	// Replacement is a constant replacement, used if neither replacer is set. consecutive rules with constant
+	// replacements and literal patterns are matched in a single pass where that gives the same result
+	Replacement string
+	// Action is what the rule does with its matches, replacing them by default
+	Action Action
+	// Priority orders rules, which run from highest to lowest priority and in their original order otherwise
+	Priority int
+	// Region optionally limits the rule to the text between two markers
+	Region *Region
+	// MaxReplacements optionally limits how many matches the rule handles over the lifetime of the sanitizer,
+	// later matches are left untouched and not reported
+	MaxReplacements int
+	// CollapseRuns handles runs of identical matches on a line, separated by nothing but spaces and tabs, as a single
+	// match. the replacement is annotated with the number of matches, before a closing > if it has one, e.g.
+	// "<redacted x3>". while a rule collapses runs, writers sanitize their input line by line, as LineBuffered does
+	CollapseRuns bool
+	// Validate optionally checks matches, such as the checksum of a credit card number.
+	// matches it rejects are left untouched and are not reported
+	Validate func(match string) bool
+	// FirstPerLine only handles the first match on each line, like sed without the g flag. lines are only
+	// known in full if the text is sanitized line by line, such as by a line-buffered writer
+	FirstPerLine bool
+
+	// Verify optionally checks whether a match is a live secret, see Sanitizer.OnVerify
+	Verify VerifyFunc
+}
+
+// Match describes a single occurrence of a rule's pattern
+type Match struct {
+	Rule        *Rule
+	Text        string
+	Replacement string
+	// Start and End are byte offsets of the match in the text as seen by the rule,
+	// i.e. after all preceding rules have been applied, or in the original text if Sanitizer.Exclusive is set
+	Start, End int
+	// Stream is the name of the stream the match was found in, if known
+	Stream string
+	// Line is the line of the stream the match starts on, counting from 1, for matches found by a SanitizerWriter.
+	// lines end with the sanitizer's Delimiter. it is approximate if rules before the match's changed the number of
+	// lines in the text
+	Line int
+	// Action is what was done with the match: its rule's action, unless the sanitizer's ActionPolicy chose another
+	Action Action
 }
 
 // Sanitize sanitizes a string using the Sanitizers rules
 func (s *Sanitizer) Sanitize(in string) string {
-	var discard bool
-	wrapReplacer := func(r ReplacerFunc) ReplacerFunc {
-		return func(in string) string {
-			s := r(in)
-			if s == DiscardToken {
-				discard = true
-			}
+	return s.sanitize(in, &pass{})
+}
+
+// Report describes what sanitizing a piece of text did
+type Report struct {
+	// Matches lists every match in the order the rules handled them
+	Matches []Match
+	// Changed is set if the sanitized text differs from the original
+	Changed bool
+	// Discarded is set if the whole text was dropped, e.g. by ActionDiscardWrite
+	Discarded bool
+}
+
+// SanitizeWithReport sanitizes a string like Sanitize, also returning the matches found in it
+func (s *Sanitizer) SanitizeWithReport(in string) (string, Report) {
+	p := &pass{collect: true}
+	out := s.sanitize(in, p)
+
+	return out, Report{Matches: p.matches, Changed: out != in, Discarded: p.discard}
+}
+
+// SanitizeBytes sanitizes a byte slice, see Sanitize
+func (s *Sanitizer) SanitizeBytes(in []byte) []byte {
+	return s.AppendSanitized(nil, in)
+}
+
+// AppendSanitized appends the sanitized form of src to dst and returns the extended slice, so that callers
+// can reuse buffers across calls
+func (s *Sanitizer) AppendSanitized(dst, src []byte) []byte {
+	if s.bypass() {
+		s.stats.addBytes("", len(src))
+		return append(dst, src...)
+	}
+
+	return append(dst, s.sanitize(string(src), &pass{})...)
+}
+
+// pass holds the state of sanitizing a single piece of text
+type pass struct {
+	stream string
+	// discard is set if the text should be dropped entirely
+	discard bool
+	// terminate is set if a rule with ActionTerminate matched
+	terminate bool
+	// matches are only collected if collect is set
+	collect bool
+	matches []Match
+	// escapes are the ANSI escape sequences stripped from the text if IgnoreANSI is set
+	escapes []escape
+	// regions records which rules' regions are open, it is shared by all passes of a writer
+	regions map[*Rule]bool
+	// trace records the rules run over the text if the sanitizer has an OnTrace hook
+	trace *Trace
+	// shifts are the edits that rules before the current one would have made to the text when rules
+	// are matched in a single pass, see offset
+	shifts []edit
+	// fold is set if rules match the text with its Unicode folded, see Sanitizer.FoldUnicode
+	fold bool
+	// line is the line of its stream the text starts on, or 0 if it is not known
+	line int
+	// gates are the literals that text must contain for patterns to match it, see prefilter
+	gates map[*regexp.Regexp]string
+}
 
-			return s
+// offset translates an offset in the text to one in the text as seen by the current rule
+func (p *pass) offset(pos int) int {
+	shifted := pos
+	for _, e := range p.shifts {
+		if e.end <= pos {
+			shifted += len(e.repl) - (e.end - e.start)
 		}
 	}
 
-	for _, rule := range s.Rules {
-		if discard {
-			break
+	return shifted
+}
+
+func (s *Sanitizer) sanitize(in string, p *pass) string {
+	s.stats.addBytes(p.stream, len(in))
+	if s.bypass() {
+		return in
+	}
+	if pf := s.fastPath(p); pf != nil && !pf.matchString(in) {
+		return in
+	}
+	p.gates = s.currentPrefilter().gates
+	if s.OnTrace != nil {
+		p.trace = &Trace{Stream: p.stream, Input: in}
+		p.collect = true
+		start := time.Now()
+		defer func() {
+			p.trace.Duration = time.Since(start)
+			s.OnTrace(*p.trace)
+		}()
+	}
+
+	if s.IgnoreANSI {
+		in, p.escapes = stripANSI(in)
+	}
+	p.fold = s.FoldUnicode
+
+	if s.Exclusive {
+		out := s.applyExclusive(s.orderedRules(), in, p)
+		if !s.DetectOnly {
+			in = out
 		}
+	} else {
+		steps := s.plan()
+		if p.trace != nil || p.fold || s.ActionPolicy != nil {
+			// rules are run one by one when tracing, when folding, as constant groups match the raw text, and when
+			// the actions of matches are decided one by one
+			steps = steps[:0:0]
+			for _, rule := range prioritize(s.rules()) {
+				steps = append(steps, step{rule: rule})
+			}
+		}
+
+		for _, step := range steps {
+			rule := step.rule
+			if (p.discard || p.terminate) && !s.DetectOnly {
+				// keep track of regions opened or closed in text that is dropped
+				if rule != nil && rule.Region != nil {
+					p.regionSpans(rule, in)
+				}
+				continue
+			}
 
-		in = rule.Pattern.ReplaceAllStringFunc(in, wrapReplacer(rule.Replacer))
+			if rule != nil && s.disabled(rule) {
+				continue
+			}
+
+			var out string
+			if step.group != nil {
+				out = s.applyGroup(step.group, in, p)
+			} else {
+				start, from := time.Now(), len(p.matches)
+				out = s.apply(rule, in, p)
+				p.traceRule(rule, start, from)
+			}
+			if !s.DetectOnly {
+				in = out
+			}
+		}
 	}
 
-	if discard {
-		return ""
+	if s.DetectOnly {
+		p.discard, p.terminate = false, false
+	}
+	if p.terminate {
+		atomic.StoreInt32(&s.terminated, 1)
+		p.discard = true
+	}
+	if p.discard {
+		s.emit(EventDiscard, p.stream, func(e *Event) {
+			e.Bytes = len(in)
+		})
+		in = ""
+	} else if p.escapes != nil {
+		in = restoreANSI(in, p.escapes)
+	}
+	if p.trace != nil {
+		p.trace.Output = in
 	}
 
 	return in
 }
 
-// SanitizerWriter is a wrapping writer that sanitizes all input
-type SanitizerWriter struct {
-	s *Sanitizer
-	w io.Writer
+// SetRules replaces the sanitizer's rules. it is safe to call while the sanitizer is in use,
+// text that is being sanitized at the time is sanitized with the old rules
+func (s *Sanitizer) SetRules(rules []*Rule) {
+	s.rulesMu.Lock()
+	s.Rules = rules
+	s.rulesMu.Unlock()
 }
 
-// Writer wraps a writer with a sanitizer
-func (s *Sanitizer) Writer(w io.Writer) io.Writer {
-	return &SanitizerWriter{s: s, w: w}
+// CurrentRules returns the sanitizer's rules. unlike reading Rules, it is safe to call while SetRules may be called
+func (s *Sanitizer) CurrentRules() []*Rule {
+	return s.rules()
 }
 
-// Write sanitizes bytes and passes them through to the underlying writer
-func (sw *SanitizerWriter) Write(p []byte) (n int, err error) {
-	clean := sw.s.Sanitize(string(p))
-	n = len(p)
-	_, err = sw.w.Write([]byte(clean))
-	return
+// RuleID identifies a rule where its name could give away what it matches, such as in notifications and reports
+// sent elsewhere. it is the rule's Name, unless the rule has none or is named after its pattern, as LoadConfig names
+// rules that are not given a name, in which case it is rule-<n> for the nth of the sanitizer's rules
+func (s *Sanitizer) RuleID(r *Rule) string {
+	if !r.namedAfterPattern() {
+		return r.Name
+	}
+	for i, rule := range s.rules() {
+		if rule == r {
+			return fmt.Sprintf("rule-%d", i+1)
+		}
+	}
+
+	return "rule"
+}
+
+// namedAfterPattern reports whether the rule has no name of its own, in which case its name may be the very
+// secret it matches
+func (r *Rule) namedAfterPattern() bool {
+	switch {
+	case r.Name == "":
+		return true
+	case r.Pattern != nil:
+		return r.Name == r.Pattern.String()
+	case r.Region != nil:
+		return r.Name == r.Region.Begin.String()
+	}
+
+	return false
+}
+
+func (s *Sanitizer) rules() []*Rule {
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+
+	return s.Rules
+}
+
+// Terminated reports whether a rule with ActionTerminate has matched. it is never reset, so every writer of the
+// sanitizer fails from then on, including those of commands started with it later
+func (s *Sanitizer) Terminated() bool {
+	return atomic.LoadInt32(&s.terminated) == 1
+}
+
+// apply replaces all matches of a single rule, reporting each one to the OnMatch hook
+func (s *Sanitizer) apply(rule *Rule, in string, p *pass) string {
+	locs, cont := s.find(rule, in, p)
+	locs = s.exempt(rule, in, locs)
+	if locs == nil && cont == nil {
+		return in
+	}
+
+	edits, dropped := s.matchEdits(rule, in, locs, p)
+	edits = withDropped(edits, dropped)
+	if cont != nil && rule.Action == ActionReplace {
+		if cont[1]-cont[0] == len(in) {
+			// the text is entirely inside a block whose replacement was already written
+			p.discard = true
+			return ""
+		}
+		// drop the rest of a block whose replacement was already written
+		edits = append([]edit{{start: cont[0], end: cont[1]}}, edits...)
+	}
+	if len(edits) == 0 {
+		return in
+	}
+
+	out := applyEdits(in, edits)
+	if out == "" && len(dropped) > 0 {
+		p.discard = true
+	}
+	if !s.DetectOnly {
+		remapEscapes(p.escapes, edits)
+	}
+
+	return out
+}
+
+// exempt removes the locations whose text matches an allow pattern or fails the rule's validation
+func (s *Sanitizer) exempt(rule *Rule, in string, locs [][]int) [][]int {
+	if len(s.Allow) == 0 && rule.Validate == nil {
+		return locs
+	}
+
+	var kept [][]int
+	for _, loc := range locs {
+		text := in[loc[0]:loc[1]]
+		if !s.allowed(text) && (rule.Validate == nil || rule.Validate(text)) {
+			kept = append(kept, loc)
+		}
+	}
+
+	return kept
+}
+
+// allowed reports whether text matches any of the allow patterns
+func (s *Sanitizer) allowed(text string) bool {
+	for _, allow := range s.Allow {
+		if allow.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchEdits reports the matches of a rule and applies their actions, returning the edits replacing matches and
+// those dropping the lines of matches it makes to the text
+func (s *Sanitizer) matchEdits(rule *Rule, in string, locs [][]int, p *pass) (edits, dropped []edit) {
+	occs := s.occurrences(rule, in, locs)
+	if len(occs) == 0 {
+		return nil, nil
+	}
+
+	var (
+		// dropLocs are the matches whose lines are dropped
+		dropLocs [][]int
+		// line is the line of the text counted is on, the occurrences being sorted
+		line, counted = p.line, 0
+	)
+	for _, occ := range occs {
+		if p.line > 0 {
+			line += strings.Count(in[counted:occ.start], s.delimiter())
+			counted = occ.start
+		}
+		m := Match{
+			Rule:   rule,
+			Text:   occ.text,
+			Start:  p.offset(occ.start),
+			End:    p.offset(occ.end),
+			Stream: p.stream,
+			Line:   line,
+			Action: rule.Action,
+		}
+		if s.ActionPolicy != nil {
+			m.Action = s.ActionPolicy(m)
+		}
+		repl := rule.Replacement
+		switch {
+		case rule.MatchReplacer != nil:
+			repl = rule.MatchReplacer(m)
+		case rule.Replacer != nil:
+			repl = rule.Replacer(occ.text)
+		}
+
+		switch m.Action {
+		case ActionReplace:
+			if repl == DiscardToken {
+				p.discard = true
+			}
+			repl = annotate(repl, occ.n)
+			edits = append(edits, edit{start: occ.start, end: occ.end, repl: repl})
+		case ActionDiscardLine:
+			dropLocs = append(dropLocs, []int{occ.start, occ.end})
+		case ActionDiscardWrite:
+			p.discard = true
+		case ActionTerminate:
+			p.terminate = true
+		}
+
+		m.Replacement = repl
+		if p.collect {
+			p.matches = append(p.matches, m)
+		}
+		if s.OnMatch != nil {
+			s.OnMatch(m)
+		}
+		s.emit(EventMatch, m.Stream, func(e *Event) {
+			e.Match = &m
+		})
+		if rule.Verify != nil {
+			s.verify(m)
+		}
+	}
+
+	if len(dropLocs) > 0 {
+		dropped = lineEdits(in, dropLocs, s.delimiter())
+	}
+
+	return edits, dropped
+}
+
+// edit replaces the bytes in [start, end) with repl
+type edit struct {
+	start, end int
+	repl       string
+}
+
+// applyEdits applies sorted, non-overlapping edits
+func applyEdits(in string, edits []edit) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, e := range edits {
+		b.WriteString(in[last:e.start])
+		b.WriteString(e.repl)
+		last = e.end
+	}
+	b.WriteString(in[last:])
+
+	return b.String()
+}
+
+// delimiter returns the separator of records
+func (s *Sanitizer) delimiter() string {
+	if s.Delimiter == "" {
+		return "\n"
+	}
+	return s.Delimiter
+}
+
+// lineEdits returns edits that remove every line of in, separated by delim, that overlaps one of locs
+func lineEdits(in string, locs [][]int, delim string) []edit {
+	var (
+		edits []edit
+		last  int
+	)
+	for _, loc := range locs {
+		if loc[0] < last {
+			// the match is on a line that was already dropped
+			continue
+		}
+
+		var start int
+		if i := strings.LastIndex(in[:loc[0]], delim); i >= 0 {
+			start = i + len(delim)
+		}
+		end := len(in)
+		if i := strings.Index(in[loc[1]:], delim); i >= 0 {
+			end = loc[1] + i + len(delim)
+		}
+
+		edits = append(edits, edit{start: start, end: end})
+		last = end
+	}
+
+	return edits
 }
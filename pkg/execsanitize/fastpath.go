@@ -0,0 +1,341 @@
+package execsanitize
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// never matches no text at all
+const never = `[^\x00-\x{10FFFF}]`
+
+// maxPrefilterLiterals is the number of literals above which the prefilter only matches the combined pattern, as
+// looking for each of them in turn would be slower than matching it
+const maxPrefilterLiterals = 8
+
+// prefilter combines the patterns of a set of rules into a single regexp, which matches text if any of the rules'
+// patterns or region markers do. patterns are combined in multiline mode, so that anchors also match at the line
+// boundaries of line-buffered writers. text that it does not match can be passed through as is.
+// as matching a large alternation is slow, text is looked for the literals that every match of the patterns
+// contains instead if there are any, and text that contains one of them is sanitized without matching it
+type prefilter struct {
+	rules []*Rule
+	// re is nil if the rules cannot be combined
+	re *regexp.Regexp
+	// literals is nil if not every pattern has a literal, or there are too many of them
+	literals     []string
+	literalBytes [][]byte
+	// pairs is the set of the pairs of bytes the first three bytes of literals are made of, if there are several
+	// and none is shorter than that. text is then looked for them in a single pass instead of one for each of them,
+	// at every other byte, as one of the pairs of a literal starts at an even offset wherever it is
+	pairs *[1 << 16 / 64]uint64
+	// gates holds the literal that every match of a pattern contains, for patterns that have one but do not start
+	// with a literal, which regexp would look for itself. text without the literal is not matched against them
+	gates map[*regexp.Regexp]string
+}
+
+func newPrefilter(rules []*Rule, disabled func(*Rule) bool) *prefilter {
+	var (
+		alternatives []string
+		patterns     []string
+		gates        = make(map[*regexp.Regexp]string)
+	)
+	for _, rule := range rules {
+		if rule.Pattern != nil {
+			if literal := gateLiteral(rule.Pattern); literal != "" {
+				gates[rule.Pattern] = literal
+			}
+		}
+		if disabled(rule) {
+			continue
+		}
+		for _, pattern := range []*regexp.Regexp{rule.Pattern, rule.regionBegin(), rule.regionEnd()} {
+			if pattern != nil {
+				alternatives = append(alternatives, "(?m:"+pattern.String()+")")
+				patterns = append(patterns, pattern.String())
+			}
+		}
+	}
+	if len(alternatives) == 0 {
+		alternatives = []string{never}
+	}
+
+	// a combined pattern that is too large to compile only disables the fast path
+	re, _ := regexp.Compile(strings.Join(alternatives, "|"))
+	pf := &prefilter{rules: rules, re: re, literals: prefilterLiterals(patterns), gates: gates}
+	for _, literal := range pf.literals {
+		pf.literalBytes = append(pf.literalBytes, []byte(literal))
+	}
+	if len(pf.literals) > 1 {
+		pf.pairs = new([1 << 16 / 64]uint64)
+		for _, literal := range pf.literals {
+			if len(literal) < 3 {
+				pf.pairs = nil
+				break
+			}
+			for _, pair := range []uint16{uint16(literal[0])<<8 | uint16(literal[1]), uint16(literal[1])<<8 | uint16(literal[2])} {
+				pf.pairs[pair/64] |= 1 << (pair % 64)
+			}
+		}
+	}
+
+	return pf
+}
+
+// match reports whether text may be altered by the rules
+func (pf *prefilter) match(text []byte) bool {
+	if pf.pairs != nil {
+		return pf.containsPair(text)
+	}
+	if pf.literalBytes != nil {
+		for _, literal := range pf.literalBytes {
+			if bytes.Contains(text, literal) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return pf.re.Match(text)
+}
+
+// matchString is match for strings
+func (pf *prefilter) matchString(text string) bool {
+	if pf.pairs != nil {
+		return pf.containsPairString(text)
+	}
+	if pf.literals != nil {
+		for _, literal := range pf.literals {
+			if strings.Contains(text, literal) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return pf.re.MatchString(text)
+}
+
+// containsPair reports whether text contains one of the literals, looking for their first bytes in pairs
+func (pf *prefilter) containsPair(text []byte) bool {
+	if len(text) < 2 {
+		return false
+	}
+	pairs := pf.pairs
+	for i := 1; i < len(text); i += 2 {
+		pair := uint16(text[i-1])<<8 | uint16(text[i])
+		if pairs[pair>>6]&(1<<(pair&63)) == 0 {
+			continue
+		}
+		for _, literal := range pf.literalBytes {
+			if bytes.HasPrefix(text[i-1:], literal) || i >= 2 && bytes.HasPrefix(text[i-2:], literal) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containsPairString is containsPair for strings
+func (pf *prefilter) containsPairString(text string) bool {
+	if len(text) < 2 {
+		return false
+	}
+	pairs := pf.pairs
+	for i := 1; i < len(text); i += 2 {
+		pair := uint16(text[i-1])<<8 | uint16(text[i])
+		if pairs[pair>>6]&(1<<(pair&63)) == 0 {
+			continue
+		}
+		for _, literal := range pf.literals {
+			if strings.HasPrefix(text[i-1:], literal) || i >= 2 && strings.HasPrefix(text[i-2:], literal) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// prefilterLiterals returns a set of literals, one of which every match of the patterns contains. literals are
+// shortened to their first few bytes until there are few enough of them, so that the literals of rules such as
+// secret-1 and secret-2 are looked for as one. nil is returned if a pattern has no literal or there are too many
+func prefilterLiterals(patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	literals := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return nil
+		}
+		literal := requiredLiteral(re)
+		if literal == "" {
+			return nil
+		}
+		literals = append(literals, literal)
+	}
+
+	for _, n := range []int{0, 8, 4, 3} {
+		set := minimalLiterals(literals, n)
+		if len(set) <= maxPrefilterLiterals {
+			return set
+		}
+	}
+
+	return nil
+}
+
+// gateLiteral returns the literal that every match of a pattern contains, if the pattern has one and does not
+// start with a literal
+func gateLiteral(re *regexp.Regexp) string {
+	if prefix, _ := re.LiteralPrefix(); prefix != "" {
+		return ""
+	}
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	return requiredLiteral(parsed)
+}
+
+// requiredLiteral returns the longest literal that every match of a pattern contains, if it has one.
+// case-insensitive literals are not looked at
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return ""
+		}
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var longest string
+		for _, sub := range re.Sub {
+			if literal := requiredLiteral(sub); len(literal) > len(longest) {
+				longest = literal
+			}
+		}
+		return longest
+	}
+
+	return ""
+}
+
+// minimalLiterals shortens literals to their first n bytes, if n is set, and drops those that contain another,
+// as text that contains them also contains the other
+func minimalLiterals(literals []string, n int) []string {
+	shortened := make([]string, len(literals))
+	for i, literal := range literals {
+		if n > 0 && len(literal) > n {
+			literal = literal[:n]
+		}
+		shortened[i] = literal
+	}
+	sort.SliceStable(shortened, func(i, j int) bool {
+		return len(shortened[i]) < len(shortened[j])
+	})
+
+	var set []string
+next:
+	for _, literal := range shortened {
+		for _, kept := range set {
+			if strings.Contains(literal, kept) {
+				continue next
+			}
+		}
+		set = append(set, literal)
+	}
+
+	return set
+}
+
+func (r *Rule) regionBegin() *regexp.Regexp {
+	if r.Region == nil {
+		return nil
+	}
+	return r.Region.Begin
+}
+
+func (r *Rule) regionEnd() *regexp.Regexp {
+	if r.Region == nil {
+		return nil
+	}
+	return r.Region.End
+}
+
+// fastPath returns the prefilter that text must not match to be passed through without sanitizing it,
+// or nil if the fast path cannot be taken
+func (s *Sanitizer) fastPath(p *pass) *prefilter {
+	if s.IgnoreANSI {
+		// rules match the text without escape sequences, which the raw text may not match
+		return nil
+	}
+	if s.OnTrace != nil {
+		return nil
+	}
+	if s.FoldUnicode {
+		// rules match the folded text, which the raw text may not match
+		return nil
+	}
+	for _, open := range p.regions {
+		if open {
+			return nil
+		}
+	}
+
+	pf := s.currentPrefilter()
+	if pf.re == nil {
+		return nil
+	}
+
+	return pf
+}
+
+// currentPrefilter returns the prefilter of the current rules, building it if they changed
+func (s *Sanitizer) currentPrefilter() *prefilter {
+	rules := s.rules()
+	pf := s.prefilter.Load()
+	if pf == nil || !sameRules(pf.rules, rules) {
+		pf = newPrefilter(rules, s.disabled)
+		s.prefilter.Store(pf)
+	}
+
+	return pf
+}
+
+// sameRules reports whether two slices of rules are the same slice
+func sameRules(a, b []*Rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
+
+// bypass reports whether the sanitizer has nothing to do at all, as it has no rules, so that text can be passed
+// through without being looked at
+func (s *Sanitizer) bypass() bool {
+	return s.OnTrace == nil && len(s.rules()) == 0
+}
+
+// passThrough reports whether text cannot be altered by sanitizing it, in which case only its size is recorded
+func (s *Sanitizer) passThrough(text []byte, p *pass) bool {
+	pf := s.fastPath(p)
+	if pf == nil || pf.match(text) {
+		return false
+	}
+
+	s.stats.addBytes(p.stream, len(text))
+	return true
+}
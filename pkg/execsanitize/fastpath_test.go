@@ -0,0 +1,110 @@
+package execsanitize
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastPath(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(`(?i)password=\S+`), "password=<redacted>", "hunter2", "***")}
+	clean := []byte("compiling module 1 of 300\nlinking\n")
+
+	for _, opts := range [][]WriterOption{nil, {LineBuffered()}} {
+		w := s.Writer(io.Discard, opts...)
+		allocs := testing.AllocsPerRun(100, func() {
+			_, err := w.Write(clean)
+			require.NoError(t, err)
+		})
+		if !raceEnabled {
+			assert.Zero(t, allocs)
+		}
+	}
+	assert.Equal(t, int64(202*len(clean)), s.Stats().BytesProcessed)
+
+	var out bytes.Buffer
+	w := s.Writer(&out, LineBuffered())
+	for _, write := range []string{"ok\nPASSWORD=x", "y\nok\n", "hunter", "2\n"} {
+		_, err := w.Write([]byte(write))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "ok\npassword=<redacted>\nok\n***\n", out.String())
+
+	// the prefilter is rebuilt when the rules change
+	s.SetRules(makeRules("linking", "<linking>"))
+	assert.Equal(t, "compiling module 1 of 300\n<linking>\n", s.Sanitize(string(clean)))
+}
+
+func TestPrefilterLiterals(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		expect   []string
+	}{
+		{[]string{`hunter2`, `password=\S+`}, []string{"hunter2", "password="}},
+		{[]string{`\b[\w.]+@[\w.]+\.com\b`, `(tok_)+\w{4}`}, []string{".com", "tok_"}},
+		// literals that contain another are dropped
+		{[]string{`secret`, `my-secret`, `secrets?`}, []string{"secret"}},
+		// literals are shortened until there are few enough of them
+		{[]string{`key-00`, `key-01`, `key-02`, `key-03`, `key-04`, `key-05`, `key-06`, `key-07`, `key-08`, `key-09`}, []string{"key-"}},
+		// patterns without literals disable the literals
+		{[]string{`hunter2`, `\d{16}`}, nil},
+		{[]string{`(?i)password=\S+`}, nil},
+		{[]string{`foo|bar`}, nil},
+		{[]string{`(foo)?bar*`}, []string{"ba"}},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, prefilterLiterals(test.patterns), "%q", test.patterns)
+	}
+}
+
+func TestPrefilterMatch(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***", regexp.MustCompile(`tok_\w+`), "<token>", regexp.MustCompile(`[\w.]+@example\.com`), "<email>")}
+	pf := s.currentPrefilter()
+	require.NotNil(t, pf.pairs)
+
+	for text, expect := range map[string]bool{
+		"":                     false,
+		"h":                    false,
+		"hunter":               false,
+		"hunter2":              true,
+		"xxhunter2":            true,
+		"a tok_":               true,
+		"tok":                  false,
+		"mail me@example.com":  true,
+		"mail me at example":   false,
+		"hunter3 tok- example": false,
+	} {
+		assert.Equal(t, expect, pf.matchString(text), "%q", text)
+		assert.Equal(t, expect, pf.match([]byte(text)), "%q", text)
+	}
+
+	// patterns without a literal prefix are only matched against text containing their literal
+	assert.Equal(t, map[*regexp.Regexp]string{s.Rules[2].Pattern: "@example.com"}, pf.gates)
+	assert.Equal(t, "<email> hunter3 <token>", s.Sanitize("me@example.com hunter3 tok_x"))
+	assert.Equal(t, "me@example.org ***", s.Sanitize("me@example.org hunter2"))
+}
+
+func TestWriterLongLine(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+	line := bytes.Repeat([]byte("abcdefghij"), 10000)
+	copy(line[50000:], "hunter2")
+
+	var out bytes.Buffer
+	w := s.Writer(&out, LineBuffered())
+	for off := 0; off < len(line); off += 3 {
+		_, err := w.Write(line[off:min(off+3, len(line))])
+		require.NoError(t, err)
+	}
+	assert.Zero(t, out.Len())
+	_, err := w.Write([]byte("\nok\n"))
+	require.NoError(t, err)
+
+	expect := bytes.Replace(line, []byte("hunter2"), []byte("***"), 1)
+	assert.Equal(t, string(expect)+"\nok\n", out.String())
+}
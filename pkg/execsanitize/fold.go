@@ -0,0 +1,118 @@
+package execsanitize
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// homoglyphs maps Cyrillic and Greek letters to the Latin letters they are indistinguishable from
+var homoglyphs = map[rune]rune{
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P',
+	'Τ': 'T', 'Υ': 'Y', 'Χ': 'X', 'ο': 'o',
+}
+
+// foldRune returns the ASCII character a rune is folded to, or -1 if it is dropped. runes that are not folded
+// are returned as is
+func foldRune(r rune) rune {
+	switch {
+	case r >= 0xFF01 && r <= 0xFF5E:
+		// fullwidth forms of ASCII
+		return r - 0xFF01 + '!'
+	case r == 0x3000 || r == 0x00A0 || (r >= 0x2000 && r <= 0x200A) || r == 0x202F || r == 0x205F:
+		// ideographic, no-break and typographic spaces
+		return ' '
+	case (r >= 0x200B && r <= 0x200F) || (r >= 0x2060 && r <= 0x2064) || r == 0xFEFF || r == 0x00AD || r == 0x180E:
+		// zero-width characters, soft hyphens and direction marks
+		return -1
+	case r >= 0x1D400 && r <= 0x1D6A3:
+		// mathematical alphanumeric letters, in styles of 52 letters each
+		i := (r - 0x1D400) % 52
+		if i < 26 {
+			return 'A' + i
+		}
+		return 'a' + i - 26
+	case r >= 0x1D7CE && r <= 0x1D7FF:
+		// mathematical digits, in styles of 10 digits each
+		return '0' + (r-0x1D7CE)%10
+	}
+	if folded, ok := homoglyphs[r]; ok {
+		return folded
+	}
+
+	return r
+}
+
+// foldedText is a text with its look-alike characters folded, see Sanitizer.FoldUnicode
+type foldedText struct {
+	text string
+	// starts and ends map each byte of text to the start and end of the rune of the original text it came from.
+	// they are nil if folding left the text unchanged
+	starts, ends []int
+}
+
+func foldUnicode(in string) foldedText {
+	var i int
+	for i < len(in) && in[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(in) {
+		return foldedText{text: in}
+	}
+
+	var (
+		b            strings.Builder
+		starts, ends = make([]int, i, len(in)+1), make([]int, i, len(in))
+		changed      bool
+	)
+	b.WriteString(in[:i])
+	for j := 0; j < i; j++ {
+		starts[j], ends[j] = j, j+1
+	}
+	for i < len(in) {
+		r, size := utf8.DecodeRuneInString(in[i:])
+		folded := r
+		if r != utf8.RuneError {
+			folded = foldRune(r)
+		}
+
+		switch {
+		case folded < 0:
+			changed = true
+		case folded != r:
+			changed = true
+			b.WriteRune(folded)
+		default:
+			b.WriteString(in[i : i+size])
+		}
+		for len(starts) < b.Len() {
+			starts, ends = append(starts, i), append(ends, i+size)
+		}
+		i += size
+	}
+	if !changed {
+		return foldedText{text: in}
+	}
+
+	return foldedText{text: b.String(), starts: append(starts, len(in)), ends: ends}
+}
+
+// findAll returns the locations of a pattern's matches in the original text
+func (f foldedText) findAll(re *regexp.Regexp) [][]int {
+	locs := re.FindAllStringIndex(f.text, -1)
+	if f.starts == nil {
+		return locs
+	}
+
+	for _, loc := range locs {
+		if loc[0] == loc[1] {
+			loc[0] = f.starts[loc[0]]
+			loc[1] = loc[0]
+			continue
+		}
+		loc[0], loc[1] = f.starts[loc[0]], f.ends[loc[1]-1]
+	}
+	return locs
+}
@@ -0,0 +1,40 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldUnicode(t *testing.T) {
+	var matches []Match
+	s := &Sanitizer{
+		Rules:       constantRules("hunter2", "<password>", regexp.MustCompile(`AKIA[A-Z0-9]{4}`), "<aws>"),
+		FoldUnicode: true,
+		OnMatch: func(m Match) {
+			matches = append(matches, m)
+		},
+	}
+
+	tests := map[string]string{
+		"plain hunter2":                                             "plain <password>",
+		"fullwidth ｈｕｎｔｅｒ２ here":                                    "fullwidth <password> here",
+		"zero\u200bwidth hun\u200bter\u200d2.":                      "zero\u200bwidth <password>.",
+		"cyrillic hunt\u0435r2, greek \u0391\u039a\u0399\u03911234": "cyrillic <password>, greek <aws>",
+		"math 𝐡𝐮𝐧𝐭𝐞𝐫𝟐 and Русский текст":                            "math <password> and Русский текст",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, s.Sanitize(in), in)
+	}
+
+	matches = nil
+	s.Sanitize("x ｈｕｎｔｅｒ２")
+	require.Len(t, matches, 1)
+	assert.Equal(t, "ｈｕｎｔｅｒ２", matches[0].Text)
+	assert.Equal(t, 2, matches[0].Start)
+
+	s.FoldUnicode = false
+	assert.Equal(t, "ｈｕｎｔｅｒ２", s.Sanitize("ｈｕｎｔｅｒ２"))
+}
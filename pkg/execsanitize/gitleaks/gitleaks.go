@@ -0,0 +1,268 @@
+// Package gitleaks converts gitleaks rule sets into sanitization rules, so that rules maintained for scanning
+// repositories can redact the same secrets from command output
+package gitleaks
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Ruleset is a gitleaks config converted to sanitization rules
+type Ruleset struct {
+	Rules []*execsanitize.Rule
+	// Allow holds the patterns of the config's global allowlist, see execsanitize.Sanitizer.Allow
+	Allow []*regexp.Regexp
+}
+
+// Load reads and converts a gitleaks TOML config file
+func Load(path string) (*Ruleset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return rs, nil
+}
+
+// Parse converts a gitleaks TOML config. each rule with a regex becomes a rule named after its id that replaces
+// the secret with <id>, keeping the rest of the match. the secret is the rule's secretGroup, the first non-empty
+// capture group if it has none, or the whole match. rules that only match file paths are skipped.
+//
+// secrets that a rule's allowlists, stopwords or entropy threshold exempt are rejected by the rule's Validate, so
+// they are left untouched and not reported. the global allowlist's regexes and stopwords are converted to Allow
+// patterns, other allowlist conditions such as paths and commits do not apply to command output and are ignored
+func Parse(data []byte) (*Ruleset, error) {
+	var cfg configFile
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return nil, fmt.Errorf("parsing gitleaks config: %w", err)
+	}
+
+	rs := &Ruleset{}
+	if cfg.Allowlist != nil {
+		al, err := cfg.Allowlist.compile()
+		if err != nil {
+			return nil, fmt.Errorf("allowlist: %w", err)
+		}
+		rs.Allow = append(al.regexes, al.stopwordPatterns()...)
+	}
+
+	for i, rc := range cfg.Rules {
+		rule, err := rc.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rc.ID, err)
+		}
+		if rule != nil {
+			rs.Rules = append(rs.Rules, rule)
+		}
+	}
+
+	return rs, nil
+}
+
+// configFile is the part of a gitleaks config that applies to command output
+type configFile struct {
+	Allowlist *allowlistConfig `toml:"allowlist"`
+	Rules     []ruleConfig     `toml:"rules"`
+}
+
+type ruleConfig struct {
+	ID          string  `toml:"id"`
+	Regex       string  `toml:"regex"`
+	SecretGroup int     `toml:"secretGroup"`
+	Entropy     float64 `toml:"entropy"`
+	// Allowlist is the single allowlist of older configs, Allowlists those of newer ones
+	Allowlist  *allowlistConfig  `toml:"allowlist"`
+	Allowlists []allowlistConfig `toml:"allowlists"`
+}
+
+type allowlistConfig struct {
+	Regexes     []string `toml:"regexes"`
+	RegexTarget string   `toml:"regexTarget"`
+	Stopwords   []string `toml:"stopwords"`
+	Condition   string   `toml:"condition"`
+}
+
+// secretRule holds the parts of a gitleaks rule that decide what the secret is and whether it is exempt
+type secretRule struct {
+	pattern     *regexp.Regexp
+	secretGroup int
+	entropy     float64
+	allowlists  []*allowlist
+}
+
+func (rc *ruleConfig) compile() (*execsanitize.Rule, error) {
+	if rc.ID == "" {
+		return nil, fmt.Errorf("missing id")
+	}
+	if rc.Regex == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(rc.Regex)
+	if err != nil {
+		return nil, err
+	}
+	if rc.SecretGroup < 0 || rc.SecretGroup > pattern.NumSubexp() {
+		return nil, fmt.Errorf("secretGroup %d does not exist", rc.SecretGroup)
+	}
+
+	sr := &secretRule{pattern: pattern, secretGroup: rc.SecretGroup, entropy: rc.Entropy}
+	configs := rc.Allowlists
+	if rc.Allowlist != nil {
+		configs = append([]allowlistConfig{*rc.Allowlist}, configs...)
+	}
+	for _, ac := range configs {
+		al, err := ac.compile()
+		if err != nil {
+			return nil, fmt.Errorf("allowlist: %w", err)
+		}
+		sr.allowlists = append(sr.allowlists, al)
+	}
+
+	replacement := "<" + rc.ID + ">"
+	return &execsanitize.Rule{
+		Name:    rc.ID,
+		Pattern: pattern,
+		Replacer: func(match string) string {
+			start, end := sr.secret(match)
+			return match[:start] + replacement + match[end:]
+		},
+		Validate: func(match string) bool {
+			start, end := sr.secret(match)
+			return !sr.exempt(match, match[start:end])
+		},
+	}, nil
+}
+
+// secret returns the offsets of the secret in a match
+func (sr *secretRule) secret(match string) (int, int) {
+	loc := sr.pattern.FindStringSubmatchIndex(match)
+	if loc == nil {
+		// the pattern depends on the text around the match
+		return 0, len(match)
+	}
+
+	if sr.secretGroup > 0 {
+		if loc[2*sr.secretGroup] >= 0 {
+			return loc[2*sr.secretGroup], loc[2*sr.secretGroup+1]
+		}
+		return 0, len(match)
+	}
+	for i := 2; i < len(loc); i += 2 {
+		if loc[i] >= 0 && loc[i+1] > loc[i] {
+			return loc[i], loc[i+1]
+		}
+	}
+
+	return 0, len(match)
+}
+
+// exempt reports whether the rule's entropy threshold or allowlists exempt a secret
+func (sr *secretRule) exempt(match, secret string) bool {
+	if sr.entropy > 0 && shannonEntropy(secret) <= sr.entropy {
+		return true
+	}
+	for _, al := range sr.allowlists {
+		if al.allows(match, secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowlist is the part of a gitleaks allowlist that applies to command output
+type allowlist struct {
+	regexes []*regexp.Regexp
+	// matchTarget makes regexes apply to the whole match instead of the secret
+	matchTarget bool
+	stopwords   []string
+	// and requires both a regex and a stopword to apply
+	and bool
+}
+
+func (ac *allowlistConfig) compile() (*allowlist, error) {
+	al := &allowlist{stopwords: ac.Stopwords}
+	for _, expr := range ac.Regexes {
+		rgxp, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		al.regexes = append(al.regexes, rgxp)
+	}
+	al.matchTarget = ac.RegexTarget == "match" || ac.RegexTarget == "line"
+	al.and = strings.EqualFold(ac.Condition, "AND")
+
+	return al, nil
+}
+
+func (al *allowlist) allows(match, secret string) bool {
+	target := secret
+	if al.matchTarget {
+		target = match
+	}
+
+	var regexAllows bool
+	for _, rgxp := range al.regexes {
+		if rgxp.MatchString(target) {
+			regexAllows = true
+			break
+		}
+	}
+	var stopwordAllows bool
+	lower := strings.ToLower(secret)
+	for _, word := range al.stopwords {
+		if strings.Contains(lower, strings.ToLower(word)) {
+			stopwordAllows = true
+			break
+		}
+	}
+
+	if al.and && len(al.regexes) > 0 && len(al.stopwords) > 0 {
+		return regexAllows && stopwordAllows
+	}
+	return regexAllows || stopwordAllows
+}
+
+// stopwordPatterns returns patterns that match text containing any of the stopwords
+func (al *allowlist) stopwordPatterns() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(al.stopwords))
+	for _, word := range al.stopwords {
+		patterns = append(patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(word)))
+	}
+
+	return patterns
+}
+
+// shannonEntropy returns the entropy of s in bits per byte, as computed by gitleaks
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	var entropy float64
+	for _, n := range counts {
+		if n > 0 {
+			freq := float64(n) / float64(len(s))
+			entropy -= freq * math.Log2(freq)
+		}
+	}
+
+	return entropy
+}
@@ -0,0 +1,102 @@
+package execsanitize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// occurrence is a single match, or a run of identical matches if the rule collapses runs
+type occurrence struct {
+	// start and end span the whole run
+	start, end int
+	text       string
+	// n is the number of matches in the run
+	n int
+}
+
+// occurrences groups the rule's matches into runs if it collapses them and applies its limit,
+// recording the remaining matches in the sanitizer's statistics
+func (s *Sanitizer) occurrences(rule *Rule, in string, locs [][]int) []occurrence {
+	if rule.FirstPerLine {
+		locs = firstPerLine(in, locs, s.delimiter())
+	}
+
+	occs := make([]occurrence, 0, len(locs))
+	for _, loc := range locs {
+		text := in[loc[0]:loc[1]]
+		if rule.CollapseRuns && len(occs) > 0 {
+			last := &occs[len(occs)-1]
+			if last.text == text && strings.Trim(in[last.end:loc[0]], " \t") == "" {
+				last.end = loc[1]
+				last.n++
+				continue
+			}
+		}
+
+		occs = append(occs, occurrence{start: loc[0], end: loc[1], text: text, n: 1})
+	}
+
+	if rule.MaxReplacements > 0 {
+		occs = occs[:s.stats.reserve(rule, len(occs), rule.MaxReplacements)]
+	}
+
+	var n int
+	for _, occ := range occs {
+		n += occ.n
+	}
+	if n > 0 {
+		s.stats.addMatches(rule, n)
+	}
+
+	return occs
+}
+
+// collapsesRuns reports whether any of the sanitizer's rules collapses runs of matches
+func (s *Sanitizer) collapsesRuns() bool {
+	for _, rule := range s.rules() {
+		if rule.CollapseRuns {
+			return true
+		}
+	}
+
+	return false
+}
+
+// firstPerLine returns the first of the sorted locations on each line of in, separated by delim
+func firstPerLine(in string, locs [][]int, delim string) [][]int {
+	var (
+		kept [][]int
+		// end is the end of the line of the last kept location
+		end = -1
+	)
+	for _, loc := range locs {
+		if loc[0] < end {
+			continue
+		}
+
+		kept = append(kept, loc)
+		// the line ends after the match, or with it if the match ends with a delimiter
+		from := loc[1] - len(delim)
+		if from < loc[0] {
+			from = loc[0]
+		}
+		end = len(in)
+		if i := strings.Index(in[from:], delim); i >= 0 {
+			end = from + i + len(delim)
+		}
+	}
+
+	return kept
+}
+
+// annotate adds the number of matches in a run to its replacement
+func annotate(repl string, n int) string {
+	if n == 1 || repl == "" {
+		return repl
+	}
+
+	if strings.HasSuffix(repl, ">") {
+		return fmt.Sprintf("%s x%d>", repl[:len(repl)-1], n)
+	}
+	return fmt.Sprintf("%s x%d", repl, n)
+}
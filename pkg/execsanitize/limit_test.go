@@ -0,0 +1,76 @@
+package execsanitize
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxReplacements(t *testing.T) {
+	var matches int
+	rules := makeRules("token", "<redacted>")
+	rules[0].MaxReplacements = 3
+	s := &Sanitizer{
+		Rules: rules,
+		OnMatch: func(Match) {
+			matches++
+		},
+	}
+
+	assert.Equal(t, "<redacted> <redacted>", s.Sanitize("token token"))
+	assert.Equal(t, "<redacted> token", s.Sanitize("token token"))
+	assert.Equal(t, "token", s.Sanitize("token"))
+	assert.Equal(t, 3, matches)
+	assert.Equal(t, int64(3), s.Stats().Rules[0].Matches)
+}
+
+func TestCollapseRuns(t *testing.T) {
+	var matches []Match
+	rules := makeRules(regexp.MustCompile(`tok_\w+`), "<redacted>", "secret", "***")
+	rules[0].CollapseRuns = true
+	rules[1].CollapseRuns = true
+	s := &Sanitizer{
+		Rules: rules,
+		OnMatch: func(m Match) {
+			matches = append(matches, m)
+		},
+	}
+
+	// runs do not span lines
+	assert.Equal(t, "<redacted>\n<redacted x2>\t\n<redacted> *** x2, ***", s.Sanitize("tok_a\ntok_a \ttok_a\t\ntok_b secretsecret, secret"))
+	assert.Len(t, matches, 5)
+	assert.Equal(t, Match{Rule: rules[0], Text: "tok_a", Replacement: "<redacted x2>", Start: 6, End: 18}, matches[1])
+	assert.Equal(t, int64(4), s.Stats().Rules[0].Matches)
+
+	// writers sanitize whole lines while a rule collapses runs, so that runs do not depend on how the input is written
+	for _, size := range []int{1, 2, 3, 7} {
+		var out bytes.Buffer
+		w := s.Writer(&out)
+		in := "tok_a tok_a tok_a\ntok_a\ntok_a tok_a"
+		for i := 0; i < len(in); i += size {
+			_, err := io.WriteString(w, in[i:min(i+size, len(in))])
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Flush())
+		assert.Equal(t, "<redacted x3>\n<redacted>\n<redacted x2>", out.String(), size)
+	}
+}
+
+func TestFirstPerLine(t *testing.T) {
+	rules := makeRules(regexp.MustCompile(`a+`), "<a>")
+	rules[0].FirstPerLine = true
+	s := &Sanitizer{Rules: rules}
+
+	assert.Equal(t, "<a> b a\n<a>\nc <a> a", s.Sanitize("aa b a\naaa\nc a a"))
+	assert.Equal(t, int64(3), s.Stats().Rules[0].Matches)
+
+	// a match ending with a newline does not affect the next line
+	rules = makeRules(regexp.MustCompile(`b\n?`), "<b>")
+	rules[0].FirstPerLine = true
+	s = &Sanitizer{Rules: rules}
+	assert.Equal(t, "a<b><b> c b", s.Sanitize("ab\nb c b"))
+}
@@ -0,0 +1,259 @@
+package execsanitize
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+)
+
+// LintMaxProgramSize is the number of instructions above which Lint reports a pattern's compiled program as too large.
+// such patterns, typically with large counted repetitions, are slow to match and use a lot of memory
+const LintMaxProgramSize = 5000
+
+// lint severities
+const (
+	// LintError is the severity of problems that stop a config from loading
+	LintError = "error"
+	// LintWarning is the severity of rules that load but likely do not do what was intended
+	LintWarning = "warning"
+)
+
+// sentinelActions maps the replacement values the exec-sanitize CLI reads as actions to the action a config sets instead
+var sentinelActions = map[string]string{
+	"@discard":       ActionDiscardLine.String(),
+	"@discard-write": ActionDiscardWrite.String(),
+	"@alert":         ActionAlert.String(),
+}
+
+// LintIssue is a problem Lint found with a config
+type LintIssue struct {
+	// Rule is where the rule is in the config, such as rules[2] or profiles.ci.rules[0], and empty for problems
+	// with the config as a whole
+	Rule string `json:"rule,omitempty"`
+	// Name is the name the rule was given, if any
+	Name     string `json:"name,omitempty"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	where := i.Rule
+	if i.Name != "" {
+		where += " (" + i.Name + ")"
+	}
+	if where != "" {
+		where += ": "
+	}
+
+	return fmt.Sprintf("%s%s: %s: %s", where, i.Severity, i.Check, i.Message)
+}
+
+// lintRule is a rule along with where it is in the config
+type lintRule struct {
+	at     string
+	config RuleConfig
+	rule   *Rule
+}
+
+// Lint statically checks the config's rules for invalid patterns, patterns that match the empty string and
+// thus everywhere, patterns that compile to very large programs, replacements that look like CLI actions, and rules
+// that never match because an earlier rule always matches their text first. the rules of each profile are checked
+// along with those of the profiles it extends
+func (c *Config) Lint() []LintIssue {
+	var (
+		issues   []LintIssue
+		compiled = make(map[string]*lintRule)
+		reported = make(map[string]bool)
+	)
+	compile := func(at string, rc RuleConfig) *lintRule {
+		if r, ok := compiled[at]; ok {
+			return r
+		}
+		r := &lintRule{at: at, config: rc}
+		compiled[at] = r
+		rule, err := rc.Compile()
+		if err != nil {
+			issues = append(issues, LintIssue{Rule: at, Name: rc.Name, Check: "invalid", Severity: LintError, Message: err.Error()})
+			return r
+		}
+		r.rule = rule
+		issues = append(issues, lintRuleConfig(at, rc, rule)...)
+		return r
+	}
+
+	var top []*lintRule
+	for i, rc := range c.Rules {
+		top = append(top, compile(fmt.Sprintf("rules[%d]", i), rc))
+	}
+	issues = append(issues, lintShadowed(top, reported)...)
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := c.Profile(name); err != nil {
+			issues = append(issues, LintIssue{Check: "profile", Severity: LintError, Message: err.Error()})
+			continue
+		}
+
+		rules := append([]*lintRule(nil), top...)
+		for _, profile := range c.profileChain(name) {
+			for i, rc := range c.Profiles[profile].Rules {
+				rules = append(rules, compile(fmt.Sprintf("profiles.%s.rules[%d]", profile, i), rc))
+			}
+		}
+		issues = append(issues, lintShadowed(rules, reported)...)
+	}
+
+	return issues
+}
+
+// profileChain returns the names of the profiles whose rules make up a valid profile, in the order Profile adds them
+func (c *Config) profileChain(name string) []string {
+	var (
+		chain []string
+		seen  = make(map[string]bool)
+		add   func(name string)
+	)
+	add = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, parent := range c.Profiles[name].Extends {
+			add(parent)
+		}
+		chain = append(chain, name)
+	}
+	add(name)
+
+	return chain
+}
+
+// lintRuleConfig checks a single compiled rule
+func lintRuleConfig(at string, rc RuleConfig, rule *Rule) []LintIssue {
+	var issues []LintIssue
+	issue := func(check, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Rule: at, Name: rc.Name, Check: check, Severity: LintWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if action, ok := sentinelActions[rc.Replace]; ok && rc.Action == "" {
+		issue("sentinel", "replacement %s is written out as is in configs, set action: %s instead", rc.Replace, action)
+	}
+	if rule.Pattern == nil {
+		return issues
+	}
+	if rule.Pattern.MatchString("") {
+		issue("empty-match", "pattern %s matches the empty string, so it matches everywhere", rule.Pattern)
+	}
+	if size, err := programSize(rule.Pattern.String()); err == nil && size > LintMaxProgramSize {
+		issue("program-size", "pattern %s compiles to %d instructions, more than %d", rule.Pattern, size, LintMaxProgramSize)
+	}
+
+	return issues
+}
+
+// programSize returns the number of instructions of a pattern's compiled program
+func programSize(pattern string) (int, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(prog.Inst), nil
+}
+
+// lintShadowed reports rules that never match because an earlier rule, going by priority, matches the same
+// pattern or the whole of their literal text. rules already in reported are skipped, and those reported are added
+func lintShadowed(rules []*lintRule, reported map[string]bool) []LintIssue {
+	var (
+		issues []LintIssue
+		valid  []*Rule
+		byRule = make(map[*Rule]*lintRule)
+	)
+	for _, r := range rules {
+		if r.rule != nil {
+			valid = append(valid, r.rule)
+			byRule[r.rule] = r
+		}
+	}
+
+	ordered := prioritize(valid)
+	for j, later := range ordered {
+		lr := byRule[later]
+		if later.Pattern == nil || later.Region != nil || reported[lr.at] {
+			continue
+		}
+		literal, complete := later.Pattern.LiteralPrefix()
+		for _, earlier := range ordered[:j] {
+			if !shadows(earlier, later, literal, complete) {
+				continue
+			}
+
+			er := byRule[earlier]
+			issues = append(issues, LintIssue{
+				Rule:     lr.at,
+				Name:     lr.config.Name,
+				Check:    "shadowed",
+				Severity: LintWarning,
+				Message:  fmt.Sprintf("never matches, as %s matches all of its text first", er.at),
+			})
+			reported[lr.at] = true
+			break
+		}
+	}
+
+	return issues
+}
+
+// shadows reports whether an earlier rule always takes the text of a later one, given the later rule's literal
+// prefix and whether its pattern is only that literal
+func shadows(earlier, later *Rule, literal string, complete bool) bool {
+	// rules that may leave a match in place do not shadow anything
+	if earlier.Pattern == nil || earlier.Region != nil || earlier.Validate != nil || earlier.Verify != nil ||
+		earlier.MaxReplacements > 0 || earlier.Action == ActionAlert {
+		return false
+	}
+	if earlier.Pattern.String() == later.Pattern.String() {
+		return true
+	}
+	if !complete || literal == "" || hasAssertions(earlier.Pattern.String()) {
+		// anchors and word boundaries make whether the earlier rule matches depend on the text around the literal
+		return false
+	}
+
+	loc := earlier.Pattern.FindStringIndex(literal)
+	return loc != nil && loc[0] == 0 && loc[1] == len(literal)
+}
+
+// hasAssertions reports whether a pattern has anchors or word boundaries
+func hasAssertions(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return true
+	}
+
+	var walk func(re *syntax.Regexp) bool
+	walk = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+			syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+			return true
+		}
+		for _, sub := range re.Sub {
+			if walk(sub) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return walk(re)
+}
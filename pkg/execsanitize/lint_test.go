@@ -0,0 +1,57 @@
+package execsanitize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+rules:
+  - {name: token, regex: 'tok_\w+', replace: '<token>'}
+  - {name: literal, plain: 'tok_abc', replace: '<abc>'}
+  - {name: bounded, regex: '\bsecret\b', replace: x}
+  - {plain: 'secret', replace: y}
+  - {name: broken, regex: '(', replace: x}
+  - {name: everything, regex: 'a*', replace: x}
+  - {name: drop, plain: 'debug', replace: '@discard'}
+  - {name: huge, regex: 'x{1000}y{1000}z{1000}w{1000}v{1000}u{1000}', replace: x}
+  - {name: prioritized, plain: 'key', replace: x, priority: 1}
+  - {name: key, regex: 'k\w+', replace: x}
+profiles:
+  ci:
+    rules:
+      - {name: dupe, regex: 'tok_\w+', replace: x}
+  strict:
+    extends: [ci]
+  loop:
+    extends: [loop]
+`))
+	require.NoError(t, err)
+
+	var got []string
+	for _, issue := range c.Lint() {
+		got = append(got, issue.String())
+	}
+	assert.Equal(t, []string{
+		"rules[4] (broken): error: invalid: parsing pattern (: error parsing regexp: missing closing ): `(`",
+		"rules[5] (everything): warning: empty-match: pattern a* matches the empty string, so it matches everywhere",
+		"rules[6] (drop): warning: sentinel: replacement @discard is written out as is in configs, set action: discard-line instead",
+		"rules[7] (huge): warning: program-size: pattern x{1000}y{1000}z{1000}w{1000}v{1000}u{1000} compiles to 6002 instructions, more than 5000",
+		"rules[1] (literal): warning: shadowed: never matches, as rules[0] matches all of its text first",
+		"profiles.ci.rules[0] (dupe): warning: shadowed: never matches, as rules[0] matches all of its text first",
+		"error: profile: profile loop extends itself: loop -> loop",
+	}, got)
+}
+
+func TestLintClean(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+rules:
+  - {name: password, regex: 'password=\S+', replace: 'password=***'}
+  - {name: debug, plain: 'DEBUG', action: discard-line}
+`))
+	require.NoError(t, err)
+	assert.Empty(t, c.Lint())
+}
@@ -0,0 +1,121 @@
+// Package logging sanitizes the output of Go loggers, so that services can reuse their exec-sanitize rules
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Handler is a slog.Handler that sanitizes messages and attribute values before passing records on to another handler
+type Handler struct {
+	s    *execsanitize.Sanitizer
+	next slog.Handler
+}
+
+// NewHandler wraps a handler with a sanitizer
+func NewHandler(s *execsanitize.Sanitizer, next slog.Handler) *Handler {
+	return &Handler{s: s, next: next}
+}
+
+// Enabled reports whether the wrapped handler handles records at the given level
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle sanitizes a record and passes it on to the wrapped handler
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, h.s.Sanitize(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(h.attr(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, clean)
+}
+
+// WithAttrs returns a handler whose wrapped handler has the sanitized attributes
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		clean = append(clean, h.attr(a))
+	}
+
+	return &Handler{s: h.s, next: h.next.WithAttrs(clean)}
+}
+
+// WithGroup returns a handler whose wrapped handler starts a group
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{s: h.s, next: h.next.WithGroup(name)}
+}
+
+// attr sanitizes an attribute's value. values other than strings and groups are formatted as text
+// and replaced with the sanitized text, only if it differs
+func (h *Handler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(h.s.Sanitize(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		clean := make([]slog.Attr, 0, len(group))
+		for _, ga := range group {
+			clean = append(clean, h.attr(ga))
+		}
+		a.Value = slog.GroupValue(clean...)
+	case slog.KindAny:
+		text := fmt.Sprintf("%+v", v.Any())
+		if clean := h.s.Sanitize(text); clean != text {
+			a.Value = slog.StringValue(clean)
+		} else {
+			a.Value = v
+		}
+	default:
+		a.Value = v
+	}
+
+	return a
+}
+
+// Writer sanitizes each write as a whole, which suits loggers such as logrus and zap that write one entry per call.
+// it is safe for concurrent use and implements zapcore.WriteSyncer
+type Writer struct {
+	s  *execsanitize.Sanitizer
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter wraps a writer with a sanitizer
+func NewWriter(s *execsanitize.Sanitizer, w io.Writer) *Writer {
+	return &Writer{s: s, w: w}
+}
+
+// Write sanitizes an entry and writes it to the underlying writer
+func (w *Writer) Write(p []byte) (int, error) {
+	clean := w.s.Sanitize(string(p))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if clean != "" {
+		if _, err := io.WriteString(w.w, clean); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Sync syncs the underlying writer if it supports it
+func (w *Writer) Sync() error {
+	if syncer, ok := w.w.(interface{ Sync() error }); ok {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return syncer.Sync()
+	}
+
+	return nil
+}
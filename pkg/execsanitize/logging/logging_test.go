@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func sanitizer() *execsanitize.Sanitizer {
+	return &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{{
+		Pattern: regexp.MustCompile(`hunter2`),
+		Replacer: func(string) string {
+			return "***"
+		},
+	}}}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	next := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slog.New(NewHandler(sanitizer(), next)).With("password", "hunter2")
+
+	logger.WithGroup("req").Info("logging in with hunter2",
+		"user", "joe",
+		"attempt", 1,
+		"err", errors.New("wrong password hunter2"),
+		slog.Group("headers", "authorization", "Basic hunter2"),
+	)
+	assert.Equal(t, `level=INFO msg="logging in with ***" password=*** req.user=joe req.attempt=1 req.err="wrong password ***" req.headers.authorization="Basic ***"`+"\n", buf.String())
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(sanitizer(), &buf)
+
+	n, err := w.Write([]byte(`{"msg":"password is hunter2"}` + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 30, n)
+	assert.Equal(t, `{"msg":"password is ***"}`+"\n", buf.String())
+	assert.NoError(t, w.Sync())
+}
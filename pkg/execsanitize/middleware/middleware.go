@@ -0,0 +1,130 @@
+// Package middleware applies sanitizers to HTTP servers and clients
+package middleware
+
+import (
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Option configures a middleware
+type Option func(*config)
+
+type config struct {
+	log    func(dump string)
+	bodies bool
+}
+
+// LogTo makes a middleware pass sanitized dumps of requests, and of responses for transports, to fn
+func LogTo(fn func(dump string)) Option {
+	return func(c *config) {
+		c.log = fn
+	}
+}
+
+// DumpBodies includes bodies in dumps. bodies are read into memory to dump them
+func DumpBodies() Option {
+	return func(c *config) {
+		c.bodies = true
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Handler wraps a handler, sanitizing the bodies of its responses. since sanitizing can change the length
+// of a body, the Content-Length header is removed. a tripwire rule stops the sanitizer, truncating all later responses
+func Handler(s *execsanitize.Sanitizer, next http.Handler, opts ...Option) http.Handler {
+	c := newConfig(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.log != nil {
+			if dump, err := httputil.DumpRequest(r, c.bodies); err == nil {
+				c.log(s.Sanitize(string(dump)))
+			}
+		}
+
+		rw := &responseWriter{ResponseWriter: w}
+		rw.sw = s.Writer(w, execsanitize.WithStream("response"))
+		next.ServeHTTP(rw, r)
+		_ = rw.sw.Flush()
+	})
+}
+
+// responseWriter sanitizes everything written to a response
+type responseWriter struct {
+	http.ResponseWriter
+	sw          *execsanitize.SanitizerWriter
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.Header().Del("Content-Length")
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	return rw.sw.Write(p)
+}
+
+// Flush writes out buffered data, see http.Flusher
+func (rw *responseWriter) Flush() {
+	_ = rw.sw.Flush()
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Transport wraps a round tripper, passing sanitized dumps of requests and responses to the LogTo option.
+// requests and responses themselves are left untouched. next defaults to http.DefaultTransport
+func Transport(s *execsanitize.Sanitizer, next http.RoundTripper, opts ...Option) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &transport{s: s, next: next, config: newConfig(opts)}
+}
+
+type transport struct {
+	s    *execsanitize.Sanitizer
+	next http.RoundTripper
+	*config
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.log != nil {
+		if dump, err := httputil.DumpRequestOut(r, t.bodies); err == nil {
+			t.log(t.s.Sanitize(string(dump)))
+		}
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil || t.log == nil {
+		return resp, err
+	}
+
+	if dump, err := httputil.DumpResponse(resp, t.bodies); err == nil {
+		t.log(t.s.Sanitize(string(dump)))
+	}
+
+	return resp, nil
+}
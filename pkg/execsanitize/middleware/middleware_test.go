@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func sanitizer() *execsanitize.Sanitizer {
+	pattern := regexp.MustCompile(`(?i)(authorization: |token=)[^\r\n]+`)
+	return &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{{
+		Pattern: pattern,
+		Replacer: func(in string) string {
+			return pattern.ReplaceAllString(in, "${1}<redacted>")
+		},
+	}}}
+}
+
+func TestHandler(t *testing.T) {
+	var dumps []string
+	h := Handler(sanitizer(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "22")
+		_, _ = io.WriteString(w, "Authorization: abc123\n")
+	}), LogTo(func(dump string) {
+		dumps = append(dumps, dump)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer abc123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, "Authorization: <redacted>\n", w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	require.Len(t, dumps, 1)
+	assert.Contains(t, dumps[0], "Authorization: <redacted>")
+	assert.NotContains(t, dumps[0], "abc123")
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Authorization", "abc123")
+		_, _ = io.WriteString(w, "token=abc123")
+	}))
+	defer ts.Close()
+
+	var dumps []string
+	client := &http.Client{Transport: Transport(sanitizer(), nil, DumpBodies(), LogTo(func(dump string) {
+		dumps = append(dumps, dump)
+	}))}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("token=xyz789"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer xyz789")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "token=abc123", string(body), "the response itself must not be altered")
+
+	require.Len(t, dumps, 2)
+	for _, dump := range dumps {
+		assert.NotContains(t, dump, "abc123")
+		assert.NotContains(t, dump, "xyz789")
+	}
+	assert.Contains(t, dumps[0], "token=<redacted>")
+	assert.Contains(t, dumps[1], "token=<redacted>")
+}
@@ -0,0 +1,225 @@
+package execsanitize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNotifyInterval is used when a Batcher is created without an interval
+const DefaultNotifyInterval = 5 * time.Second
+
+// Notifier is told about matches in batches
+type Notifier interface {
+	Notify(ctx context.Context, b Batch) error
+}
+
+// Batch is a group of matches collected by a Batcher
+type Batch struct {
+	Matches []Match
+	// Dropped counts matches that did not fit into the batch
+	Dropped int
+}
+
+// Batcher collects matches and hands them to a Notifier in batches, at most once per interval
+type Batcher struct {
+	// Filter optionally selects which matches are collected
+	Filter func(Match) bool
+	// MaxBatch caps the number of matches per batch, extra matches are only counted. defaults to 100
+	MaxBatch int
+	// OnError is called when the Notifier fails
+	OnError func(error)
+
+	notifier Notifier
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending Batch
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewBatcher starts a batcher that notifies n every interval if any matches were collected
+func NewBatcher(n Notifier, interval time.Duration) *Batcher {
+	if interval <= 0 {
+		interval = DefaultNotifyInterval
+	}
+
+	b := &Batcher{
+		notifier: n,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+
+	return b
+}
+
+// Add collects a match. its signature makes it suitable as a Sanitizer's OnMatch hook
+func (b *Batcher) Add(m Match) {
+	if b.Filter != nil && !b.Filter(m) {
+		return
+	}
+
+	max := b.MaxBatch
+	if max <= 0 {
+		max = 100
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending.Matches) >= max {
+		b.pending.Dropped++
+		return
+	}
+	b.pending.Matches = append(b.pending.Matches, m)
+}
+
+func (b *Batcher) loop() {
+	defer close(b.done)
+
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.flush()
+		case <-b.stop:
+			b.flush()
+			return
+		}
+	}
+}
+
+func (b *Batcher) flush() {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = Batch{}
+	b.mu.Unlock()
+
+	if len(batch.Matches) == 0 && batch.Dropped == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.interval)
+	defer cancel()
+	if err := b.notifier.Notify(ctx, batch); err != nil && b.OnError != nil {
+		b.OnError(err)
+	}
+}
+
+// Close sends any pending matches and stops the batcher
+func (b *Batcher) Close() {
+	close(b.stop)
+	<-b.done
+}
+
+// WebhookNotifier posts batches as JSON to a URL. matched text is never included in the payload
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+	// RuleID names the rules of matches in the payload, see Sanitizer.RuleID, which it is usually set to. by
+	// default, rules without a name of their own are named rule, as their name may be what they match
+	RuleID func(*Rule) string
+	// IncludeReplacements adds the replacement of each match to the payload. it is off by default, as replacements
+	// such as masks, or those of replacers that keep part of their input, can carry secret text
+	IncludeReplacements bool
+}
+
+func (n *WebhookNotifier) ruleID(r *Rule) string {
+	if n.RuleID != nil {
+		return n.RuleID(r)
+	}
+	if r.namedAfterPattern() {
+		return "rule"
+	}
+
+	return r.Name
+}
+
+type webhookPayload struct {
+	// Text makes the payload usable with Slack-style incoming webhooks
+	Text    string         `json:"text"`
+	Matches []webhookMatch `json:"matches"`
+	Dropped int            `json:"dropped"`
+}
+
+type webhookMatch struct {
+	Rule        string `json:"rule"`
+	Stream      string `json:"stream,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, b Batch) error {
+	payload := webhookPayload{
+		Text:    summarizeBatch(b, n.ruleID),
+		Matches: make([]webhookMatch, 0, len(b.Matches)),
+		Dropped: b.Dropped,
+	}
+	for _, m := range b.Matches {
+		match := webhookMatch{Rule: n.ruleID(m.Rule), Stream: m.Stream}
+		if n.IncludeReplacements {
+			match.Replacement = m.Replacement
+		}
+		payload.Matches = append(payload.Matches, match)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func summarizeBatch(b Batch, ruleID func(*Rule) string) string {
+	counts := make(map[string]int)
+	for _, m := range b.Matches {
+		counts[ruleID(m.Rule)]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s x%d", name, counts[name]))
+	}
+	if b.Dropped > 0 {
+		parts = append(parts, fmt.Sprintf("%d more", b.Dropped))
+	}
+
+	total := len(b.Matches) + b.Dropped
+	return fmt.Sprintf("exec-sanitize: %d matches (%s)", total, strings.Join(parts, ", "))
+}
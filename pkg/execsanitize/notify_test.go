@@ -0,0 +1,91 @@
+package execsanitize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookBatcher(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		payloads []map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	rules := makeRules(
+		regexp.MustCompile(`tok-\d`), "<token>",
+		"noise", "-",
+	)
+	rules[0].Name = "token"
+	rules[1].Name = "noise"
+
+	b := NewBatcher(&WebhookNotifier{URL: srv.URL, IncludeReplacements: true}, time.Hour)
+	b.MaxBatch = 2
+	b.Filter = func(m Match) bool {
+		return m.Rule.Name == "token"
+	}
+	s := &Sanitizer{Rules: rules, OnMatch: b.Add}
+
+	s.Sanitize("tok-1 noise tok-2 tok-3")
+	b.Close()
+
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "exec-sanitize: 3 matches (token x2, 1 more)", payloads[0]["text"])
+	assert.EqualValues(t, 1, payloads[0]["dropped"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"rule": "token", "replacement": "<token>"},
+		map[string]interface{}{"rule": "token", "replacement": "<token>"},
+	}, payloads[0]["matches"])
+}
+
+func TestWebhookRuleID(t *testing.T) {
+	var payloads []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		payloads = append(payloads, p)
+	}))
+	defer srv.Close()
+
+	// rules without a name of their own, such as those of LoadConfig, are named after the secret they match
+	rules := makeRules("hunter2", "***", "noise", "-")
+	rules[0].Name = rules[0].Pattern.String()
+	rules[1].Name = "named"
+	s := &Sanitizer{Rules: rules}
+	assert.Equal(t, "rule-1", s.RuleID(rules[0]))
+	assert.Equal(t, "named", s.RuleID(rules[1]))
+	assert.Equal(t, "rule", s.RuleID(&Rule{}))
+
+	for _, n := range []*WebhookNotifier{{URL: srv.URL}, {URL: srv.URL, RuleID: s.RuleID}} {
+		b := NewBatcher(n, time.Hour)
+		s.OnMatch = b.Add
+		s.Sanitize("hunter2 noise")
+		b.Close()
+	}
+
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "exec-sanitize: 2 matches (named x1, rule x1)", payloads[0]["text"])
+	assert.Equal(t, "exec-sanitize: 2 matches (named x1, rule-1 x1)", payloads[1]["text"])
+	// replacements are left out unless asked for
+	assert.Equal(t, map[string]interface{}{"rule": "rule-1"}, payloads[1]["matches"].([]interface{})[0])
+	for _, p := range payloads {
+		body, err := json.Marshal(p)
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "hunter2")
+	}
+}
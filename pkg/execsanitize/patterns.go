@@ -0,0 +1,73 @@
+package execsanitize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GlobPattern converts a shell-style glob into a regexp. * matches any run of non-whitespace characters,
+// ? matches a single non-whitespace character, [...] matches a character class, negated with [!...],
+// and a backslash matches the next character literally
+func GlobPattern(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`\S*`)
+		case '?':
+			b.WriteString(`\S`)
+		case '\\':
+			if i+1 == len(glob) {
+				return "", withKind(ErrInvalidPattern, fmt.Errorf("trailing backslash in glob %s", glob))
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == 0 {
+				// a ] directly after the opening bracket is part of the class
+				if next := strings.IndexByte(glob[i+2:], ']'); next >= 0 {
+					end = next + 1
+				} else {
+					end = -1
+				}
+			}
+			if end < 0 {
+				return "", withKind(ErrInvalidPattern, fmt.Errorf("unterminated [ in glob %s", glob))
+			}
+
+			class := glob[i+1 : i+1+end]
+			b.WriteByte('[')
+			if strings.HasPrefix(class, "!") {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			b.WriteString(strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(class))
+			b.WriteByte(']')
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// WordPattern converts plain text into a regexp that only matches it as a whole word,
+// i.e. not when it is directly preceded or followed by a letter, digit or underscore
+func WordPattern(word string) string {
+	pattern := regexp.QuoteMeta(word)
+	if word != "" && isWordByte(word[0]) {
+		pattern = `\b` + pattern
+	}
+	if word != "" && isWordByte(word[len(word)-1]) {
+		pattern += `\b`
+	}
+
+	return pattern
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,48 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobPattern(t *testing.T) {
+	tcs := []struct {
+		glob, in, want string
+	}{
+		{glob: "ghp_*", in: "token ghp_abc123 used", want: "token <redacted> used"},
+		{glob: "key-??", in: "key-01 key-001 key-1", want: "<redacted> <redacted>1 key-1"},
+		{glob: "v[0-9].[!0]", in: "v1.2 v1.0 va.1", want: "<redacted> v1.0 va.1"},
+		{glob: "[]]x", in: "]x", want: "<redacted>"},
+		{glob: `a.b\*`, in: "a.b* axb*", want: "<redacted> axb*"},
+	}
+
+	for _, tc := range tcs {
+		pattern, err := GlobPattern(tc.glob)
+		require.NoError(t, err, tc.glob)
+		s := &Sanitizer{Rules: makeRules(regexp.MustCompile(pattern), "<redacted>")}
+		assert.Equal(t, tc.want, s.Sanitize(tc.in), tc.glob)
+	}
+
+	_, err := GlobPattern("[abc")
+	assert.EqualError(t, err, "unterminated [ in glob [abc")
+	_, err = GlobPattern(`abc\`)
+	assert.EqualError(t, err, `trailing backslash in glob abc\`)
+}
+
+func TestWordPattern(t *testing.T) {
+	tcs := []struct {
+		word, in, want string
+	}{
+		{word: "secret", in: "secret secrets mysecret secret.", want: "<redacted> secrets mysecret <redacted>."},
+		{word: "a.b", in: "a.b axb xa.b", want: "<redacted> axb xa.b"},
+		{word: "-v", in: "run -v -vv", want: "run <redacted> -vv"},
+	}
+
+	for _, tc := range tcs {
+		s := &Sanitizer{Rules: makeRules(regexp.MustCompile(WordPattern(tc.word)), "<redacted>")}
+		assert.Equal(t, tc.want, s.Sanitize(tc.in), tc.word)
+	}
+}
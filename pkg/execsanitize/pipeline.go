@@ -0,0 +1,200 @@
+package execsanitize
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+)
+
+// Pipeline sanitizes the input of writers on a pool of workers, so that writers that are written to a lot at once,
+// such as a command's stdout and stderr or the streams of a server, do not wait on each other, and a writer's input
+// is sanitized while its earlier output is being written. a writer's output is still written in the order of its
+// input. a pipeline may be shared by any number of writers, see WithPipeline
+type Pipeline struct {
+	jobs      chan *pipelineJob
+	workers   int
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// pipelineJob is a piece of a writer's input, made up of complete lines if lines is set
+type pipelineJob struct {
+	sw    *SanitizerWriter
+	seq   uint64
+	lines bool
+	// flush is set for the input held back until the writer was flushed, which eol is written after
+	flush bool
+	eol   string
+	text  []byte
+	// line is the line of the writer's stream the text starts on
+	line int
+	out  *[]byte
+}
+
+// NewPipeline starts a pipeline with the given number of workers, or one per CPU if workers is not positive.
+// the pipeline should be closed once all of its writers have been flushed
+func NewPipeline(workers int) *Pipeline {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	pl := &Pipeline{jobs: make(chan *pipelineJob, workers), workers: workers}
+	pl.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer pl.wg.Done()
+			for job := range pl.jobs {
+				job.sw.runJob(job)
+			}
+		}()
+	}
+
+	return pl
+}
+
+// Close stops the pipeline's workers once they have sanitized the input they were handed.
+// writers that use the pipeline must not be written to after it is closed
+func (pl *Pipeline) Close() {
+	pl.closeOnce.Do(func() {
+		close(pl.jobs)
+	})
+	pl.wg.Wait()
+}
+
+// WithPipeline makes a writer sanitize its input on a pipeline's workers. Write returns once the input has been
+// handed to the pipeline, and errors of the underlying writer are returned by later writes and Flush, which waits for
+// all output to be written. as the pieces of a writer's input are sanitized at the same time, OnMatch hooks and the
+// LineFilter may be called concurrently, and matches may be reported out of order. while the sanitizer has rules
+// with regions, which depend on the text before them, input is sanitized as it is written instead
+func WithPipeline(pl *Pipeline) WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.pipeline = pl
+		sw.slots = make(chan struct{}, 2*pl.workers)
+		sw.done = make(map[uint64]*pipelineJob)
+	}
+}
+
+// parallel reports whether the writer's input is handed to its pipeline
+func (sw *SanitizerWriter) parallel() bool {
+	if sw.pipeline == nil || sw.spill != nil || sw.s.bypass() {
+		return false
+	}
+	for _, rule := range sw.s.rules() {
+		if rule.Region != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeParallel hands the complete lines, or complete UTF-8 sequences, of the written input to the pipeline
+func (sw *SanitizerWriter) writeParallel(p []byte) (int, error) {
+	written := p
+	p = sw.normalizeLineEndings(p)
+
+	var (
+		held  = len(sw.buf)
+		data  = append(sw.buf, p...)
+		k     int
+		lines = sw.lineBuffered()
+	)
+	if lines {
+		// as with Write, only the written bytes and the end of the held back ones are looked for a delimiter
+		delim := []byte(sw.s.delimiter())
+		from := max(held-len(delim)+1, 0)
+		if i := bytes.LastIndex(data[from:], delim); i >= 0 {
+			k = from + i + len(delim)
+		}
+	} else {
+		k = len(data) - incompleteRuneLen(data)
+	}
+	if k == 0 {
+		sw.buf = data
+	} else {
+		text := make([]byte, k)
+		copy(text, data)
+		sw.buf = append(data[:0], data[k:]...)
+		sw.submit(&pipelineJob{lines: lines, text: text, line: sw.lineAt(sw.countLines(text))})
+	}
+	if lines {
+		// a spilled line is sanitized as it is written, once the output of earlier input has been written
+		return sw.written(written, sw.spillHeld())
+	}
+
+	return sw.written(written, nil)
+}
+
+// submit hands a piece of input to the pipeline, waiting if too much of the writer's output is yet to be written
+func (sw *SanitizerWriter) submit(job *pipelineJob) {
+	sw.slots <- struct{}{}
+	sw.pending.Add(1)
+	job.sw, job.seq = sw, sw.seq
+	sw.seq++
+	sw.pipeline.jobs <- job
+}
+
+// runJob sanitizes a piece of input and writes out the output of the writer's jobs that are next in line
+func (sw *SanitizerWriter) runJob(job *pipelineJob) {
+	job.out = outputBuffers.Get().(*[]byte)
+	if job.flush {
+		*job.out = sw.appendHeld(*job.out, job.line, job.text, job.eol)
+	} else {
+		*job.out = sw.sanitizeChunk(*job.out, job.line, job.text, job.lines)
+	}
+
+	sw.seqMu.Lock()
+	defer sw.seqMu.Unlock()
+
+	sw.done[job.seq] = job
+	for {
+		next, ok := sw.done[sw.next]
+		if !ok {
+			break
+		}
+		delete(sw.done, sw.next)
+		sw.next++
+
+		if err := sw.Err(); err != nil && !sw.bestEffort {
+			// a strict writer writes nothing after an error
+			*next.out = (*next.out)[:0]
+		}
+		_ = sw.writeOut(next.out)
+		<-sw.slots
+		sw.pending.Done()
+	}
+}
+
+// sanitizeChunk sanitizes a piece of input on its own, line by line if lines is set, appending its output to dst
+func (sw *SanitizerWriter) sanitizeChunk(dst []byte, at int, text []byte, lines bool) []byte {
+	if !lines {
+		return append(dst, sw.s.sanitize(string(text), sw.passAt(at))...)
+	}
+
+	delim := []byte(sw.s.delimiter())
+	if sw.s.LineFilter == nil && string(delim) == "\n" && bytes.IndexByte(text, '\r') < 0 && sw.s.passThrough(text, sw.passAt(at)) {
+		return append(dst, text...)
+	}
+	for ; len(text) > 0 && !sw.s.Terminated(); at++ {
+		i := bytes.Index(text, delim)
+		dst = sw.appendLine(dst, at, string(text[:i]), string(delim))
+		text = text[i+len(delim):]
+	}
+
+	return dst
+}
+
+// flushParallel hands the held back input to the pipeline and waits for all output to be written
+func (sw *SanitizerWriter) flushParallel(eol string) error {
+	if len(sw.buf) > 0 || eol != "" {
+		text := append([]byte(nil), sw.buf...)
+		sw.buf = sw.buf[:0]
+		sw.submit(&pipelineJob{flush: true, eol: eol, text: text, line: sw.lineAt(sw.countLines([]byte(eol)))})
+	}
+	sw.pending.Wait()
+
+	if err := sw.Err(); err != nil && !sw.bestEffort {
+		return err
+	}
+	return nil
+}
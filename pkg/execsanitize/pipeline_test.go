@@ -0,0 +1,93 @@
+package execsanitize
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	pl := NewPipeline(4)
+	defer pl.Close()
+
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(`password=\S+`), "password=***", "hunter2", "<redacted>")}
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, "line %d", i)
+		if i%7 == 0 {
+			input.WriteString(" password=hunter2 hunter2")
+		}
+		input.WriteString("\n")
+	}
+	in := input.String() + "no newline hunter2"
+	write := func(w *SanitizerWriter) {
+		for off := 0; off < len(in); off += 100 {
+			_, err := w.Write([]byte(in[off:min(off+100, len(in))]))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Flush())
+	}
+
+	for _, opts := range [][]WriterOption{nil, {LineBuffered()}, {LineBuffered(), NormalizeLineEndings()}} {
+		var expect bytes.Buffer
+		write(s.Writer(&expect, opts...))
+
+		// streams sharing the pipeline are each written out in order
+		var wg sync.WaitGroup
+		outs := make([]bytes.Buffer, 2)
+		for i := range outs {
+			wg.Add(1)
+			go func(out *bytes.Buffer) {
+				defer wg.Done()
+				write(s.Writer(out, append(opts, WithPipeline(pl))...))
+			}(&outs[i])
+		}
+		wg.Wait()
+
+		for _, out := range outs {
+			assert.Equal(t, expect.String(), out.String())
+		}
+	}
+}
+
+func TestPipelineRegions(t *testing.T) {
+	pl := NewPipeline(2)
+	defer pl.Close()
+
+	// rules with regions depend on the text before them, so input is sanitized as it is written
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(`.+`), "<redacted>")}
+	s.Rules[0].Region = &Region{Begin: regexp.MustCompile(`^BEGIN$`), End: regexp.MustCompile(`^END$`)}
+
+	var out bytes.Buffer
+	w := s.Writer(&out, LineBuffered(), WithPipeline(pl))
+	for _, write := range []string{"a\nBEGIN\nx=1\n", "y=2\n", "END\nb\n"} {
+		_, err := w.Write([]byte(write))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "a\nBEGIN\n<redacted>\n<redacted>\nEND\nb\n", out.String())
+}
+
+func TestPipelineErrors(t *testing.T) {
+	pl := NewPipeline(2)
+	defer pl.Close()
+
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+	fw := &flakyWriter{max: 3, budget: 5}
+	w := s.Writer(fw, LineBuffered(), WithPipeline(pl))
+	_, err := w.Write([]byte("password hunter2\n"))
+	require.NoError(t, err)
+
+	// errors are returned once the output has been written
+	assert.Equal(t, errBroken, w.Flush())
+	fw.budget = 100
+	_, err = w.Write([]byte("ok\n"))
+	assert.Equal(t, errBroken, err)
+	assert.Equal(t, "passw", fw.String())
+}
@@ -0,0 +1,96 @@
+package presets
+
+import (
+	"strings"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// ciProvider describes a CI environment
+type ciProvider struct {
+	name string
+	// detect is an env var that is set to true by the provider
+	detect string
+	// secrets are sensitive env vars set by the provider that sensitiveEnvVar does not catch
+	secrets []string
+}
+
+var ciProviders = []ciProvider{
+	{name: "github-actions", detect: "GITHUB_ACTIONS"},
+	{name: "gitlab-ci", detect: "GITLAB_CI", secrets: []string{"CI_JOB_JWT", "CI_JOB_JWT_V1", "CI_JOB_JWT_V2", "CI_REPOSITORY_URL"}},
+	{name: "circleci", detect: "CIRCLECI"},
+	{name: "buildkite", detect: "BUILDKITE"},
+}
+
+// sensitiveSuffixes are the suffixes of env var names that are considered sensitive in any environment,
+// such as GITHUB_TOKEN and AWS_SECRET_ACCESS_KEY
+var sensitiveSuffixes = []string{"_TOKEN", "_PASSWORD", "_PASSWD", "_SECRET", "_SECRET_KEY", "_ACCESS_KEY", "_API_KEY", "_PRIVATE_KEY"}
+
+// DetectCI returns the name of the CI provider the environment belongs to, or an empty string
+func DetectCI(environ []string) string {
+	env := envMap(environ)
+	for _, p := range ciProviders {
+		if env[p.detect] == "true" {
+			return p.name
+		}
+	}
+
+	return ""
+}
+
+// EnvSecrets returns rules that redact the values of sensitive env vars, such as *_TOKEN and *_PASSWORD,
+// and of secrets set by the detected CI provider. each value is replaced with the name of its env var in angle
+// brackets. environ is in the form returned by os.Environ
+func EnvSecrets(environ []string) []*execsanitize.Rule {
+	env := envMap(environ)
+
+	var provider ciProvider
+	name := DetectCI(environ)
+	for _, p := range ciProviders {
+		if p.name == name {
+			provider = p
+		}
+	}
+
+	secrets := make(map[string]string)
+	for key, value := range env {
+		if sensitiveEnvVar(key) || containsString(provider.secrets, key) {
+			secrets[key] = value
+		}
+	}
+
+	return execsanitize.SecretRules("env:", secrets)
+}
+
+// sensitiveEnvVar reports whether an env var is considered sensitive by its name
+func sensitiveEnvVar(key string) bool {
+	key = strings.ToUpper(key)
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func envMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+
+	return env
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
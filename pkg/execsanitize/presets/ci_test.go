@@ -0,0 +1,36 @@
+package presets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func TestDetectCI(t *testing.T) {
+	assert.Equal(t, "github-actions", DetectCI([]string{"CI=true", "GITHUB_ACTIONS=true"}))
+	assert.Equal(t, "gitlab-ci", DetectCI([]string{"GITLAB_CI=true"}))
+	assert.Equal(t, "", DetectCI([]string{"HOME=/root"}))
+}
+
+func TestEnvSecrets(t *testing.T) {
+	environ := []string{
+		"HOME=/root",
+		"GITHUB_TOKEN=ghs_abcdef",
+		"DB_PASSWORD=hunter2",
+		"SHORT_TOKEN=abc",
+		"AWS_SECRET_ACCESS_KEY=wJalrXUtnFEMI",
+		"DEPLOY_SECRET=wJalrXUtnFEMI-staging",
+		"CI_JOB_JWT=eyJhbGci.eyJzdWIi.c2ln",
+	}
+
+	s := &execsanitize.Sanitizer{Rules: EnvSecrets(environ)}
+	assert.Equal(t,
+		"<GITHUB_TOKEN> <DB_PASSWORD> abc <DEPLOY_SECRET> <AWS_SECRET_ACCESS_KEY> eyJhbGci.eyJzdWIi.c2ln /root",
+		s.Sanitize("ghs_abcdef hunter2 abc wJalrXUtnFEMI-staging wJalrXUtnFEMI eyJhbGci.eyJzdWIi.c2ln /root"),
+	)
+
+	s = &execsanitize.Sanitizer{Rules: EnvSecrets(append(environ, "GITLAB_CI=true"))}
+	assert.Equal(t, "<CI_JOB_JWT>", s.Sanitize("eyJhbGci.eyJzdWIi.c2ln"))
+}
@@ -0,0 +1,31 @@
+package presets
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Logfmt returns a rule that replaces the values of the given keys in logfmt lines, such as password=hunter2.
+// quoted values, which may contain spaces and escaped quotes, are replaced as a whole and stay quoted.
+// a quoted value that is not terminated is replaced up to the end of the line
+func Logfmt(keys []string, replacement string) *execsanitize.Rule {
+	quoted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		quoted = append(quoted, regexp.QuoteMeta(key))
+	}
+	pattern := regexp.MustCompile(`(?:^|\s)(?:` + strings.Join(quoted, "|") + `)=(?:"(?:[^"\\\n]|\\.)*"|"[^\n]*|[^\s"]+)`)
+
+	return &execsanitize.Rule{
+		Name:    "logfmt",
+		Pattern: pattern,
+		Replacer: func(match string) string {
+			i := strings.IndexByte(match, '=')
+			if strings.HasPrefix(match[i+1:], `"`) {
+				return match[:i+1] + `"` + replacement + `"`
+			}
+			return match[:i+1] + replacement
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package presets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func TestLogfmt(t *testing.T) {
+	s := &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{Logfmt([]string{"password", "api_key"}, "<redacted>")}}
+
+	tcs := []struct{ in, want string }{
+		{
+			in:   `level=info msg="login" user=kamal password=hunter2 api_key=abc`,
+			want: `level=info msg="login" user=kamal password=<redacted> api_key=<redacted>`,
+		},
+		{
+			in:   `password="correct horse \"battery\" staple" level=warn`,
+			want: `password="<redacted>" level=warn`,
+		},
+		{
+			in:   "msg=retry api_key=\"truncated value\npassword= old_password=x",
+			want: "msg=retry api_key=\"<redacted>\"\npassword= old_password=x",
+		},
+		{
+			in:   `msg="password=hunter2"`,
+			want: `msg="password=hunter2"`,
+		},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.want, s.Sanitize(tc.in), tc.in)
+	}
+}
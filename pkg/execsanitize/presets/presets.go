@@ -0,0 +1,63 @@
+// Package presets provides ready-made sanitization rules for common tools and secret formats
+package presets
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+//go:embed packs/*.yaml
+var packs embed.FS
+
+// Packs returns the names of all built-in rule packs
+func Packs() []string {
+	entries, _ := packs.ReadDir("packs")
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// PackConfig returns the config of a built-in rule pack
+func PackConfig(name string) (*execsanitize.Config, error) {
+	data, err := packs.ReadFile(path.Join("packs", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown pack %s", name)
+	}
+
+	return execsanitize.ParseConfig(data)
+}
+
+// Pack returns the rules of a built-in rule pack
+func Pack(name string) ([]*execsanitize.Rule, error) {
+	c, err := PackConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := c.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", name, err)
+	}
+
+	return rules, nil
+}
+
+// mustPack returns the rules of a built-in pack, panicking if it is invalid
+func mustPack(name string) []*execsanitize.Rule {
+	rules, err := Pack(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return rules
+}
@@ -0,0 +1,9 @@
+package presets
+
+import "github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+
+// SSH returns rules that scrub host key noise printed by ssh and scp: known_hosts warnings are dropped,
+// while key fingerprints and public host keys are replaced with placeholders
+func SSH() []*execsanitize.Rule {
+	return mustPack("ssh")
+}
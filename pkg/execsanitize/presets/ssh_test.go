@@ -0,0 +1,36 @@
+package presets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func TestSSH(t *testing.T) {
+	s := &execsanitize.Sanitizer{Rules: SSH()}
+
+	tcs := [][]string{
+		{
+			"Warning: Permanently added 'example.com,93.184.216.34' (ED25519) to the list of known hosts.\r\nwelcome\n",
+			"welcome\n",
+		},
+		{
+			"ED25519 key fingerprint is SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU.",
+			"ED25519 key fingerprint is SHA256:<fingerprint>.",
+		},
+		{
+			"RSA key fingerprint is MD5:d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e.",
+			"RSA key fingerprint is MD5:<fingerprint>.",
+		},
+		{
+			"example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n",
+			"example.com ssh-ed25519 <host-key>\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc[1], s.Sanitize(tc[0]))
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package execsanitize
+
+// raceEnabled is set if the tests are built with the race detector, which allocates on its own
+const raceEnabled = true
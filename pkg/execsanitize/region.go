@@ -0,0 +1,110 @@
+package execsanitize
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Region restricts a rule to the text between a match of Begin and the following match of End, exclusive of
+// the markers themselves. writers keep track of open regions across writes, so a region can span many lines.
+// markers must not match an empty string.
+//
+// a rule with a region but no pattern matches each whole region, markers included, as a single block.
+// if a block spans several writes, its replacement is written in place of the first part and the rest is dropped,
+// along with any lines of a line-buffered writer that are entirely inside the block
+type Region struct {
+	Begin, End *regexp.Regexp
+}
+
+// regionSpan is a part of a text inside a region
+type regionSpan struct {
+	// start and end exclude the markers, outerStart and outerEnd include them
+	start, end           int
+	outerStart, outerEnd int
+	// cont is set if the region was opened in an earlier piece of text
+	cont bool
+}
+
+// find returns the locations of the rule's matches, limited to its region if it has one. for a block rule,
+// cont is the continuation of a block opened in an earlier piece of text, which is not a match of its own
+func (p *pass) find(rule *Rule, in string) (locs [][]int, cont []int) {
+	if rule.Region == nil {
+		return p.findAll(rule.Pattern, in), nil
+	}
+
+	for _, span := range p.regionSpans(rule, in) {
+		if rule.Pattern == nil {
+			if span.cont {
+				cont = []int{span.outerStart, span.outerEnd}
+			} else {
+				locs = append(locs, []int{span.outerStart, span.outerEnd})
+			}
+			continue
+		}
+
+		for _, loc := range p.findAll(rule.Pattern, in[span.start:span.end]) {
+			locs = append(locs, []int{span.start + loc[0], span.start + loc[1]})
+		}
+	}
+
+	return locs, cont
+}
+
+// findAll returns the locations of a pattern's matches in a text, folding the text first if the sanitizer folds Unicode
+func (p *pass) findAll(re *regexp.Regexp, in string) [][]int {
+	if !p.fold {
+		if literal, ok := p.gates[re]; ok && !strings.Contains(in, literal) {
+			return nil
+		}
+		return re.FindAllStringIndex(in, -1)
+	}
+
+	return foldUnicode(in).findAll(re)
+}
+
+// regionSpans returns the parts of in that are inside the rule's region and records whether the region
+// is still open at the end of in
+func (p *pass) regionSpans(rule *Rule, in string) []regionSpan {
+	if p.regions == nil {
+		p.regions = make(map[*Rule]bool)
+	}
+
+	var (
+		spans []regionSpan
+		open  = p.regions[rule]
+		span  = regionSpan{cont: open}
+		pos   int
+	)
+	for pos < len(in) {
+		marker := rule.Region.Begin
+		if open {
+			marker = rule.Region.End
+		}
+
+		loc := marker.FindStringIndex(in[pos:])
+		if loc == nil {
+			break
+		}
+		if loc[0] == loc[1] {
+			// skip empty matches rather than toggling the region without making progress
+			pos += loc[1] + 1
+			continue
+		}
+
+		if open {
+			span.end, span.outerEnd = pos+loc[0], pos+loc[1]
+			spans = append(spans, span)
+		} else {
+			span = regionSpan{start: pos + loc[1], outerStart: pos + loc[0]}
+		}
+		pos += loc[1]
+		open = !open
+	}
+	if open {
+		span.end, span.outerEnd = len(in), len(in)
+		spans = append(spans, span)
+	}
+	p.regions[rule] = open
+
+	return spans
+}
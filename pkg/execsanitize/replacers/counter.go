@@ -0,0 +1,28 @@
+package replacers
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Counter numbers matches from 1 in the order they are replaced, replacing each with format(n, match).
+// it is safe for concurrent use, e.g. by the writers of a command's stdout and stderr sharing a sanitizer
+func Counter(format func(n int64, match string) string) execsanitize.ReplacerFunc {
+	var count int64
+	return func(in string) string {
+		return format(atomic.AddInt64(&count, 1), in)
+	}
+}
+
+// Synchronized makes a stateful replacer safe for concurrent use by running one call to it at a time
+func Synchronized(r execsanitize.ReplacerFunc) execsanitize.ReplacerFunc {
+	var mu sync.Mutex
+	return func(in string) string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return r(in)
+	}
+}
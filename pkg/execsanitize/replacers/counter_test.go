@@ -0,0 +1,55 @@
+package replacers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func TestCounter(t *testing.T) {
+	counter := Counter(func(n int64, match string) string {
+		return fmt.Sprintf("<%d:%s>", n, match)
+	})
+	assert.Equal(t, "<1:a>", counter("a"))
+	assert.Equal(t, "<2:b>", counter("b"))
+
+	var (
+		n     int
+		count = Synchronized(func(string) string {
+			n++
+			return fmt.Sprint(n)
+		})
+	)
+	for _, r := range []execsanitize.ReplacerFunc{counter, count} {
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			seen []string
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					out := r("x")
+					mu.Lock()
+					seen = append(seen, out)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		// every call got a distinct number
+		sort.Strings(seen)
+		for i := 1; i < len(seen); i++ {
+			assert.NotEqual(t, seen[i-1], seen[i])
+		}
+		assert.Len(t, seen, 800)
+	}
+}
@@ -0,0 +1,90 @@
+package replacers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout bounds each run of an ExecReplacer's command if it has no Timeout
+const DefaultExecTimeout = 5 * time.Second
+
+// DefaultExecFallback replaces matches whose command fails if an ExecReplacer has no Fallback
+const DefaultExecFallback = "<redacted>"
+
+// ExecReplacer replaces each match with the output of an external command, which is run with the match on its
+// stdin, so that replacements can come from programs such as tokenization services. trailing newlines are
+// removed from the output. matches whose command fails are replaced with the fallback, never left as they are.
+// it is safe for concurrent use
+type ExecReplacer struct {
+	// Path and Args are the command to run, as with exec.Command
+	Path string
+	Args []string
+	// Timeout bounds each run of the command, defaulting to DefaultExecTimeout
+	Timeout time.Duration
+	// Fallback replaces matches whose command fails or times out, defaulting to DefaultExecFallback
+	Fallback string
+	// OnError optionally receives the errors of failed runs
+	OnError func(error)
+
+	sem chan struct{}
+}
+
+// NewExecReplacer creates a replacer that runs at most concurrency commands at once, or any number of them
+// if concurrency is not positive
+func NewExecReplacer(path string, args []string, concurrency int) *ExecReplacer {
+	e := &ExecReplacer{Path: path, Args: args}
+	if concurrency > 0 {
+		e.sem = make(chan struct{}, concurrency)
+	}
+
+	return e
+}
+
+// Replace runs the command for a match, it is suitable as a ReplacerFunc
+func (e *ExecReplacer) Replace(in string) string {
+	out, err := e.run(in)
+	if err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		}
+		if e.Fallback != "" {
+			return e.Fallback
+		}
+		return DefaultExecFallback
+	}
+
+	return out
+}
+
+func (e *ExecReplacer) run(in string) (string, error) {
+	if e.sem != nil {
+		e.sem <- struct{}{}
+		defer func() { <-e.sem }()
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, e.Path, e.Args...)
+	c.Stdin = strings.NewReader(in)
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+		} else if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", fmt.Errorf("running %s: %w", e.Path, err)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,38 @@
+package replacers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecReplacer(t *testing.T) {
+	e := NewExecReplacer("sh", []string{"-c", `printf '<%s>\n' "$(tr a-z A-Z)"`}, 2)
+	assert.Equal(t, "<HUNTER2>", e.Replace("hunter2"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "<SWORDFISH>", e.Replace("swordfish"))
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	failing := NewExecReplacer("sh", []string{"-c", "echo broken >&2; exit 3"}, 0)
+	failing.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, DefaultExecFallback, failing.Replace("hunter2"))
+	require.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "running sh: exit status 3: broken")
+
+	slow := NewExecReplacer("sleep", []string{"10"}, 0)
+	slow.Timeout, slow.Fallback = 50*time.Millisecond, "<timeout>"
+	assert.Equal(t, "<timeout>", slow.Replace("hunter2"))
+}
@@ -0,0 +1,38 @@
+package replacers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// DefaultHashLength is the number of hex characters Hash keeps if given a non-positive length
+const DefaultHashLength = 8
+
+// Hash replaces each match with the first length hex characters of its SHA-256 hash, so that occurrences
+// of the same value can be correlated without revealing it. if key is set, an HMAC-SHA256 keyed with it
+// is used instead, which prevents confirming guesses of low-entropy values without the key
+func Hash(key []byte, length int) execsanitize.ReplacerFunc {
+	if length <= 0 {
+		length = DefaultHashLength
+	}
+	if length > sha256.Size*2 {
+		length = sha256.Size * 2
+	}
+
+	return func(in string) string {
+		var sum []byte
+		if key != nil {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(in))
+			sum = mac.Sum(nil)
+		} else {
+			h := sha256.Sum256([]byte(in))
+			sum = h[:]
+		}
+
+		return hex.EncodeToString(sum)[:length]
+	}
+}
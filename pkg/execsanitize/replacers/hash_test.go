@@ -0,0 +1,19 @@
+package replacers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	// echo -n hunter2 | sha256sum
+	assert.Equal(t, "f52fbd32", Hash(nil, 0)("hunter2"))
+	assert.Equal(t, "f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7", Hash(nil, 100)("hunter2"))
+
+	// echo -n hunter2 | openssl dgst -sha256 -hmac key
+	keyed := Hash([]byte("key"), 12)
+	assert.Equal(t, "05d210d8af05", keyed("hunter2"))
+	assert.Equal(t, keyed("hunter2"), keyed("hunter2"))
+	assert.NotEqual(t, keyed("hunter2"), keyed("hunter3"))
+}
@@ -0,0 +1,78 @@
+package replacers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/netip"
+	"regexp"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// ipCandidate matches text that may be an IPv4 or IPv6 address, candidates are checked with netip.ParseAddr
+var ipCandidate = regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}(?:(?:[0-9]{1,3}\.){3}[0-9]{1,3}|[0-9a-f]{1,4})?|\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)
+
+// replaceIPs replaces every IP address in the match using anonymize, keeping the text around them
+func replaceIPs(anonymize func(netip.Addr) netip.Addr) execsanitize.ReplacerFunc {
+	return func(in string) string {
+		if addr, err := netip.ParseAddr(in); err == nil {
+			return anonymize(addr.WithZone("")).String()
+		}
+
+		return ipCandidate.ReplaceAllStringFunc(in, func(candidate string) string {
+			addr, err := netip.ParseAddr(candidate)
+			if err != nil {
+				return candidate
+			}
+			return anonymize(addr).String()
+		})
+	}
+}
+
+// AnonymizeIP replaces IP addresses with pseudonymous ones in a prefix-preserving way, in the spirit of
+// Crypto-PAn: addresses that share an n-bit prefix are replaced with addresses that share an n-bit prefix,
+// so that subnets can still be told apart. the mapping is keyed with an HMAC-SHA256 key, without which it
+// cannot be reversed by trying every address. addresses within the match are replaced, other text is kept
+func AnonymizeIP(key []byte) execsanitize.ReplacerFunc {
+	return replaceIPs(func(addr netip.Addr) netip.Addr {
+		in := addr.AsSlice()
+		out := make([]byte, len(in))
+		prefix := make([]byte, len(in))
+
+		for i := 0; i < len(in)*8; i++ {
+			// flip each bit according to a pseudorandom function of the bits before it
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte{byte(len(in)), byte(i)})
+			mac.Write(prefix)
+			flip := mac.Sum(nil)[0] >> 7
+
+			bit := in[i/8] >> (7 - i%8) & 1
+			out[i/8] |= (bit ^ flip) << (7 - i%8)
+			prefix[i/8] |= bit << (7 - i%8)
+		}
+
+		anonymized, _ := netip.AddrFromSlice(out)
+		return anonymized
+	})
+}
+
+// TruncateIP replaces IP addresses with their network address, keeping the first v4Bits bits of IPv4 and
+// v6Bits bits of IPv6 addresses, e.g. 192.0.2.0 for 192.0.2.17 with v4Bits 24.
+// addresses within the match are replaced, other text is kept
+func TruncateIP(v4Bits, v6Bits int) execsanitize.ReplacerFunc {
+	return replaceIPs(func(addr netip.Addr) netip.Addr {
+		bits := v6Bits
+		if addr.Is4() {
+			bits = v4Bits
+		}
+		if bits < 0 {
+			bits = 0
+		}
+		if bits > addr.BitLen() {
+			bits = addr.BitLen()
+		}
+
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr()
+	})
+}
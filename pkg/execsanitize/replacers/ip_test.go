@@ -0,0 +1,43 @@
+package replacers
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	anonymize := AnonymizeIP([]byte("key"))
+
+	a, b, c := anonymize("10.1.2.3"), anonymize("10.1.2.200"), anonymize("10.1.9.3")
+	assert.NotEqual(t, "10.1.2.3", a)
+	assert.Equal(t, a, anonymize("10.1.2.3"))
+	assert.Equal(t, prefixOf(t, a, 24), prefixOf(t, b, 24))
+	assert.NotEqual(t, prefixOf(t, a, 24), prefixOf(t, c, 24))
+	assert.Equal(t, prefixOf(t, a, 16), prefixOf(t, c, 16))
+	assert.NotEqual(t, a, AnonymizeIP([]byte("other"))("10.1.2.3"))
+
+	v6 := anonymize("2001:db8::1")
+	assert.True(t, netip.MustParseAddr(v6).Is6())
+	assert.Equal(t, prefixOf(t, v6, 64), prefixOf(t, anonymize("2001:db8::2"), 64))
+
+	out := anonymize("from 10.1.2.3:443 at 12:30:01 via fe80::1")
+	assert.Equal(t, "from "+a+":443 at 12:30:01 via "+anonymize("fe80::1"), out)
+}
+
+func TestTruncateIP(t *testing.T) {
+	truncate := TruncateIP(24, 48)
+	assert.Equal(t, "192.0.2.0", truncate("192.0.2.17"))
+	assert.Equal(t, "2001:db8:1::", truncate("2001:db8:1:2::17"))
+	assert.Equal(t, "client=192.0.2.0 port=80", truncate("client=192.0.2.17 port=80"))
+	assert.Equal(t, "999.1.2.3", truncate("999.1.2.3"))
+	assert.Equal(t, "0.0.0.0", TruncateIP(-1, 200)("192.0.2.17"))
+	assert.Equal(t, "2001:db8::17", TruncateIP(-1, 200)("2001:db8::17"))
+}
+
+func prefixOf(t *testing.T, addr string, bits int) netip.Prefix {
+	prefix, err := netip.MustParseAddr(addr).Prefix(bits)
+	assert.NoError(t, err)
+	return prefix
+}
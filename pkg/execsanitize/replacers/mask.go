@@ -0,0 +1,41 @@
+package replacers
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// Mask keeps the first keepStart and last keepEnd characters of a match and replaces the rest with maskChar,
+// so that Mask(5, 2, '*') turns sk-abcdef123456 into sk-ab********56. matches too short to hide anything
+// are masked entirely
+func Mask(keepStart, keepEnd int, maskChar rune) execsanitize.ReplacerFunc {
+	return func(in string) string {
+		runes := []rune(in)
+		if keepStart < 0 || keepEnd < 0 || keepStart+keepEnd >= len(runes) {
+			return strings.Repeat(string(maskChar), len(runes))
+		}
+
+		var b strings.Builder
+		b.WriteString(string(runes[:keepStart]))
+		b.WriteString(strings.Repeat(string(maskChar), len(runes)-keepStart-keepEnd))
+		b.WriteString(string(runes[len(runes)-keepEnd:]))
+		return b.String()
+	}
+}
+
+// MaskAll replaces every character of a match with maskChar
+func MaskAll(maskChar rune) execsanitize.ReplacerFunc {
+	return func(in string) string {
+		return strings.Repeat(string(maskChar), utf8.RuneCountInString(in))
+	}
+}
+
+// MaskFixed replaces a match with n maskChars, hiding its length
+func MaskFixed(n int, maskChar rune) execsanitize.ReplacerFunc {
+	masked := strings.Repeat(string(maskChar), n)
+	return func(string) string {
+		return masked
+	}
+}
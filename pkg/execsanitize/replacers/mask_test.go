@@ -0,0 +1,28 @@
+package replacers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	tcs := []struct {
+		name     string
+		replacer func(string) string
+		in, want string
+	}{
+		{"keep prefix and suffix", Mask(5, 2, '*'), "sk-abcdef123456", "sk-ab********56"},
+		{"keep prefix only", Mask(3, 0, '#'), "sk-abcdef", "sk-######"},
+		{"too short", Mask(2, 2, '*'), "abcd", "****"},
+		{"multibyte", Mask(1, 1, '•'), "pässwörd", "p••••••d"},
+		{"all", MaskAll('x'), "hünter2", "xxxxxxx"},
+		{"fixed", MaskFixed(8, '*'), "a", "********"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.replacer(tc.in))
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package replacers
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// FormatPreserving replaces letters with x or X and digits with 0 while keeping all other characters,
+// so that the length and shape of a match survive for parsers relying on fixed widths or formats.
+// the first keepStart and last keepEnd letters and digits are left as they are, so that
+// FormatPreserving(0, 4) turns 4111-1111-1111-1234 into 0000-0000-0000-1234
+func FormatPreserving(keepStart, keepEnd int) execsanitize.ReplacerFunc {
+	return func(in string) string {
+		var total int
+		for _, r := range in {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				total++
+			}
+		}
+		if keepStart+keepEnd >= total {
+			keepStart, keepEnd = 0, 0
+		}
+
+		var (
+			b   strings.Builder
+			idx int
+		)
+		for _, r := range in {
+			alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+			if !alnum || idx < keepStart || idx >= total-keepEnd {
+				b.WriteRune(r)
+			} else {
+				switch {
+				case unicode.IsDigit(r):
+					b.WriteByte('0')
+				case unicode.IsUpper(r):
+					b.WriteByte('X')
+				default:
+					b.WriteByte('x')
+				}
+			}
+
+			if alnum {
+				idx++
+			}
+		}
+
+		return b.String()
+	}
+}
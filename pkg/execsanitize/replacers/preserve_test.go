@@ -0,0 +1,24 @@
+package replacers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatPreserving(t *testing.T) {
+	tcs := []struct {
+		keepStart, keepEnd int
+		in, want           string
+	}{
+		{0, 0, "4111-1111-1111-1234", "0000-0000-0000-0000"},
+		{0, 4, "4111-1111-1111-1234", "0000-0000-0000-1234"},
+		{2, 0, "AB12 cd-34", "AB00 xx-00"},
+		{0, 0, "Jürgen.Müller@Corp.com", "Xxxxxx.Xxxxxx@Xxxx.xxx"},
+		{3, 3, "abc", "xxx"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.want, FormatPreserving(tc.keepStart, tc.keepEnd)(tc.in))
+	}
+}
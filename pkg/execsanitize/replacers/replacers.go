@@ -0,0 +1,2 @@
+// Package replacers provides ready-made ReplacerFuncs
+package replacers
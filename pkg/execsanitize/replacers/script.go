@@ -0,0 +1,98 @@
+package replacers
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// DefaultScriptSteps bounds the execution steps of each call of a Script's function if it has no MaxSteps, so that
+// a function stuck in a loop cannot stall the output
+const DefaultScriptSteps = 1000000
+
+// Script holds the functions of a Starlark script, which replace matches without recompiling the program that
+// sanitizes them. a function is called with the match and returns its replacement, e.g.
+//
+//	def replace(match):
+//	    mailbox, _, domain = match.partition("@")
+//	    return "*" * len(mailbox) + "@" + domain
+//
+// matches whose function fails are replaced with the fallback, never left as they are. the output of print is
+// discarded, as it could reveal matches. it is safe for concurrent use
+type Script struct {
+	// Name is the file name of the script, used in errors
+	Name string
+	// MaxSteps bounds the execution steps of each call, defaulting to DefaultScriptSteps
+	MaxSteps uint64
+	// Fallback replaces matches whose function fails, defaulting to DefaultExecFallback
+	Fallback string
+	// OnError optionally receives the errors of failed calls
+	OnError func(error)
+
+	globals starlark.StringDict
+}
+
+// LoadScript runs a Starlark script, read from filename if src is nil, and keeps the functions it defines
+func LoadScript(filename string, src interface{}) (*Script, error) {
+	thread := &starlark.Thread{Name: filename, Print: discardPrint}
+	globals, err := starlark.ExecFile(thread, filename, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+
+	return &Script{Name: filename, globals: globals}, nil
+}
+
+// Defines reports whether the script defines a function of the given name
+func (sc *Script) Defines(name string) bool {
+	_, ok := sc.globals[name].(starlark.Callable)
+	return ok
+}
+
+// Replacer returns a replacer that calls the script's function of the given name with each match
+func (sc *Script) Replacer(name string) (execsanitize.ReplacerFunc, error) {
+	fn, ok := sc.globals[name].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not define a function %s", sc.Name, name)
+	}
+
+	return func(in string) string {
+		out, err := sc.call(fn, in)
+		if err != nil {
+			if sc.OnError != nil {
+				sc.OnError(err)
+			}
+			if sc.Fallback != "" {
+				return sc.Fallback
+			}
+			return DefaultExecFallback
+		}
+
+		return out
+	}, nil
+}
+
+func (sc *Script) call(fn starlark.Callable, in string) (string, error) {
+	steps := sc.MaxSteps
+	if steps == 0 {
+		steps = DefaultScriptSteps
+	}
+	thread := &starlark.Thread{Name: sc.Name, Print: discardPrint}
+	thread.SetMaxExecutionSteps(steps)
+
+	v, err := starlark.Call(thread, fn, starlark.Tuple{starlark.String(in)}, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s: %w", sc.Name, fn.Name(), err)
+	}
+	out, ok := starlark.AsString(v)
+	if !ok {
+		return "", fmt.Errorf("%s: %s returned a %s, not a string", sc.Name, fn.Name(), v.Type())
+	}
+
+	return out, nil
+}
+
+func discardPrint(*starlark.Thread, string) {}
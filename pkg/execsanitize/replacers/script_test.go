@@ -0,0 +1,70 @@
+package replacers
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testScript = `
+def replace(match):
+    mailbox, _, domain = match.partition("@")
+    return "*" * len(mailbox) + "@" + domain
+
+def upper(match):
+    print(match)
+    return match.upper()
+
+def broken(match):
+    return len(match)
+
+def spin(match):
+    for i in range(100000000):
+        pass
+    return match
+`
+
+func TestScript(t *testing.T) {
+	sc, err := LoadScript("test.star", testScript)
+	require.NoError(t, err)
+	assert.True(t, sc.Defines("replace"))
+	assert.False(t, sc.Defines("missing"))
+
+	replace, err := sc.Replacer("replace")
+	require.NoError(t, err)
+	assert.Equal(t, "*****@example.com", replace("alice@example.com"))
+
+	upper, err := sc.Replacer("upper")
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "HUNTER2", upper("hunter2"))
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	sc.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	broken, err := sc.Replacer("broken")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultExecFallback, broken("hunter2"))
+	sc.MaxSteps, sc.Fallback = 1000, "<timeout>"
+	spin, err := sc.Replacer("spin")
+	require.NoError(t, err)
+	assert.Equal(t, "<timeout>", spin("hunter2"))
+	require.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], "test.star: broken returned a int, not a string")
+	assert.Contains(t, errs[1].Error(), "test.star: spin: ")
+
+	_, err = sc.Replacer("missing")
+	assert.EqualError(t, err, "test.star does not define a function missing")
+	_, err = LoadScript("bad.star", "def replace(match)\n")
+	assert.Error(t, err)
+}
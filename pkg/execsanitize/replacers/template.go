@@ -0,0 +1,81 @@
+package replacers
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+// TemplateData is what replacement templates are executed with
+type TemplateData struct {
+	// RuleName is the name of the rule that matched
+	RuleName string
+	// MatchIndex numbers the template's matches from 0
+	MatchIndex int64
+	// Stream is the name of the stream the match was found in, if known
+	Stream string
+	// Hash is the first DefaultHashLength hex characters of the match's hash, keyed as by Hash
+	Hash string
+	// Timestamp is the time of the match
+	Timestamp time.Time
+	// Attempt is the number of the attempt at running a command the match was found in, if the caller sets it
+	Attempt int
+}
+
+// TemplateReplacer replaces matches with a text/template executed with TemplateData, such as
+// "<{{.RuleName}}-{{.MatchIndex}}>". the matched text itself is not available to the template.
+// it is safe for concurrent use
+type TemplateReplacer struct {
+	tmpl *template.Template
+	hash execsanitize.ReplacerFunc
+	next int64
+}
+
+// NewTemplateReplacer parses a replacement template. key is used for .Hash as it is by Hash
+func NewTemplateReplacer(text string, key []byte) (*TemplateReplacer, error) {
+	tmpl, err := template.New("replacement").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// catch references to fields that do not exist before the first match
+	if err := tmpl.Execute(new(strings.Builder), TemplateData{}); err != nil {
+		return nil, err
+	}
+
+	return &TemplateReplacer{tmpl: tmpl, hash: Hash(key, DefaultHashLength)}, nil
+}
+
+// Data returns the data to execute the template with for a match, counting it as the template's next match
+func (t *TemplateReplacer) Data(m execsanitize.Match) TemplateData {
+	data := TemplateData{
+		MatchIndex: atomic.AddInt64(&t.next, 1) - 1,
+		Stream:     m.Stream,
+		Hash:       t.hash(m.Text),
+		Timestamp:  time.Now(),
+	}
+	if m.Rule != nil {
+		data.RuleName = m.Rule.Name
+	}
+
+	return data
+}
+
+// Execute executes the template with data. templates that fail are replaced with an error message rather
+// than the partial output
+func (t *TemplateReplacer) Execute(data TemplateData) string {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return fmt.Sprintf("<template error: %v>", err)
+	}
+
+	return b.String()
+}
+
+// Replace replaces a match, it is suitable as a MatchReplacerFunc
+func (t *TemplateReplacer) Replace(m execsanitize.Match) string {
+	return t.Execute(t.Data(m))
+}
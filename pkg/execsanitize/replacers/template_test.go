@@ -0,0 +1,43 @@
+package replacers
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamaln7/exec-sanitize/v2/pkg/execsanitize"
+)
+
+func TestTemplateReplacer(t *testing.T) {
+	tr, err := NewTemplateReplacer("<{{.RuleName}}-{{.MatchIndex}} {{.Stream}} {{.Hash}}>", nil)
+	require.NoError(t, err)
+
+	s := &execsanitize.Sanitizer{Rules: []*execsanitize.Rule{{
+		Name:          "password",
+		Pattern:       regexp.MustCompile(`hunter\d`),
+		MatchReplacer: tr.Replace,
+	}}}
+	var b bytes.Buffer
+	w := s.Writer(&b, execsanitize.WithStream("stdout"))
+	_, err = w.Write([]byte("hunter2 hunter3"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	// echo -n hunter2 | sha256sum
+	assert.Equal(t, "<password-0 stdout f52fbd32> <password-1 stdout fb8c2e2b>", b.String())
+
+	data := tr.Data(execsanitize.Match{Text: "hunter2"})
+	assert.Equal(t, int64(2), data.MatchIndex)
+	assert.Empty(t, data.RuleName)
+
+	ts, err := NewTemplateReplacer(`{{.Timestamp.Format "2006-01-02"}}`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, data.Timestamp.Format("2006-01-02"), ts.Execute(data))
+
+	_, err = NewTemplateReplacer("{{.Match}}", nil)
+	assert.Error(t, err)
+	_, err = NewTemplateReplacer("{{.RuleName", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package replacers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultTokenFormat is used by NewTokenizer if given an empty format
+const DefaultTokenFormat = "<SECRET-%d>"
+
+// Tokenizer replaces each distinct value with a stable numbered token such as <SECRET-7>, keeping a
+// token to value mapping so that authorized users can reverse the replacement later. it is safe for
+// concurrent use
+type Tokenizer struct {
+	format string
+
+	mu     sync.Mutex
+	tokens map[string]string
+	values map[string]string
+}
+
+// NewTokenizer creates a tokenizer whose tokens are formatted from a counter starting at 1 using format
+func NewTokenizer(format string) *Tokenizer {
+	if format == "" {
+		format = DefaultTokenFormat
+	}
+
+	return &Tokenizer{
+		format: format,
+		tokens: make(map[string]string),
+		values: make(map[string]string),
+	}
+}
+
+// Replace returns the token for a value, allocating a new one the first time the value is seen.
+// it is suitable as a ReplacerFunc
+func (t *Tokenizer) Replace(in string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if token, ok := t.tokens[in]; ok {
+		return token
+	}
+
+	token := fmt.Sprintf(t.format, len(t.tokens)+1)
+	t.tokens[in] = token
+	t.values[token] = in
+	return token
+}
+
+// Mapping returns a copy of the token to value mapping
+func (t *Tokenizer) Mapping() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := make(map[string]string, len(t.values))
+	for token, value := range t.values {
+		m[token] = value
+	}
+	return m
+}
+
+// WriteMapping writes the token to value mapping as a JSON object
+func (t *Tokenizer) WriteMapping(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t.Mapping())
+}
@@ -0,0 +1,28 @@
+package replacers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizer(t *testing.T) {
+	tok := NewTokenizer("")
+	assert.Equal(t, "<SECRET-1>", tok.Replace("hunter2"))
+	assert.Equal(t, "<SECRET-2>", tok.Replace("swordfish"))
+	assert.Equal(t, "<SECRET-1>", tok.Replace("hunter2"))
+
+	assert.Equal(t, map[string]string{
+		"<SECRET-1>": "hunter2",
+		"<SECRET-2>": "swordfish",
+	}, tok.Mapping())
+
+	var buf bytes.Buffer
+	require.NoError(t, tok.WriteMapping(&buf))
+	assert.JSONEq(t, `{"<SECRET-1>": "hunter2", "<SECRET-2>": "swordfish"}`, buf.String())
+
+	emails := NewTokenizer("user%d@example.com")
+	assert.Equal(t, "user1@example.com", emails.Replace("jane@corp.com"))
+}
@@ -0,0 +1,75 @@
+package execsanitize
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TestCase is an example input for a config's rules, along with what they are expected to do with it
+type TestCase struct {
+	Name  string `yaml:"name,omitempty"`
+	Input string `yaml:"input"`
+	// Output is the expected sanitized input, it is not checked if unset
+	Output *string `yaml:"output,omitempty"`
+	// Matches are the names of the rules expected to match the input, in any order. it is not checked if unset,
+	// an empty list expects no matches
+	Matches []string `yaml:"matches,omitempty"`
+}
+
+// RunTests compiles the config and runs each of its test cases against a new sanitizer with its rules,
+// returning an error for each test case that failed
+func (c *Config) RunTests() ([]error, error) {
+	rules, err := c.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []error
+	for i, tc := range c.Tests {
+		s := &Sanitizer{Rules: rules}
+		out, report := s.SanitizeWithReport(tc.Input)
+
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i)
+		}
+		if tc.Output != nil && out != *tc.Output {
+			failures = append(failures, fmt.Errorf("%s: expected output %q, got %q", name, *tc.Output, out))
+		}
+		if tc.Matches != nil {
+			want, got := ruleNames(tc.Matches), matchedRules(report.Matches)
+			if strings.Join(want, ",") != strings.Join(got, ",") {
+				failures = append(failures, fmt.Errorf("%s: expected matches of [%s], got [%s]",
+					name, strings.Join(want, ", "), strings.Join(got, ", ")))
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// matchedRules returns the sorted names of the rules of matches
+func matchedRules(matches []Match) []string {
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m.Rule.Name)
+	}
+
+	return ruleNames(names)
+}
+
+// ruleNames sorts and dedups rule names
+func ruleNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	deduped := sorted[:0]
+	for i, name := range sorted {
+		if i == 0 || name != sorted[i-1] {
+			deduped = append(deduped, name)
+		}
+	}
+
+	return deduped
+}
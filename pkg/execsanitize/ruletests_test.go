@@ -0,0 +1,49 @@
+package execsanitize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTests(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+rules:
+  - name: password
+    regex: 'password=\S+'
+    replace: 'password=<redacted>'
+  - name: token
+    glob: 'tok_*'
+    replace: '<token>'
+    max_replacements: 1
+tests:
+  - name: redacts passwords
+    input: 'login password=hunter2 tok_1'
+    output: 'login password=<redacted> <token>'
+    matches: [token, password]
+  - input: 'tok_2 tok_3'
+    output: '<token> tok_3'
+  - name: no matches
+    input: 'hello'
+    output: 'hello'
+    matches: []
+  - name: wrong output
+    input: 'password=a'
+    output: 'password=a'
+  - name: wrong matches
+    input: 'password=a'
+    matches: []
+`))
+	require.NoError(t, err)
+
+	failures, err := c.RunTests()
+	require.NoError(t, err)
+	require.Len(t, failures, 2)
+	assert.EqualError(t, failures[0], `wrong output: expected output "password=a", got "password=<redacted>"`)
+	assert.EqualError(t, failures[1], "wrong matches: expected matches of [], got [password]")
+
+	c.Rules[0].Regex = "("
+	_, err = c.RunTests()
+	assert.Error(t, err)
+}
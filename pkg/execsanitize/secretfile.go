@@ -0,0 +1,99 @@
+package execsanitize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SecretFile reads secret values to redact from a file. it implements SecretSource.
+// the file is either a JSON object, whose string fields are the secrets, or a .env file of KEY=value lines
+type SecretFile struct {
+	Path string
+}
+
+// Secrets reads the file, it is read again on every call
+func (f *SecretFile) Secrets(context.Context) (map[string]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets map[string]string
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		secrets, err = parseJSONSecrets(trimmed)
+	} else {
+		secrets, err = parseDotenv(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.Path, err)
+	}
+
+	return secrets, nil
+}
+
+// parseJSONSecrets parses a JSON object, skipping fields that are not strings
+func parseJSONSecrets(data []byte) (map[string]string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	secrets := make(map[string]string, len(fields))
+	for name, value := range fields {
+		if value, ok := value.(string); ok {
+			secrets[name] = value
+		}
+	}
+
+	return secrets, nil
+}
+
+// parseDotenv parses KEY=value lines. lines may start with export, values may be single or double quoted,
+// and blank lines and # comments are skipped
+func parseDotenv(data []byte) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value", n)
+			}
+			value, _ = strconv.Unquote(unquoted)
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: invalid quoted value", n)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		secrets[name] = value
+	}
+
+	return secrets, scanner.Err()
+}
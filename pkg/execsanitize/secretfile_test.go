@@ -0,0 +1,71 @@
+package execsanitize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretFile(t *testing.T) {
+	dir := t.TempDir()
+
+	tcs := []struct {
+		name, contents string
+		want           map[string]string
+		wantErr        string
+	}{
+		{
+			name: "dotenv",
+			contents: `# database
+DB_PASSWORD=hunter2
+export API_KEY = "abc\"def" # quoted
+TOKEN='a "b" c'
+URL=https://example.com/#frag # comment
+
+EMPTY=
+`,
+			want: map[string]string{
+				"DB_PASSWORD": "hunter2",
+				"API_KEY":     `abc"def`,
+				"TOKEN":       `a "b" c`,
+				"URL":         "https://example.com/#frag",
+				"EMPTY":       "",
+			},
+		},
+		{
+			name:     "json",
+			contents: ` {"DB_PASSWORD": "hunter2", "PORT": 5432, "NESTED": {"a": "b"}}`,
+			want:     map[string]string{"DB_PASSWORD": "hunter2"},
+		},
+		{
+			name:     "invalid dotenv",
+			contents: "DB_PASSWORD=hunter2\nnot a pair\n",
+			wantErr:  "line 2: expected KEY=value",
+		},
+		{
+			name:     "invalid json",
+			contents: `{"DB_PASSWORD": `,
+			wantErr:  "unexpected end of JSON input",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			require.NoError(t, os.WriteFile(path, []byte(tc.contents), 0600))
+
+			secrets, err := (&SecretFile{Path: path}).Secrets(context.Background())
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, secrets)
+		})
+	}
+}
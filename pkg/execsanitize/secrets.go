@@ -0,0 +1,61 @@
+package execsanitize
+
+import (
+	"context"
+	"regexp"
+	"sort"
+)
+
+// MinSecretLength is the length below which SecretRules skips values, since they would match all over the output
+const MinSecretLength = 4
+
+// SecretSource provides secret values to redact, such as those stored in a secrets manager
+type SecretSource interface {
+	// Secrets returns the current secret values by name
+	Secrets(ctx context.Context) (map[string]string, error)
+}
+
+// SecretRules returns plain-pattern rules that replace each secret value with its name in angle brackets.
+// rules are named prefix followed by the secret's name. longer values go first, so that a value containing
+// another one is replaced as a whole. values shorter than MinSecretLength are skipped
+func SecretRules(prefix string, secrets map[string]string) []*Rule {
+	return SecretRulesFunc(prefix, secrets, func(name string) string {
+		return "<" + name + ">"
+	})
+}
+
+// SecretRulesFunc is like SecretRules, but replaces each secret value with replacement(name)
+func SecretRulesFunc(prefix string, secrets map[string]string, replacement func(name string) string) []*Rule {
+	names := make([]string, 0, len(secrets))
+	for name, value := range secrets {
+		if len(value) >= MinSecretLength {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(secrets[names[i]]) != len(secrets[names[j]]) {
+			return len(secrets[names[i]]) > len(secrets[names[j]])
+		}
+		return names[i] < names[j]
+	})
+
+	var (
+		rules []*Rule
+		seen  = make(map[string]bool)
+	)
+	for _, name := range names {
+		value := secrets[name]
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+
+		rules = append(rules, &Rule{
+			Name:        prefix + name,
+			Pattern:     regexp.MustCompile(regexp.QuoteMeta(value)),
+			Replacement: replacement(name),
+		})
+	}
+
+	return rules
+}
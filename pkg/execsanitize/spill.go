@@ -0,0 +1,203 @@
+package execsanitize
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SpillAfter makes a line-buffered writer hold at most about n bytes of a line in memory. the rest of a longer line
+// is written to a temporary file in dir, or the default directory for temporary files if dir is empty, until the line
+// ends, so that a command printing a huge line cannot run the writer out of memory. the file is encrypted with a key
+// that is only kept in memory, and removed once the line is written out. as a line that does not fit in memory
+// cannot be matched as a whole, it is sanitized in pieces of about n bytes that end after whitespace where possible,
+// and only its last piece is passed to the LineFilter
+func SpillAfter(n int, dir string) WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.spillAfter, sw.spillDir = n, dir
+	}
+}
+
+// spillFile holds a part of a line in an encrypted temporary file, as records of a length followed by the
+// sealed bytes, each sealed with the next nonce
+type spillFile struct {
+	f     *os.File
+	w     *bufio.Writer
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, "exec-sanitize-spill-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spillFile{f: f, w: bufio.NewWriter(f), aead: aead}, nil
+}
+
+func (sf *spillFile) nextNonce() []byte {
+	nonce := make([]byte, sf.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], sf.nonce)
+	sf.nonce++
+	return nonce
+}
+
+// write encrypts b and appends it to the file
+func (sf *spillFile) write(b []byte) error {
+	sealed := sf.aead.Seal(nil, sf.nextNonce(), b, nil)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := sf.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := sf.w.Write(sealed)
+	return err
+}
+
+// read calls fn with each part of the file in the order it was written
+func (sf *spillFile) read(fn func([]byte) error) error {
+	if err := sf.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := sf.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(sf.f)
+	for nonce := uint64(0); nonce < sf.nonce; nonce++ {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return err
+		}
+		sealed := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, sealed); err != nil {
+			return err
+		}
+
+		n := make([]byte, sf.aead.NonceSize())
+		binary.BigEndian.PutUint64(n[len(n)-8:], nonce)
+		b, err := sf.aead.Open(sealed[:0], n, sealed, nil)
+		if err != nil {
+			return fmt.Errorf("reading spilled line: %w", err)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove closes and removes the file
+func (sf *spillFile) remove() error {
+	return errors.Join(sf.f.Close(), os.Remove(sf.f.Name()))
+}
+
+// spillHeld moves the held back part of a line to the spill file if it is over the writer's limit. the last bytes,
+// which a delimiter may start in, are kept in memory
+func (sw *SanitizerWriter) spillHeld() error {
+	keep := len(sw.s.delimiter()) - 1
+	if sw.spillAfter <= 0 || len(sw.buf) <= sw.spillAfter || len(sw.buf) <= keep {
+		return nil
+	}
+
+	if sw.spill == nil {
+		sf, err := newSpillFile(sw.spillDir)
+		if err != nil {
+			return fmt.Errorf("spilling long line: %w", err)
+		}
+		sw.spill = sf
+	}
+	// a part that could not be written is kept in memory, so that the line is only truncated if the file is
+	if err := sw.spill.write(sw.buf[:len(sw.buf)-keep]); err != nil {
+		return fmt.Errorf("spilling long line: %w", err)
+	}
+	sw.buf = append(sw.buf[:0], sw.buf[len(sw.buf)-keep:]...)
+
+	return nil
+}
+
+// continueSpilled adds written input to a spilled line. once the line ends, it is sanitized and written out, and
+// the input after it is returned
+func (sw *SanitizerWriter) continueSpilled(p []byte) (rest []byte, ended bool, err error) {
+	delim := []byte(sw.s.delimiter())
+	from := max(len(sw.buf)-len(delim)+1, 0)
+	sw.buf = append(sw.buf, p...)
+	i := bytes.Index(sw.buf[from:], delim)
+	if i < 0 {
+		return nil, false, sw.spillHeld()
+	}
+
+	i += from
+	rest = append(rest, sw.buf[i+len(delim):]...)
+	sw.buf = sw.buf[:i]
+
+	return rest, true, sw.finishSpilled(string(delim))
+}
+
+// finishSpilled sanitizes the spilled line, followed by the held back rest of it and eol, and writes it out piece
+// by piece
+func (sw *SanitizerWriter) finishSpilled(eol string) error {
+	sf := sw.spill
+	sw.spill = nil
+	tail := sw.buf
+	sw.buf = nil
+	// the pieces are all on the line the spilled line starts on
+	at := sw.lineAt(sw.countLines([]byte(eol)))
+
+	var piece []byte
+	sanitizePieces := func(b []byte) error {
+		piece = append(piece, b...)
+		for len(piece) > sw.spillAfter && !sw.s.Terminated() {
+			k := pieceEnd(piece[:sw.spillAfter])
+			if err := sw.write([]byte(sw.s.sanitize(string(piece[:k]), sw.passAt(at)))); err != nil {
+				return err
+			}
+			piece = append(piece[:0], piece[k:]...)
+		}
+		return nil
+	}
+
+	err := sf.read(sanitizePieces)
+	if err == nil {
+		err = sanitizePieces(tail)
+	}
+	if err == nil && !sw.s.Terminated() {
+		err = sw.write(sw.appendLine(nil, at, string(piece), eol))
+	}
+
+	return errors.Join(err, sf.remove())
+}
+
+// pieceEnd returns where to end a piece of a spilled line: after its last whitespace, or else before a trailing
+// incomplete UTF-8 sequence
+func pieceEnd(b []byte) int {
+	if i := bytes.LastIndexAny(b, " \t\r\n\f\v"); i >= 0 {
+		return i + 1
+	}
+	if k := len(b) - incompleteRuneLen(b); k > 0 {
+		return k
+	}
+	return len(b)
+}
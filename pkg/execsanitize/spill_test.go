@@ -0,0 +1,59 @@
+package execsanitize
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillAfter(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+	line := strings.Repeat("password hunter2 and some more text ", 300)
+
+	for _, withPipeline := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := []WriterOption{LineBuffered(), SpillAfter(100, dir)}
+		if withPipeline {
+			pl := NewPipeline(2)
+			defer pl.Close()
+			opts = append(opts, WithPipeline(pl))
+		}
+
+		var out bytes.Buffer
+		w := s.Writer(&out, opts...)
+		_, err := w.Write([]byte("first hunter2\n"))
+		require.NoError(t, err)
+		for off := 0; off < len(line); off += 1000 {
+			_, err := w.Write([]byte(line[off:min(off+1000, len(line))]))
+			require.NoError(t, err)
+		}
+
+		// the held back line is spilled to an encrypted file
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		spilled, err := os.ReadFile(dir + "/" + files[0].Name())
+		require.NoError(t, err)
+		assert.Greater(t, len(spilled), len(line)/2)
+		assert.NotContains(t, string(spilled), "password")
+
+		_, err = w.Write([]byte("\nok hunter2\nlast " + line))
+		require.NoError(t, err)
+		require.NoError(t, w.Flush())
+		assert.Equal(t, s.Sanitize("first hunter2\n"+line+"\nok hunter2\nlast "+line), out.String())
+
+		files, err = os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	}
+}
+
+func TestPieceEnd(t *testing.T) {
+	assert.Equal(t, 6, pieceEnd([]byte("hello world")))
+	assert.Equal(t, 5, pieceEnd([]byte("hello")))
+	assert.Equal(t, 1, pieceEnd([]byte("a\xe2\x82")))
+}
@@ -0,0 +1,86 @@
+package execsanitize
+
+import "sync"
+
+// Stats holds counters collected by a Sanitizer
+type Stats struct {
+	// BytesProcessed is the total size of all sanitized input
+	BytesProcessed int64
+	// BytesByStream breaks BytesProcessed down by the name of the stream the input was written to, see WithStream.
+	// input that was not written to a named stream is counted under ""
+	BytesByStream map[string]int64
+	// Rules holds per-rule statistics, in the same order as Sanitizer.Rules
+	Rules []RuleStats
+}
+
+// RuleStats holds counters for a single rule
+type RuleStats struct {
+	Rule    *Rule
+	Matches int64
+}
+
+type stats struct {
+	mu      sync.Mutex
+	bytes   int64
+	streams map[string]int64
+	matches map[*Rule]int64
+	// handled counts the matches of rules with a MaxReplacements limit, with runs counting once
+	handled map[*Rule]int
+}
+
+func (st *stats) addBytes(stream string, n int) {
+	st.mu.Lock()
+	st.bytes += int64(n)
+	if st.streams == nil {
+		st.streams = make(map[string]int64)
+	}
+	st.streams[stream] += int64(n)
+	st.mu.Unlock()
+}
+
+func (st *stats) addMatches(rule *Rule, n int) {
+	st.mu.Lock()
+	if st.matches == nil {
+		st.matches = make(map[*Rule]int64)
+	}
+	st.matches[rule] += int64(n)
+	st.mu.Unlock()
+}
+
+// reserve records up to n matches of a rule that is limited to max, returning how many of them may be handled
+func (st *stats) reserve(rule *Rule, n, max int) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.handled == nil {
+		st.handled = make(map[*Rule]int)
+	}
+	if left := max - st.handled[rule]; n > left {
+		n = left
+	}
+	st.handled[rule] += n
+
+	return n
+}
+
+// Stats returns a snapshot of the sanitizer's statistics
+func (s *Sanitizer) Stats() Stats {
+	rules := s.rules()
+	st := &s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	out := Stats{
+		BytesProcessed: st.bytes,
+		BytesByStream:  make(map[string]int64, len(st.streams)),
+		Rules:          make([]RuleStats, 0, len(rules)),
+	}
+	for stream, n := range st.streams {
+		out.BytesByStream[stream] = n
+	}
+	for _, rule := range rules {
+		out.Rules = append(out.Rules, RuleStats{Rule: rule, Matches: st.matches[rule]})
+	}
+
+	return out
+}
@@ -0,0 +1,37 @@
+package execsanitize
+
+import (
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	s := &Sanitizer{
+		Rules: makeRules(
+			regexp.MustCompile(`[aeiou]`), "*",
+			"never", "matches",
+		),
+	}
+
+	s.Sanitize("hello")
+	s.Sanitize("there")
+
+	st := s.Stats()
+	assert.EqualValues(t, 10, st.BytesProcessed)
+	require.Len(t, st.Rules, 2)
+	assert.Equal(t, s.Rules[0], st.Rules[0].Rule)
+	assert.EqualValues(t, 4, st.Rules[0].Matches)
+	assert.Equal(t, s.Rules[1], st.Rules[1].Rule)
+	assert.Zero(t, st.Rules[1].Matches)
+	assert.Equal(t, map[string]int64{"": 10}, st.BytesByStream)
+
+	_, err := s.Writer(io.Discard, WithStream("stderr")).Write([]byte("oops\n"))
+	require.NoError(t, err)
+	_, err = s.Writer(io.Discard, WithStream("stdout"), LineBuffered()).Write([]byte("nvr\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"": 10, "stderr": 5, "stdout": 4}, s.Stats().BytesByStream)
+}
@@ -0,0 +1,51 @@
+package execsanitize
+
+import (
+	"sync"
+	"time"
+)
+
+// Threshold watches the rate of a rule's matches, calling OnExceeded once Count of them were found within Window.
+// a handful of matches may be expected while thousands a minute usually mean that something is misconfigured.
+// it is safe for concurrent use
+type Threshold struct {
+	// Rule is the name of the rule whose matches are counted, or empty to count the matches of all rules
+	Rule   string
+	Count  int
+	Window time.Duration
+	// OnExceeded is called with the rule that matched last when the threshold is reached. the count then starts
+	// over, so it is called again if the rule keeps matching as often
+	OnExceeded func(rule *Rule)
+
+	mu sync.Mutex
+	// times holds the times of the matches within the window, oldest first
+	times []time.Time
+	now   func() time.Time
+}
+
+// Add counts a match. its signature makes it suitable as a Sanitizer's OnMatch hook
+func (t *Threshold) Add(m Match) {
+	if t.Rule != "" && (m.Rule == nil || m.Rule.Name != t.Rule) {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.now != nil {
+		now = t.now()
+	}
+	var expired int
+	for expired < len(t.times) && now.Sub(t.times[expired]) >= t.Window {
+		expired++
+	}
+	t.times = append(t.times[expired:], now)
+	exceeded := len(t.times) >= t.Count
+	if exceeded {
+		t.times = t.times[:0]
+	}
+	t.mu.Unlock()
+
+	if exceeded && t.OnExceeded != nil {
+		t.OnExceeded(m.Rule)
+	}
+}
@@ -0,0 +1,46 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreshold(t *testing.T) {
+	rules := makeRules(regexp.MustCompile(`tok-\d`), "<token>", "noise", "-")
+	rules[0].Name = "token"
+	rules[1].Name = "noise"
+
+	var (
+		clock    = time.Unix(0, 0).UTC()
+		exceeded []string
+	)
+	th := &Threshold{
+		Rule:   "token",
+		Count:  3,
+		Window: time.Minute,
+		OnExceeded: func(rule *Rule) {
+			exceeded = append(exceeded, clock.Format("15:04:05")+" "+rule.Name)
+		},
+		now: func() time.Time {
+			return clock
+		},
+	}
+	s := &Sanitizer{Rules: rules, OnMatch: th.Add}
+
+	s.Sanitize("tok-1 tok-2 noise noise noise")
+	assert.Empty(t, exceeded)
+
+	clock = clock.Add(time.Minute)
+	s.Sanitize("tok-3")
+	assert.Empty(t, exceeded, "matches outside the window are not counted")
+
+	clock = clock.Add(time.Second)
+	s.Sanitize("tok-4 tok-5")
+	assert.Equal(t, []string{"00:01:01 token"}, exceeded)
+
+	s.Sanitize("tok-6 tok-7 tok-8")
+	assert.Equal(t, []string{"00:01:01 token", "00:01:01 token"}, exceeded, "the count starts over")
+}
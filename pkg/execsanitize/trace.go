@@ -0,0 +1,35 @@
+package execsanitize
+
+import "time"
+
+// Trace describes how a piece of text was sanitized, see Sanitizer.OnTrace
+type Trace struct {
+	Stream        string
+	Input, Output string
+	// Rules lists the rules that were run over the text, in order. rules skipped because the text was
+	// dropped are left out
+	Rules []RuleTrace
+	// Duration is the time it took to sanitize the text
+	Duration time.Duration
+}
+
+// RuleTrace describes running a single rule over a piece of text
+type RuleTrace struct {
+	Rule *Rule
+	// Matches are the matches the rule handled
+	Matches  []Match
+	Duration time.Duration
+}
+
+// traceRule records running a rule that started at start, its matches being those collected after the first from
+func (p *pass) traceRule(rule *Rule, start time.Time, from int) {
+	if p.trace == nil {
+		return
+	}
+
+	p.trace.Rules = append(p.trace.Rules, RuleTrace{
+		Rule:     rule,
+		Matches:  p.matches[from:len(p.matches):len(p.matches)],
+		Duration: time.Since(start),
+	})
+}
@@ -0,0 +1,41 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnTrace(t *testing.T) {
+	for _, exclusive := range []bool{false, true} {
+		var traces []Trace
+		s := &Sanitizer{
+			Rules:     constantRules("hunter2", "***", "s3cre7", "<secret>", regexp.MustCompile(`^drop`), DiscardToken),
+			Exclusive: exclusive,
+			OnTrace: func(tr Trace) {
+				traces = append(traces, tr)
+			},
+		}
+
+		assert.Equal(t, "*** and ***", s.Sanitize("hunter2 and hunter2"))
+		assert.Equal(t, "nothing", s.Sanitize("nothing"))
+		require.Len(t, traces, 2)
+
+		tr := traces[0]
+		assert.Equal(t, "hunter2 and hunter2", tr.Input)
+		assert.Equal(t, "*** and ***", tr.Output)
+		require.Len(t, tr.Rules, 3)
+		assert.Equal(t, s.Rules[0], tr.Rules[0].Rule)
+		require.Len(t, tr.Rules[0].Matches, 2)
+		assert.Equal(t, "***", tr.Rules[0].Matches[1].Replacement)
+		assert.Empty(t, tr.Rules[1].Matches)
+		assert.Empty(t, tr.Rules[2].Matches)
+		assert.True(t, tr.Duration >= tr.Rules[0].Duration)
+
+		// text that no rule can match is traced as well
+		assert.Equal(t, "nothing", traces[1].Output)
+		assert.Len(t, traces[1].Rules, 3)
+	}
+}
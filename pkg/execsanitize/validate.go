@@ -0,0 +1,100 @@
+package execsanitize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validators are the validators that configs may refer to by name
+var validators = map[string]func(string) bool{
+	"luhn": ValidLuhn,
+	"iban": ValidIBAN,
+	"ssn":  ValidSSN,
+}
+
+// ParseValidator returns the validator with the given name: luhn, iban or ssn
+func ParseValidator(name string) (func(string) bool, error) {
+	v, ok := validators[name]
+	if !ok {
+		return nil, withKind(ErrUnknownValidator, fmt.Errorf("unknown validator %s", name))
+	}
+
+	return v, nil
+}
+
+// digits returns the digits of s, skipping spaces and dashes. it returns false if s contains other characters
+func digits(s string) ([]int, bool) {
+	ds := make([]int, 0, len(s))
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			ds = append(ds, int(c-'0'))
+		case c == ' ' || c == '-':
+		default:
+			return nil, false
+		}
+	}
+
+	return ds, true
+}
+
+// ValidLuhn reports whether s is a number of 12 to 19 digits, optionally separated by spaces or dashes,
+// with a valid Luhn check digit, as used by credit card numbers
+func ValidLuhn(s string) bool {
+	ds, ok := digits(s)
+	if !ok || len(ds) < 12 || len(ds) > 19 {
+		return false
+	}
+
+	var sum int
+	for i := range ds {
+		d := ds[len(ds)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return sum%10 == 0
+}
+
+// ValidIBAN reports whether s is an IBAN, optionally separated by spaces, with valid check digits
+func ValidIBAN(s string) bool {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+
+	// the country code and check digits are moved to the end and letters are converted to numbers,
+	// after which the number must be 1 mod 97
+	var mod int
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			mod = (mod*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			mod = (mod*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+
+	return mod == 1
+}
+
+// ValidSSN reports whether s is a US social security number, optionally separated by dashes or spaces,
+// that could have been issued: the area is not 000, 666 or 900-999, the group is not 00 and the serial not 0000
+func ValidSSN(s string) bool {
+	ds, ok := digits(s)
+	if !ok || len(ds) != 9 {
+		return false
+	}
+
+	area := ds[0]*100 + ds[1]*10 + ds[2]
+	group := ds[3]*10 + ds[4]
+	serial := ds[5]*1000 + ds[6]*100 + ds[7]*10 + ds[8]
+
+	return area != 0 && area != 666 && area < 900 && group != 0 && serial != 0
+}
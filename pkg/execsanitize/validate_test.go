@@ -0,0 +1,59 @@
+package execsanitize
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidators(t *testing.T) {
+	tcs := []struct {
+		validator func(string) bool
+		valid     []string
+		invalid   []string
+	}{
+		{
+			validator: ValidLuhn,
+			valid:     []string{"4111111111111111", "4111 1111 1111 1111", "5500-0000-0000-0004", "378282246310005"},
+			invalid:   []string{"4111111111111112", "1234", "4111x1111111111111", "41111111111111111111"},
+		},
+		{
+			validator: ValidIBAN,
+			valid:     []string{"GB82WEST12345698765432", "DE89 3704 0044 0532 0130 00", "de89370400440532013000"},
+			invalid:   []string{"GB82WEST12345698765433", "GB82", "GB82-WEST-1234-5698-7654-32"},
+		},
+		{
+			validator: ValidSSN,
+			valid:     []string{"123-45-6789", "123456789"},
+			invalid:   []string{"000-12-3456", "666-12-3456", "900-12-3456", "123-00-4567", "123-45-0000", "123-45-678"},
+		},
+	}
+
+	for _, tc := range tcs {
+		for _, s := range tc.valid {
+			assert.True(t, tc.validator(s), s)
+		}
+		for _, s := range tc.invalid {
+			assert.False(t, tc.validator(s), s)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var matches int
+	rules := makeRules(regexp.MustCompile(`\b\d{16}\b`), "<card>")
+	rules[0].Validate = ValidLuhn
+	s := &Sanitizer{
+		Rules: rules,
+		OnMatch: func(Match) {
+			matches++
+		},
+	}
+
+	assert.Equal(t, "card <card>, order 1234567812345678", s.Sanitize("card 4111111111111111, order 1234567812345678"))
+	assert.Equal(t, 1, matches)
+
+	s.Exclusive = true
+	assert.Equal(t, "<card> 1234567812345678", s.Sanitize("4111111111111111 1234567812345678"))
+}
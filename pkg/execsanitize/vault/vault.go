@@ -0,0 +1,97 @@
+// Package vault reads secret values to redact from HashiCorp Vault
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Source reads the fields of a KV secret from Vault. it implements execsanitize.SecretSource
+type Source struct {
+	// Addr is the address of the Vault server, defaulting to $VAULT_ADDR
+	Addr string
+	// Token authenticates with Vault, defaulting to $VAULT_TOKEN
+	Token string
+	// Namespace is the optional Vault Enterprise namespace, defaulting to $VAULT_NAMESPACE
+	Namespace string
+	// Path is the API path of the secret, e.g. secret/data/ci for the ci secret of a KV v2 engine mounted at secret
+	Path string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Secrets returns the string fields of the secret, fields of other types are skipped
+func (src *Source) Secrets(ctx context.Context) (map[string]string, error) {
+	addr := src.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("vault address is not set")
+	}
+	token := src.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	namespace := src.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	client := src.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(src.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("reading %s from vault: %s: %s", src.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding %s from vault: %w", src.Path, err)
+	}
+
+	fields := secret.Data
+	if _, ok := fields["metadata"]; ok {
+		// KV v2 engines wrap the fields with their metadata
+		var v2 map[string]json.RawMessage
+		if err := json.Unmarshal(fields["data"], &v2); err != nil {
+			return nil, fmt.Errorf("decoding %s from vault: %w", src.Path, err)
+		}
+		fields = v2
+	}
+
+	secrets := make(map[string]string, len(fields))
+	for name, raw := range fields {
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil {
+			secrets[name] = value
+		}
+	}
+
+	return secrets, nil
+}
@@ -0,0 +1,42 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/ci":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "hunter2", "port": 5432}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/ci":
+			_, _ = w.Write([]byte(`{"data": {"api_key": "abc123"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	secrets, err := (&Source{Addr: ts.URL, Token: "s.token", Path: "secret/data/ci"}).Secrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"password": "hunter2"}, secrets)
+
+	secrets, err = (&Source{Addr: ts.URL, Token: "s.token", Path: "/kv/ci"}).Secrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"api_key": "abc123"}, secrets)
+
+	_, err = (&Source{Addr: ts.URL, Token: "nope", Path: "kv/ci"}).Secrets(context.Background())
+	assert.EqualError(t, err, `reading kv/ci from vault: 403 Forbidden: {"errors":["permission denied"]}`)
+}
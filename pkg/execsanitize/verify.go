@@ -0,0 +1,178 @@
+package execsanitize
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultVerifyTimeout is used when a Sanitizer does not set VerifyTimeout
+const DefaultVerifyTimeout = 5 * time.Second
+
+// DefaultVerifyConcurrency is used when a Sanitizer does not set VerifyConcurrency
+const DefaultVerifyConcurrency = 4
+
+// VerifyFunc checks whether a matched value is live, e.g. by calling a token introspection endpoint.
+// It should honour ctx cancellation, but its result is dropped once the timeout passes regardless
+type VerifyFunc func(ctx context.Context, value string) (live bool, err error)
+
+// Verification is the outcome of verifying a match
+type Verification struct {
+	Match Match
+	Live  bool
+	Err   error
+	// Cached is true if the result was reused from an earlier verification of the same value
+	Cached bool
+}
+
+// maxVerifyCache bounds the number of verification results a sanitizer keeps, dropping the least recently used
+const maxVerifyCache = 4096
+
+type verifyKey struct {
+	rule *Rule
+	text string
+}
+
+type verifyEntry struct {
+	key  verifyKey
+	done chan struct{}
+	live bool
+	err  error
+}
+
+// verifier runs VerifyFuncs asynchronously on up to VerifyConcurrency workers, caching their results per rule and
+// value. results of verifications that timed out are not cached, so that the value is verified again next time
+type verifier struct {
+	mu    sync.Mutex
+	cache map[verifyKey]*list.Element
+	// lru orders the cached entries from the most recently used
+	lru *list.List
+	// queue holds the matches waiting for a worker, of which there are workers running
+	queue   []Match
+	workers int
+	wg      sync.WaitGroup
+}
+
+// verify queues an asynchronous verification of a match, whose result is reported to OnVerify
+func (s *Sanitizer) verify(m Match) {
+	v := &s.verifier
+	limit := s.VerifyConcurrency
+	if limit <= 0 {
+		limit = DefaultVerifyConcurrency
+	}
+
+	v.wg.Add(1)
+	v.mu.Lock()
+	v.queue = append(v.queue, m)
+	start := v.workers < limit
+	if start {
+		v.workers++
+	}
+	v.mu.Unlock()
+
+	if start {
+		go s.verifyWorker()
+	}
+}
+
+// verifyWorker verifies queued matches until there are none left
+func (s *Sanitizer) verifyWorker() {
+	v := &s.verifier
+	timeout := s.VerifyTimeout
+	if timeout <= 0 {
+		timeout = DefaultVerifyTimeout
+	}
+
+	for {
+		v.mu.Lock()
+		if len(v.queue) == 0 {
+			v.workers--
+			v.mu.Unlock()
+			return
+		}
+		m := v.queue[0]
+		v.queue[0] = Match{}
+		v.queue = v.queue[1:]
+		// entries are looked up once a worker picks up a match rather than when it is queued, so that a worker
+		// only ever waits for a verification that another worker is already running
+		e, cached := v.entry(verifyKey{rule: m.Rule, text: m.Text})
+		v.mu.Unlock()
+
+		if cached {
+			<-e.done
+		} else {
+			e.live, e.err = runVerify(m.Rule.Verify, m.Text, timeout)
+			close(e.done)
+			if errors.Is(e.err, context.DeadlineExceeded) || errors.Is(e.err, context.Canceled) {
+				v.forget(e)
+			}
+		}
+
+		if s.OnVerify != nil {
+			s.OnVerify(Verification{Match: m, Live: e.live, Err: e.err, Cached: cached})
+		}
+		v.wg.Done()
+	}
+}
+
+// entry returns the cached entry of key, or adds a new one, evicting the least recently used entry if the cache is
+// full. v.mu must be held
+func (v *verifier) entry(key verifyKey) (e *verifyEntry, cached bool) {
+	if v.cache == nil {
+		v.cache = make(map[verifyKey]*list.Element)
+		v.lru = list.New()
+	}
+	if el, ok := v.cache[key]; ok {
+		v.lru.MoveToFront(el)
+		return el.Value.(*verifyEntry), true
+	}
+
+	e = &verifyEntry{key: key, done: make(chan struct{})}
+	v.cache[key] = v.lru.PushFront(e)
+	if v.lru.Len() > maxVerifyCache {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.cache, oldest.Value.(*verifyEntry).key)
+	}
+
+	return e, false
+}
+
+// forget drops an entry from the cache, unless it was already evicted
+func (v *verifier) forget(e *verifyEntry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if el, ok := v.cache[e.key]; ok && el.Value == e {
+		v.lru.Remove(el)
+		delete(v.cache, e.key)
+	}
+}
+
+func runVerify(fn VerifyFunc, value string, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		live bool
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		live, err := fn(ctx, value)
+		ch <- result{live, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.live, r.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// WaitVerify blocks until all pending verifications have been reported
+func (s *Sanitizer) WaitVerify() {
+	s.verifier.wg.Wait()
+}
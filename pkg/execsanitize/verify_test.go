@@ -0,0 +1,128 @@
+package execsanitize
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	var calls int32
+	rules := makeRules(regexp.MustCompile(`tok-\w+`), "<token>")
+	rules[0].Verify = func(ctx context.Context, value string) (bool, error) {
+		atomic.AddInt32(&calls, 1)
+		if value == "tok-slow" {
+			<-time.After(time.Second)
+		}
+		return value == "tok-live", nil
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string][]Verification)
+	)
+	s := &Sanitizer{
+		Rules:         rules,
+		DetectOnly:    true,
+		VerifyTimeout: 50 * time.Millisecond,
+		OnVerify: func(v Verification) {
+			mu.Lock()
+			defer mu.Unlock()
+			results[v.Match.Text] = append(results[v.Match.Text], v)
+		},
+	}
+
+	in := "tok-live tok-dead tok-live tok-slow"
+	assert.Equal(t, in, s.Sanitize(in))
+	s.WaitVerify()
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+
+	require.Len(t, results["tok-live"], 2)
+	for _, v := range results["tok-live"] {
+		assert.True(t, v.Live)
+		assert.NoError(t, v.Err)
+	}
+	assert.True(t, results["tok-live"][0].Cached != results["tok-live"][1].Cached)
+
+	require.Len(t, results["tok-dead"], 1)
+	assert.False(t, results["tok-dead"][0].Live)
+
+	require.Len(t, results["tok-slow"], 1)
+	assert.Equal(t, context.DeadlineExceeded, results["tok-slow"][0].Err)
+}
+
+func TestVerifyBounds(t *testing.T) {
+	var running, maxRunning, calls int32
+	slow := int32(1)
+	rules := makeRules(regexp.MustCompile(`tok-\w+`), "<token>")
+	rules[0].Verify = func(ctx context.Context, value string) (bool, error) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		if value == "tok-slow" && atomic.LoadInt32(&slow) == 1 {
+			<-ctx.Done()
+			return false, ctx.Err()
+		}
+		time.Sleep(time.Millisecond)
+		return true, nil
+	}
+
+	var (
+		mu      sync.Mutex
+		results []Verification
+	)
+	s := &Sanitizer{
+		Rules:             rules,
+		DetectOnly:        true,
+		VerifyTimeout:     20 * time.Millisecond,
+		VerifyConcurrency: 2,
+		OnVerify: func(v Verification) {
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, v)
+		},
+	}
+
+	s.Sanitize("tok-slow tok-a tok-b tok-c tok-d tok-e")
+	s.WaitVerify()
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(2))
+	require.Len(t, results, 6)
+
+	// a verification that timed out is run again rather than reported from the cache
+	atomic.StoreInt32(&slow, 0)
+	results = nil
+	s.Sanitize("tok-slow tok-a")
+	s.WaitVerify()
+	require.Len(t, results, 2)
+	for _, v := range results {
+		assert.True(t, v.Live, v.Match.Text)
+		assert.Equal(t, v.Match.Text == "tok-a", v.Cached, v.Match.Text)
+	}
+
+	// the cache keeps the most recently used results
+	for i := 0; i < maxVerifyCache; i++ {
+		s.verifier.mu.Lock()
+		s.verifier.entry(verifyKey{rule: rules[0], text: fmt.Sprint("filler-", i)})
+		s.verifier.mu.Unlock()
+	}
+	assert.Equal(t, maxVerifyCache, s.verifier.lru.Len())
+	assert.Len(t, s.verifier.cache, maxVerifyCache)
+	atomic.StoreInt32(&calls, 0)
+	s.Sanitize("tok-a")
+	s.WaitVerify()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
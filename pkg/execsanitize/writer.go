@@ -0,0 +1,484 @@
+package execsanitize
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// outputBuffers holds the buffers writers assemble sanitized output in before writing it out
+var outputBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// maxPooledBuffer is the capacity above which buffers are not returned to the pool, so that a single large
+// write does not pin its buffer
+const maxPooledBuffer = 1 << 20
+
+// SanitizerWriter is a wrapping writer that sanitizes all input. unlike its Sanitizer, a writer is not safe for
+// concurrent use, streams written concurrently need a writer each
+type SanitizerWriter struct {
+	s      *Sanitizer
+	w      io.Writer
+	stream string
+
+	lines bool
+	buf   []byte
+	// regions tracks rules' regions across writes
+	regions map[*Rule]bool
+
+	bestEffort bool
+	// err is the last error of the underlying writer
+	err   error
+	errMu sync.Mutex
+
+	normalize bool
+	// pendingCR is set if the last write ended with a CR, which may be followed by a LF
+	pendingCR bool
+
+	// mu serializes writes with flushes by the flushAfter timer
+	mu         sync.Mutex
+	flushAfter time.Duration
+	timer      *time.Timer
+	// flushDue is when the flushAfter timer fires, unless it is postponed by a write
+	flushDue         time.Time
+	ignoreKeepalives bool
+
+	// linesSeen counts the delimiters of the input handed to be sanitized, for Match.Line
+	linesSeen int
+
+	pipeline *Pipeline
+	// seq numbers the jobs handed to the pipeline, and next is the job whose output is written next
+	seq, next uint64
+	// done holds the jobs whose output waits for that of earlier ones to be written
+	done    map[uint64]*pipelineJob
+	seqMu   sync.Mutex
+	pending sync.WaitGroup
+	// slots limits the number of jobs whose output is yet to be written
+	slots chan struct{}
+
+	// spillAfter is the size above which a held back line is moved to spill
+	spillAfter int
+	spillDir   string
+	spill      *spillFile
+}
+
+// WriterOption configures a SanitizerWriter
+type WriterOption func(*SanitizerWriter)
+
+// WithStream names the stream a writer sanitizes, which is reported in matches
+func WithStream(name string) WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.stream = name
+	}
+}
+
+// LineBuffered makes a writer hold back input until a full line is available and sanitize each line on
+// its own, so that matches are not split across writes. lines end with the sanitizer's Delimiter.
+// the writer must be flushed to emit a trailing partial line
+func LineBuffered() WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.lines = true
+	}
+}
+
+// BestEffort makes a writer drop output that the underlying writer fails to write instead of returning the error,
+// so that a broken destination does not stop whatever writes to it. the last error is available from Err.
+// by default, a writer returns the underlying writer's errors and fails all later writes with the same error
+func BestEffort() WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.bestEffort = true
+	}
+}
+
+// NormalizeLineEndings makes a writer rewrite CRLF and lone CR line endings to LF before sanitizing its input.
+// lone CRs, such as those of progress bars, then end lines as well
+func NormalizeLineEndings() WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.normalize = true
+	}
+}
+
+// FlushAfter makes a writer sanitize and write out held back input, such as a prompt without a trailing newline,
+// once nothing has been written to it for d. the rest of a line flushed early is sanitized as a line of its own,
+// so a short d may split matches. with FlushAfter, Write and Flush may be called from different goroutines
+func FlushAfter(d time.Duration) WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.flushAfter = d
+	}
+}
+
+// IgnoreKeepalives makes writes that hold nothing but NUL bytes and ANSI escape sequences, such as the keepalives
+// and terminal redraws of remote sessions, not postpone the flush of FlushAfter, so that a prompt followed by them
+// is still written out
+func IgnoreKeepalives() WriterOption {
+	return func(sw *SanitizerWriter) {
+		sw.ignoreKeepalives = true
+	}
+}
+
+// Writer wraps a writer with a sanitizer. a multibyte UTF-8 character split across writes is held back
+// until it is complete, so the writer should be flushed once all input has been written. while the sanitizer has no
+// rules, input is passed through as is without being held back, so that wrapping output costs next to nothing
+func (s *Sanitizer) Writer(w io.Writer, opts ...WriterOption) *SanitizerWriter {
+	sw := &SanitizerWriter{s: s, w: w, regions: make(map[*Rule]bool)}
+	for _, opt := range opts {
+		opt(sw)
+	}
+
+	return sw
+}
+
+// Write sanitizes bytes and passes them through to the underlying writer. as the sanitized output of p differs
+// from p, none of p is reported as written if the underlying writer fails, even if some of its output was written
+func (sw *SanitizerWriter) Write(p []byte) (n int, err error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	defer sw.scheduleFlush(p)
+
+	if sw.s.Terminated() {
+		return 0, ErrTerminated
+	}
+	if err := sw.Err(); err != nil && !sw.bestEffort {
+		return 0, err
+	}
+
+	if sw.pipeline != nil {
+		if sw.parallel() {
+			return sw.writeParallel(p)
+		}
+		// input written as it is sanitized must follow the output of earlier writes
+		sw.pending.Wait()
+	}
+	if len(sw.buf) == 0 && sw.spill == nil && !sw.normalize && sw.s.LineFilter == nil && sw.s.bypass() {
+		// without rules, input is written out as is, without buffering partial lines
+		sw.s.stats.addBytes(sw.stream, len(p))
+		sw.lineAt(sw.countLines(p))
+		return sw.written(p, sw.write(p))
+	}
+
+	written := p
+	p = sw.normalizeLineEndings(p)
+	if !sw.lineBuffered() {
+		// hold back a trailing incomplete UTF-8 sequence until the rest of it is written
+		data := p
+		if len(sw.buf) > 0 {
+			data = append(sw.buf, p...)
+		}
+		k := len(data) - incompleteRuneLen(data)
+		at := sw.lineAt(sw.countLines(data[:k]))
+		if sw.s.passThrough(data[:k], sw.passAt(at)) {
+			err = sw.write(data[:k])
+			sw.buf = append(sw.buf[:0], data[k:]...)
+			return sw.written(written, err)
+		}
+
+		out := outputBuffers.Get().(*[]byte)
+		*out = append(*out, sw.s.sanitize(string(data[:k]), sw.passAt(at))...)
+		sw.buf = append(sw.buf[:0], data[k:]...)
+
+		return sw.written(written, sw.writeOut(out))
+	}
+
+	if sw.spill != nil {
+		rest, ended, err := sw.continueSpilled(p)
+		if err != nil || !ended {
+			return sw.written(written, err)
+		}
+		p = rest
+	}
+
+	var (
+		delim = []byte(sw.s.delimiter())
+		out   = outputBuffers.Get().(*[]byte)
+	)
+	if sw.s.LineFilter == nil && string(delim) == "\n" {
+		// complete lines that cannot be altered are written out as is, once a line held back from an earlier write
+		// is completed and sanitized. the prefilter's anchors only match at newlines, so lines ending otherwise,
+		// such as with a CRLF, are sanitized one by one
+		start := 0
+		if len(sw.buf) > 0 {
+			if i := bytes.IndexByte(p, '\n'); i >= 0 {
+				sw.buf = append(sw.buf, p[:i]...)
+				*out = sw.appendLine(*out, sw.lineAt(1), string(sw.buf), "\n")
+				sw.buf, start = sw.buf[:0], i+1
+				if sw.s.Terminated() {
+					return sw.written(written, sw.writeOut(out))
+				}
+			}
+		}
+
+		end := bytes.LastIndexByte(p, '\n') + 1
+		if len(sw.buf) == 0 && end > start && bytes.IndexByte(p[start:end], '\r') < 0 && sw.s.passThrough(p[start:end], sw.passAt(sw.linesSeen+1)) {
+			sw.lineAt(bytes.Count(p[start:end], delim))
+			err = sw.writeOut(out)
+			if err == nil {
+				err = sw.write(p[start:end])
+			}
+			sw.buf = append(sw.buf, p[end:]...)
+			if err == nil {
+				err = sw.spillHeld()
+			}
+			return sw.written(written, err)
+		}
+		p = p[start:]
+	}
+
+	// only the written bytes, along with the end of the held back ones a delimiter may start in, are looked for
+	// delimiters, so that a long line written in many pieces is not searched over and over
+	from := max(len(sw.buf)-len(delim)+1, 0)
+	sw.buf = append(sw.buf, p...)
+	rest := sw.buf
+	for {
+		i := bytes.Index(rest[from:], delim)
+		if i < 0 {
+			break
+		}
+
+		i += from
+		*out = sw.appendLine(*out, sw.lineAt(1), string(rest[:i]), string(delim))
+		rest, from = rest[i+len(delim):], 0
+		if sw.s.Terminated() {
+			rest = nil
+			break
+		}
+	}
+	if len(rest) < len(sw.buf) {
+		// a line that is still being written is only copied once it is followed by another line
+		sw.buf = append(sw.buf[:0], rest...)
+	}
+
+	err = sw.writeOut(out)
+	if err == nil {
+		err = sw.spillHeld()
+	}
+	return sw.written(written, err)
+}
+
+// normalizeLineEndings rewrites the CRLF and CR line endings of p to LF if the writer normalizes them.
+// a CR at the end of p is held back until the next write or flush, as it may be the first half of a CRLF
+func (sw *SanitizerWriter) normalizeLineEndings(p []byte) []byte {
+	if !sw.normalize || len(p) == 0 || (!sw.pendingCR && bytes.IndexByte(p, '\r') < 0) {
+		return p
+	}
+
+	out := make([]byte, 0, len(p)+1)
+	if sw.pendingCR {
+		out = append(out, '\n')
+		if p[0] == '\n' {
+			p = p[1:]
+		}
+		sw.pendingCR = false
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] != '\r' {
+			out = append(out, p[i])
+			continue
+		}
+		if i == len(p)-1 {
+			sw.pendingCR = true
+			break
+		}
+		out = append(out, '\n')
+		if p[i+1] == '\n' {
+			i++
+		}
+	}
+
+	return out
+}
+
+// written returns the result of writing p given the error of writing its output
+func (sw *SanitizerWriter) written(p []byte, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	if sw.s.Terminated() {
+		return len(p), ErrTerminated
+	}
+
+	return len(p), nil
+}
+
+// appendLine sanitizes a single line and runs it through the LineFilter, appending it to dst unless it is dropped.
+// the CR of a CRLF line ending is kept out of the line, so that $ matches before it as it does before a LF
+func (sw *SanitizerWriter) appendLine(dst []byte, at int, line, eol string) []byte {
+	if eol == "\n" && strings.HasSuffix(line, "\r") {
+		line, eol = line[:len(line)-1], "\r\n"
+	}
+
+	p := sw.passAt(at)
+	p.collect = sw.s.LineFilter != nil
+	clean := sw.s.sanitize(line, p)
+	if p.discard {
+		return dst
+	}
+
+	if sw.s.LineFilter != nil {
+		var keep bool
+		clean, keep = sw.s.LineFilter(clean, p.matches)
+		if !keep {
+			sw.s.emit(EventDiscard, sw.stream, func(e *Event) {
+				e.Bytes = len(line)
+			})
+			return dst
+		}
+	}
+
+	return append(append(dst, clean...), eol...)
+}
+
+// writeOut writes the output assembled in a pooled buffer, if any, and returns the buffer to the pool
+func (sw *SanitizerWriter) writeOut(out *[]byte) error {
+	err := sw.write(*out)
+	if cap(*out) <= maxPooledBuffer {
+		*out = (*out)[:0]
+		outputBuffers.Put(out)
+	}
+
+	return err
+}
+
+// write writes b to the underlying writer in full, retrying short writes. errors are only returned by strict writers
+func (sw *SanitizerWriter) write(b []byte) error {
+	for len(b) > 0 {
+		n, err := sw.w.Write(b)
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			sw.errMu.Lock()
+			sw.err = err
+			sw.errMu.Unlock()
+			if sw.bestEffort {
+				return nil
+			}
+			return err
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+// Err returns the last error of the underlying writer, which best effort writers do not return from Write
+func (sw *SanitizerWriter) Err() error {
+	sw.errMu.Lock()
+	defer sw.errMu.Unlock()
+
+	return sw.err
+}
+
+// passAt starts sanitizing a piece of the writer's input, which starts on the given line
+func (sw *SanitizerWriter) passAt(line int) *pass {
+	return &pass{stream: sw.stream, regions: sw.regions, line: line}
+}
+
+// lineAt returns the line the next piece of input starts on, and counts the given number of lines of it as seen
+func (sw *SanitizerWriter) lineAt(lines int) int {
+	at := sw.linesSeen + 1
+	sw.linesSeen += lines
+	return at
+}
+
+// countLines counts the delimiters in a piece of input
+func (sw *SanitizerWriter) countLines(b []byte) int {
+	return bytes.Count(b, []byte(sw.s.delimiter()))
+}
+
+// incompleteRuneLen returns the length of a truncated UTF-8 sequence at the end of b
+func incompleteRuneLen(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return 0
+			}
+			return len(b) - i
+		}
+	}
+
+	return 0
+}
+
+// scheduleFlush starts or restarts the flushAfter timer after p was written, if input is held back
+func (sw *SanitizerWriter) scheduleFlush(p []byte) {
+	if sw.flushAfter <= 0 || len(sw.buf) == 0 {
+		return
+	}
+	if sw.ignoreKeepalives && time.Now().Before(sw.flushDue) && onlyEscapes(p) {
+		return
+	}
+	sw.flushDue = time.Now().Add(sw.flushAfter)
+	if sw.timer == nil {
+		sw.timer = time.AfterFunc(sw.flushAfter, func() {
+			_ = sw.Flush()
+		})
+		return
+	}
+	sw.timer.Reset(sw.flushAfter)
+}
+
+// Flush sanitizes and writes out any buffered partial line or UTF-8 sequence
+func (sw *SanitizerWriter) Flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.flush()
+}
+
+func (sw *SanitizerWriter) flush() error {
+	if err := sw.Err(); err != nil && !sw.bestEffort {
+		return err
+	}
+	held := len(sw.buf)
+	defer sw.s.emit(EventFlush, sw.stream, func(e *Event) {
+		e.Bytes = held
+	})
+	// a held back CR ends the last line
+	var eol string
+	if sw.pendingCR {
+		eol, sw.pendingCR = "\n", false
+	}
+	if sw.spill != nil {
+		sw.pending.Wait()
+		return sw.finishSpilled(eol)
+	}
+	if sw.pipeline != nil {
+		return sw.flushParallel(eol)
+	}
+	if len(sw.buf) == 0 && eol == "" {
+		return nil
+	}
+
+	out := outputBuffers.Get().(*[]byte)
+	*out = sw.appendHeld(*out, sw.lineAt(sw.countLines([]byte(eol))), sw.buf, eol)
+	sw.buf = sw.buf[:0]
+
+	return sw.writeOut(out)
+}
+
+// appendHeld sanitizes the input held back until the writer is flushed, appending it to dst followed by eol
+func (sw *SanitizerWriter) appendHeld(dst []byte, at int, held []byte, eol string) []byte {
+	if sw.lineBuffered() {
+		return sw.appendLine(dst, at, string(held), eol)
+	}
+
+	return append(append(dst, sw.s.sanitize(string(held), sw.passAt(at))...), eol...)
+}
+
+// lineBuffered reports whether the writer sanitizes its input line by line: if it is LineBuffered, and while a rule
+// collapses runs of matches, as a run is only known in full once its line is
+func (sw *SanitizerWriter) lineBuffered() bool {
+	return sw.lines || sw.s.collapsesRuns()
+}
+
+// Close flushes the writer. it does not close the underlying writer
+func (sw *SanitizerWriter) Close() error {
+	return sw.Flush()
+}
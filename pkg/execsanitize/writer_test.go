@@ -0,0 +1,398 @@
+package execsanitize
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineBuffered(t *testing.T) {
+	s := &Sanitizer{
+		Rules: makeRules(
+			regexp.MustCompile(`^secret \w+$`), "<redacted>",
+			"drop", DiscardToken,
+		),
+	}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered())
+
+	for _, chunk := range []string{"sec", "ret val\nkeep me\nplease drop", " me\nsecret", " tail"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "<redacted>\nkeep me\n", buf.String())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "<redacted>\nkeep me\n<redacted>", buf.String())
+}
+
+func TestDelimiter(t *testing.T) {
+	s := &Sanitizer{
+		Rules: makeRules(
+			regexp.MustCompile(`^secret \w+$`), "<redacted>",
+			"drop", DiscardToken,
+		),
+		Delimiter: "\x00",
+	}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered())
+	for _, chunk := range []string{"./a\nb\x00sec", "ret val\x00please drop\nme\x00keep", " me"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "./a\nb\x00<redacted>\x00keep me", buf.String())
+
+	s = &Sanitizer{Rules: makeRules("tok", "<t>"), Delimiter: "\r\n"}
+	s.Rules[0].FirstPerLine = true
+	assert.Equal(t, "<t> tok\ntok\r\n<t>\r\n", s.Sanitize("tok tok\ntok\r\ntok\r\n"))
+}
+
+func TestFlushAfter(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "<password>")}
+
+	writes := make(chan string, 10)
+	w := s.Writer(writerFunc(func(p []byte) (int, error) {
+		writes <- string(p)
+		return len(p), nil
+	}), LineBuffered(), FlushAfter(20*time.Millisecond))
+
+	_, err := w.Write([]byte("user: admin\nPass"))
+	require.NoError(t, err)
+	assert.Equal(t, "user: admin\n", <-writes)
+	_, err = w.Write([]byte("word: "))
+	require.NoError(t, err)
+
+	select {
+	case out := <-writes:
+		assert.Equal(t, "Password: ", out)
+	case <-time.After(time.Second):
+		t.Fatal("the partial line was not flushed")
+	}
+
+	_, err = w.Write([]byte("hunter2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "<password>\n", <-writes)
+	require.NoError(t, w.Close())
+}
+
+func TestIgnoreKeepalives(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "<password>")}
+
+	var (
+		mu      sync.Mutex
+		flushed time.Time
+		out     string
+	)
+	w := s.Writer(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if out == "" {
+			flushed = time.Now()
+		}
+		out += string(p)
+		return len(p), nil
+	}), LineBuffered(), FlushAfter(100*time.Millisecond), IgnoreKeepalives())
+
+	start := time.Now()
+	_, err := w.Write([]byte("Password: "))
+	require.NoError(t, err)
+	// keepalives written more often than the flush interval do not hold the prompt back
+	for i := 0; i < 10; i++ {
+		_, err = w.Write([]byte("\x00\x1b]0;host\x07"))
+		require.NoError(t, err)
+		time.Sleep(30 * time.Millisecond)
+	}
+	require.NoError(t, w.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "Password: "+strings.Repeat("\x00\x1b]0;host\x07", 10), out)
+	assert.True(t, flushed.Sub(start) < 250*time.Millisecond, "flushed after %s", flushed.Sub(start))
+
+	assert.True(t, onlyEscapes([]byte("\x1b[0m\x00")))
+	assert.False(t, onlyEscapes([]byte("\x1b[0mx")))
+}
+
+// writerFunc is an io.Writer implemented by a function
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestLineEndings(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules(regexp.MustCompile(`secret \w+$`), "<redacted>")}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered())
+	_, err := w.Write([]byte("secret one\r\nsecret two\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "<redacted>\r\n<redacted>\n", buf.String(), "line endings are preserved")
+
+	buf.Reset()
+	w = s.Writer(&buf, LineBuffered(), NormalizeLineEndings())
+	for _, chunk := range []string{"secret one\r", "\nprogress 50%\rprogress 100%\r", "secret two\r"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "<redacted>\nprogress 50%\nprogress 100%\n<redacted>\n", buf.String())
+}
+
+func TestLineFilter(t *testing.T) {
+	longDigits := regexp.MustCompile(`\d{20,}`)
+	var seen [][]Match
+	s := &Sanitizer{
+		Rules: makeRules("token", "<token>"),
+		LineFilter: func(line string, matches []Match) (string, bool) {
+			seen = append(seen, matches)
+			if longDigits.MatchString(line) {
+				return "", false
+			}
+			if len(matches) > 0 {
+				return line + " (redacted)", true
+			}
+
+			return line, true
+		},
+	}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf, LineBuffered(), WithStream("stderr"))
+	_, err := w.Write([]byte("a token here\n123456789012345678901234\nplain\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "a <token> here (redacted)\nplain\n", buf.String())
+	require.Len(t, seen, 3)
+	require.Len(t, seen[0], 1)
+	assert.Equal(t, Match{Rule: s.Rules[0], Text: "token", Replacement: "<token>", Start: 2, End: 7, Stream: "stderr", Line: 1}, seen[0][0])
+	assert.Empty(t, seen[1])
+}
+
+func TestMatchLine(t *testing.T) {
+	pl := NewPipeline(2)
+	defer pl.Close()
+
+	in := "a\nb hunter2\nc\r\n\nhunter2 x hunter2\nend hunter2"
+	for _, tc := range []struct {
+		name string
+		opts []WriterOption
+		size int
+	}{
+		{name: "unbuffered", size: len(in)},
+		{name: "line-buffered", opts: []WriterOption{LineBuffered()}, size: 5},
+		{name: "whole", opts: []WriterOption{LineBuffered()}, size: len(in)},
+		{name: "pipeline", opts: []WriterOption{LineBuffered(), WithPipeline(pl)}, size: 4},
+		{name: "spill", opts: []WriterOption{LineBuffered(), SpillAfter(8, t.TempDir())}, size: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				lines []int
+			)
+			s := &Sanitizer{
+				Rules: makeRules("hunter2", "***"),
+				OnMatch: func(m Match) {
+					mu.Lock()
+					defer mu.Unlock()
+					lines = append(lines, m.Line)
+				},
+			}
+
+			var buf bytes.Buffer
+			w := s.Writer(&buf, tc.opts...)
+			for off := 0; off < len(in); off += tc.size {
+				_, err := w.Write([]byte(in[off:min(off+tc.size, len(in))]))
+				require.NoError(t, err)
+			}
+			require.NoError(t, w.Flush())
+
+			assert.Equal(t, strings.ReplaceAll(in, "hunter2", "***"), buf.String())
+			assert.ElementsMatch(t, []int{2, 5, 5, 6}, lines)
+		})
+	}
+
+	// matches outside of writers have no line
+	var line = -1
+	s := &Sanitizer{Rules: makeRules("hunter2", "***"), OnMatch: func(m Match) { line = m.Line }}
+	s.Sanitize("a\nhunter2")
+	assert.Zero(t, line)
+}
+
+func TestWriterUTF8Boundaries(t *testing.T) {
+	s := &Sanitizer{
+		Rules: makeRules("é", "e"),
+	}
+
+	var buf bytes.Buffer
+	w := s.Writer(&buf)
+	for _, chunk := range []string{"caf\xc3", "\xa9 \xe2\x82", "\xac\xff", "\xf0\x9f"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "cafe €\xff", buf.String())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "cafe €\xff\xf0\x9f", buf.String())
+}
+
+func TestWriterPassthroughIsByteIdentical(t *testing.T) {
+	s := &Sanitizer{
+		Rules: makeRules("nomatch", "-"),
+	}
+
+	in := []byte("日本語のテキスト, emoji 🌶 and invalid \xff\xfe bytes")
+	var buf bytes.Buffer
+	w := s.Writer(&buf)
+	for i := 0; i < len(in); i += 5 {
+		end := i + 5
+		if end > len(in) {
+			end = len(in)
+		}
+		_, err := w.Write(in[i:end])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, in, buf.Bytes())
+}
+
+func TestWriterWithoutRules(t *testing.T) {
+	s := &Sanitizer{}
+
+	var writes []string
+	w := s.Writer(writerFunc(func(p []byte) (int, error) {
+		writes = append(writes, string(p))
+		return len(p), nil
+	}), LineBuffered(), WithStream("stdout"))
+	for _, in := range []string{"a partial", " line\n", "\xf0\x9f"} {
+		_, err := w.Write([]byte(in))
+		require.NoError(t, err)
+	}
+	// nothing is held back
+	assert.Equal(t, []string{"a partial", " line\n", "\xf0\x9f"}, writes)
+	assert.Equal(t, int64(17), s.Stats().BytesProcessed)
+
+	// once there are rules, writes are sanitized again
+	s.SetRules(makeRules("secret", "<s>"))
+	_, err := w.Write([]byte("\x8c\xb6 secret\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "\xf0\x9f\x8c\xb6 <s>\n", strings.Join(writes[2:], ""))
+
+	assert.Equal(t, []byte("as is"), (&Sanitizer{}).SanitizeBytes([]byte("as is")))
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		matches = make(map[string]int)
+		s       = &Sanitizer{
+			Rules: makeRules(regexp.MustCompile(`token=\w+`), "token=<redacted>", "hunter2", "***"),
+			OnMatch: func(m Match) {
+				mu.Lock()
+				matches[m.Stream]++
+				mu.Unlock()
+			},
+		}
+		outs [2]bytes.Buffer
+		wg   sync.WaitGroup
+	)
+	for i, stream := range []string{"stdout", "stderr"} {
+		w := s.Writer(&outs[i], WithStream(stream), LineBuffered())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := w.Write([]byte("token=abc hunter2\n"))
+				assert.NoError(t, err)
+				if j == 50 {
+					s.SetRules(s.CurrentRules())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range outs {
+		assert.Equal(t, strings.Repeat("token=<redacted> ***\n", 100), outs[i].String())
+	}
+	assert.Equal(t, map[string]int{"stdout": 200, "stderr": 200}, matches)
+	assert.Equal(t, int64(400), s.Stats().Rules[0].Matches+s.Stats().Rules[1].Matches)
+}
+
+// flakyWriter writes at most max bytes per call and fails once its budget is used up
+type flakyWriter struct {
+	bytes.Buffer
+	max, budget int
+}
+
+var errBroken = errors.New("broken pipe")
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.budget <= 0 {
+		return 0, errBroken
+	}
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	if len(p) > w.budget {
+		p = p[:w.budget]
+	}
+	w.budget -= len(p)
+	return w.Buffer.Write(p)
+}
+
+func TestWriterErrors(t *testing.T) {
+	s := &Sanitizer{Rules: makeRules("hunter2", "***")}
+
+	for _, opts := range [][]WriterOption{nil, {LineBuffered()}} {
+		// short writes are retried
+		fw := &flakyWriter{max: 3, budget: 100}
+		w := s.Writer(fw, opts...)
+		n, err := w.Write([]byte("password hunter2\nok\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 20, n)
+		assert.Equal(t, "password ***\nok\n", fw.String())
+
+		// errors are returned and sticky
+		fw = &flakyWriter{max: 3, budget: 5}
+		w = s.Writer(fw, opts...)
+		n, err = w.Write([]byte("password hunter2\n"))
+		assert.Equal(t, errBroken, err)
+		assert.Zero(t, n)
+		fw.budget = 100
+		_, err = w.Write([]byte("ok\n"))
+		assert.Equal(t, errBroken, err)
+		assert.Equal(t, errBroken, w.Flush())
+		assert.Equal(t, "passw", fw.String())
+
+		// best effort writers drop what cannot be written
+		fw = &flakyWriter{max: 3, budget: 5}
+		w = s.Writer(fw, append(opts, BestEffort())...)
+		n, err = w.Write([]byte("password hunter2\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 17, n)
+		assert.Equal(t, errBroken, w.Err())
+		fw.budget = 100
+		_, err = w.Write([]byte("ok\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "passwok\n", fw.String())
+	}
+
+	_, err := io.Copy(s.Writer(&flakyWriter{max: 3, budget: 5}), strings.NewReader("hunter2 hunter2"))
+	assert.Equal(t, errBroken, err)
+}